@@ -45,13 +45,12 @@ func compare(source *media.DcmObj, destination *media.DcmObj) {
 	for _, st := range source.GetTags() {
 		found := false
 		if st.VR == "SQ" {
-			sSeq, _ := st.ReadSeq(source.IsExplicitVR())
 			dt := destination.GetTagGE(st.Group, st.Element)
 			if dt == nil {
 				log.Printf("Sequence: (%04X,%04X) %s not found in destination", st.Group, st.Element, st.Name)
+				continue
 			}
-			dSeq, _ := dt.ReadSeq(destination.IsExplicitVR())
-			compareSeq(1, sSeq, dSeq)
+			compareItems(1, st, dt)
 			continue
 		}
 		for _, dt := range destination.GetTags() {
@@ -81,6 +80,17 @@ func compare(source *media.DcmObj, destination *media.DcmObj) {
 	}
 }
 
+// compareItems - compares the items of two sequences, one by one
+func compareItems(indent int, source *media.DcmTag, destination *media.DcmTag) {
+	sItems, dItems := source.GetItems(), destination.GetItems()
+	if len(sItems) != len(dItems) {
+		log.Printf("Sequence: (%04X,%04X) %s has %d items, destination: %d", source.Group, source.Element, source.Name, len(sItems), len(dItems))
+	}
+	for i := 0; i < len(sItems) && i < len(dItems); i++ {
+		compareSeq(indent, sItems[i], dItems[i])
+	}
+}
+
 func compareSeq(indent int, source *media.DcmObj, destination *media.DcmObj) {
 	tabs := "\t"
 	for i := 0; i < indent; i++ {
@@ -90,13 +100,12 @@ func compareSeq(indent int, source *media.DcmObj, destination *media.DcmObj) {
 	for _, st := range source.GetTags() {
 		found := false
 		if st.VR == "SQ" {
-			sSeq, _ := st.ReadSeq(source.IsExplicitVR())
 			dt := destination.GetTagGE(st.Group, st.Element)
 			if dt == nil {
 				log.Printf("%sSequence: (%04X,%04X) %s not found in destination", tabs, st.Group, st.Element, st.Name)
+				continue
 			}
-			dSeq, _ := dt.ReadSeq(destination.IsExplicitVR())
-			compareSeq(indent+1, sSeq, dSeq)
+			compareItems(indent+1, st, dt)
 			continue
 		}
 		for _, dt := range destination.GetTags() {
@@ -1,12 +1,18 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
+	"net"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/t2care/obd-dicom/dictionary/tags"
 	"github.com/t2care/obd-dicom/dictionary/transfersyntax"
@@ -100,10 +106,25 @@ func main() {
 			return dicomstatus.Success
 		})
 
+		// Associations in progress are given 30 s to complete on SIGTERM, eg. during a rolling deploy
+		shutdown := make(chan struct{})
+		go func() {
+			signals := make(chan os.Signal, 1)
+			signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+			<-signals
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+			if err := scp.Shutdown(ctx); err != nil {
+				log.Printf("WARNING: associations aborted at shutdown: %s", err.Error())
+			}
+			close(shutdown)
+		}()
+
 		err := scp.Start()
-		if err != nil {
+		if !errors.Is(err, net.ErrClosed) {
 			log.Fatal(err)
 		}
+		<-shutdown
 		os.Exit(0)
 	}
 
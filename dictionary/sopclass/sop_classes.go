@@ -32,6 +32,14 @@ var StorageCommitmentPushModel = &SOPClass{
 	Type:        "SOP Class",
 }
 
+// StorageCommitmentPushModelInstance - (1.2.840.10008.1.20.1.1) Storage Commitment Push Model SOP Instance
+var StorageCommitmentPushModelInstance = &SOPClass{
+	UID:         "1.2.840.10008.1.20.1.1",
+	Name:        "StorageCommitmentPushModelInstance",
+	Description: "Storage Commitment Push Model SOP Instance",
+	Type:        "Well-known SOP Instance",
+}
+
 // StorageCommitmentPullModel - (1.2.840.10008.1.20.2) Storage Commitment Pull Model SOP Class (Retired)
 var StorageCommitmentPullModel = &SOPClass{
 	UID:         "1.2.840.10008.1.20.2",
@@ -2389,6 +2397,7 @@ var sopClasses = []*SOPClass{
 	MediaStorageDirectoryStorage,
 	BasicStudyContentNotification,
 	StorageCommitmentPushModel,
+	StorageCommitmentPushModelInstance,
 	StorageCommitmentPullModel,
 	ProceduralEventLogging,
 	SubstanceAdministrationLogging,
@@ -10,6 +10,7 @@ type TransferSyntax struct {
 var SupportedTransferSyntaxes = []*TransferSyntax{
 	ImplicitVRLittleEndian,
 	ExplicitVRLittleEndian,
+	DeflatedExplicitVRLittleEndian,
 	ExplicitVRBigEndian,
 }
 
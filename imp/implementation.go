@@ -1,56 +1,67 @@
 package imp
 
+import "sync"
+
+const (
+	defaultClassUID = "1.2.826.0.1.3680043.10.90.999"
+	defaultVersion  = "OBD-Dicom"
+)
+
+// implementation - never modified once created, it is shared by the associations
 type implementation struct {
 	classUID string
 	version  string
 }
 
-var imp *implementation
+var (
+	mu  sync.RWMutex
+	imp *implementation
+)
 
 func SetDefaultImplementation() *implementation {
-	imp = &implementation{
-		classUID: "1.2.826.0.1.3680043.10.90.999",
-		version:  "OBD-Dicom",
-	}
-	return imp
+	return SetImplementation(defaultClassUID, defaultVersion)
 }
 
+// SetImplementation - Implementation of the associations opened from now on, unless set on the SCU or SCP
 func SetImplementation(classUID string, version string) *implementation {
-	imp = &implementation{
+	i := &implementation{
 		classUID: classUID,
 		version:  version,
 	}
-	return imp
+	mu.Lock()
+	defer mu.Unlock()
+	imp = i
+	return i
 }
 
-func GetImpClassUID() string {
+// current - the implementation set, the default one when none was
+func current() *implementation {
+	mu.RLock()
+	defer mu.RUnlock()
 	if imp == nil {
-		SetDefaultImplementation()
+		return &implementation{classUID: defaultClassUID, version: defaultVersion}
 	}
-	return imp.GetClassUID()
+	return imp
+}
+
+func GetImpClassUID() string {
+	return current().GetClassUID()
 }
 
 func GetImpVersion() string {
-	if imp == nil {
-		SetDefaultImplementation()
-	}
-	return imp.GetVersion()
+	return current().GetVersion()
 }
 
 func (i *implementation) GetClassUID() string {
 	if i.classUID == "" {
-		imp := SetDefaultImplementation()
-		i.classUID = imp.GetClassUID()
-		i.version = imp.GetVersion()
+		return defaultClassUID
 	}
 	return i.classUID
 }
 
 func (i *implementation) GetVersion() string {
 	if i.classUID == "" {
-		imp := SetDefaultImplementation()
-		i.classUID = imp.GetClassUID()
-		i.version = imp.GetVersion()
+		return defaultVersion
 	}
 	return i.version
 }
@@ -2,9 +2,13 @@ package media
 
 import (
 	"bufio"
+	"bytes"
+	"compress/flate"
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"io"
+	"slices"
 
 	"github.com/t2care/obd-dicom/dictionary/transfersyntax"
 )
@@ -171,8 +175,9 @@ func (bd *BufData) ReadTag(explicitVR bool, opt ...*ParseOptions) (*DcmTag, erro
 		return nil, err
 	}
 	tag := &DcmTag{
-		Group:   group,
-		Element: element,
+		Group:     group,
+		Element:   element,
+		BigEndian: bd.IsBigEndian(),
 	}
 
 	internalVR := explicitVR
@@ -183,7 +188,7 @@ func (bd *BufData) ReadTag(explicitVR bool, opt ...*ParseOptions) (*DcmTag, erro
 
 	if (tag.Group != 0x0000) && (tag.Group != 0xfffe) && (internalVR) {
 		tag.VR = bd.readString(2)
-		if (tag.VR == "OB") || (tag.VR == "OW") || (tag.VR == "SQ") || (tag.VR == "UN") || (tag.VR == "UT") {
+		if hasLongLength(tag.VR) {
 			_, err := bd.ReadUint16()
 			if err != nil {
 				return nil, err
@@ -228,6 +233,10 @@ func (bd *BufData) ReadTag(explicitVR bool, opt ...*ParseOptions) (*DcmTag, erro
 
 // WriteTag - Write a single tag to stream
 func (bd *BufData) WriteTag(tag *DcmTag, explicitVR bool) {
+	if tag.Items != nil {
+		bd.writeSeq(tag, explicitVR)
+		return
+	}
 	bd.WriteUint16(tag.Group)
 	bd.WriteUint16(tag.Element)
 	// If the byte length is not even, append 1 padding byte to make it even.
@@ -242,7 +251,7 @@ func (bd *BufData) WriteTag(tag *DcmTag, explicitVR bool) {
 			tag.VR = getDictionaryVR(tag.Group, tag.Element)
 		}
 		bd.MS.Write([]byte(tag.VR), 2)
-		if (tag.VR == "OB") || (tag.VR == "OW") || (tag.VR == "SQ") || (tag.VR == "UN") || (tag.VR == "UT") {
+		if hasLongLength(tag.VR) {
 			bd.WriteUint16(0)
 			bd.WriteUint32(tag.Length)
 		} else {
@@ -294,17 +303,23 @@ func (bd *BufData) ReadMeta() (*transfersyntax.TransferSyntax, error) {
 		return nil, err
 	}
 	if string(bs[:4]) == "DICM" {
-		fin := false
-		for (pos < bd.GetSize()) && (!fin) {
+		for bd.GetPosition() < bd.GetSize() {
 			pos = bd.GetPosition()
-			tag, _ := bd.ReadTag(true)
+			// Only the group of the first tag of the dataset is read, it may be deflated
+			group, err := bd.ReadUint16()
+			if err != nil || group > 0x02 {
+				break
+			}
+			bd.SetPosition(pos)
+			tag, err := bd.ReadTag(true)
+			if err != nil {
+				return nil, err
+			}
 			if (tag.Group == 0x02) && (tag.Element == 0x010) {
 				uid := tag.getString()
 				TransferSyntax = transfersyntax.GetTransferSyntaxFromUID(uid)
 			}
-			if tag.Group > 0x02 {
-				fin = true
-			}
+			pos = bd.GetPosition()
 		}
 	}
 	bd.SetPosition(pos)
@@ -357,8 +372,19 @@ func (bd *BufData) WriteMeta(SOPClassUID string, SOPInstanceUID string, Transfer
 
 // ReadObj - Read a DICOM Object from a BufData
 func (bd *BufData) ReadObj(obj *DcmObj, opt ...*ParseOptions) error {
-	isExplicitVR := obj.IsExplicitVR()
 	obj.Size = bd.GetSize()
+	if obj.GetTransferSyntax() == transfersyntax.DeflatedExplicitVRLittleEndian {
+		if err := bd.inflate(); err != nil {
+			return err
+		}
+	}
+	return bd.readTags(obj, opt...)
+}
+
+// readTags - reads the tags of obj, the items of the sequences included, until the end of the stream
+// or the item delimitation of an undefined length item
+func (bd *BufData) readTags(obj *DcmObj, opt ...*ParseOptions) error {
+	isExplicitVR := obj.IsExplicitVR()
 	for bd.GetPosition() < bd.GetSize() {
 		tag, err := bd.ReadTag(isExplicitVR, opt...)
 		if err != nil {
@@ -367,14 +393,104 @@ func (bd *BufData) ReadObj(obj *DcmObj, opt ...*ParseOptions) error {
 		if tag == nil {
 			return nil
 		}
+		if obj.UndefinedLength && tag.Group == 0xFFFE && tag.Element == 0xE00D {
+			return nil
+		}
 		if !isExplicitVR {
 			tag.VR = getDictionaryVR(tag.Group, tag.Element)
 		}
 		if tag.Length%2 != 0 && tag.VR != "SQ" && tag.Length != 0xffffffff {
 			return fmt.Errorf("%s is odd", tag.Name)
 		}
+		switch {
+		case tag.VR == "SQ":
+			if err := bd.readItems(tag, isExplicitVR); err != nil {
+				return err
+			}
+		case tag.VR == "UN" && tag.Length == 0xFFFFFFFF:
+			if err := bd.readUNItems(tag, isExplicitVR); err != nil {
+				return err
+			}
+		}
 		obj.Add(tag)
 	}
+	if obj.UndefinedLength {
+		return errors.New("DcmObj::Read, item delimitation not found")
+	}
+	return nil
+}
+
+// readItems - parses the items of a sequence tag, from its data when its length is defined,
+// from the stream until the sequence delimitation otherwise
+func (bd *BufData) readItems(sq *DcmTag, explicitVR bool) error {
+	undefined := sq.Length == 0xFFFFFFFF
+	src := bd
+	if !undefined {
+		src = &BufData{BigEndian: bd.BigEndian, MS: NewMemoryStreamFromBytes(sq.Data)}
+	}
+	sq.Items = make([]*DcmObj, 0)
+	sq.Data = nil
+	for src.GetPosition() < src.GetSize() {
+		group, err := src.ReadUint16()
+		if err != nil {
+			return err
+		}
+		element, err := src.ReadUint16()
+		if err != nil {
+			return err
+		}
+		length, err := src.ReadUint32()
+		if err != nil {
+			return err
+		}
+		if group != 0xFFFE || (element != 0xE000 && element != 0xE0DD) {
+			return fmt.Errorf("DcmObj::Read, (%04X,%04X) found in sequence (%04X,%04X)", group, element, sq.Group, sq.Element)
+		}
+		if element == 0xE0DD {
+			return nil
+		}
+		item := NewEmptyDCMObj()
+		item.SetExplicitVR(explicitVR)
+		item.SetBigEndian(src.IsBigEndian())
+		if length == 0xFFFFFFFF {
+			item.UndefinedLength = true
+			if err := src.readTags(item); err != nil {
+				return err
+			}
+		} else {
+			data, err := src.Read(int(length))
+			if err != nil {
+				return err
+			}
+			if err := (&BufData{BigEndian: src.BigEndian, MS: NewMemoryStreamFromBytes(data)}).readTags(item); err != nil {
+				return err
+			}
+		}
+		sq.Items = append(sq.Items, item)
+	}
+	if undefined {
+		return fmt.Errorf("DcmObj::Read, sequence delimitation of (%04X,%04X) not found", sq.Group, sq.Element)
+	}
+	return nil
+}
+
+// readUNItems - parses an undefined length UN as a sequence of Implicit VR Little Endian items, whatever the
+// transfer syntax, PS3.5 6.2.2. The items are then converted to the encoding of the dataset
+func (bd *BufData) readUNItems(un *DcmTag, explicitVR bool) error {
+	bigEndian := bd.BigEndian
+	bd.BigEndian = false
+	err := bd.readItems(un, false)
+	bd.BigEndian = bigEndian
+	if err != nil {
+		return err
+	}
+	un.VR = "SQ"
+	for _, item := range un.Items {
+		item.SetExplicitVR(explicitVR)
+		if err := item.swapByteOrder(bigEndian); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -383,12 +499,99 @@ func (bd *BufData) WriteObj(obj *DcmObj) {
 	//	bd.BigEndian = BigEndian
 	// Si lo limpio elimino el meta!!
 	//	bd.MS.Clear()
+	if obj.GetTransferSyntax() == transfersyntax.DeflatedExplicitVRLittleEndian {
+		bd.deflate(obj)
+		return
+	}
 	for i := 0; i < obj.TagCount(); i++ {
 		tag := obj.GetTagAt(i)
 		bd.WriteTag(tag, obj.IsExplicitVR())
 	}
 }
 
+// writeSeq - writes a sequence tag and its items, with their defined or undefined lengths
+func (bd *BufData) writeSeq(tag *DcmTag, explicitVR bool) {
+	items := &BufData{BigEndian: bd.BigEndian, MS: NewEmptyMemoryStream()}
+	for _, item := range tag.Items {
+		items.writeItem(item, explicitVR)
+	}
+	seq := *tag
+	seq.Items = nil
+	seq.Data = items.GetAllBytes()
+	if tag.Length != 0xFFFFFFFF {
+		seq.Length = uint32(len(seq.Data))
+		tag.Length = seq.Length
+	}
+	bd.WriteTag(&seq, explicitVR)
+	if tag.Length == 0xFFFFFFFF {
+		bd.writeRaw(seq.Data)
+		bd.writeDelimitation(0xE0DD)
+	}
+}
+
+// writeItem - writes an item of a sequence, with its defined or undefined length
+func (bd *BufData) writeItem(item *DcmObj, explicitVR bool) {
+	content := &BufData{BigEndian: bd.BigEndian, MS: NewEmptyMemoryStream()}
+	for _, tag := range item.GetTags() {
+		content.WriteTag(tag, explicitVR)
+	}
+	bd.WriteUint16(0xFFFE)
+	bd.WriteUint16(0xE000)
+	if item.UndefinedLength {
+		bd.WriteUint32(0xFFFFFFFF)
+		bd.writeRaw(content.GetAllBytes())
+		bd.writeDelimitation(0xE00D)
+		return
+	}
+	bd.WriteUint32(uint32(content.GetSize()))
+	bd.writeRaw(content.GetAllBytes())
+}
+
+// writeDelimitation - item or sequence delimitation
+func (bd *BufData) writeDelimitation(element uint16) {
+	bd.WriteUint16(0xFFFE)
+	bd.WriteUint16(element)
+	bd.WriteUint32(0)
+}
+
+// writeRaw - writes already encoded bytes, if any
+func (bd *BufData) writeRaw(data []byte) {
+	if len(data) > 0 {
+		bd.MS.Write(data, len(data))
+	}
+}
+
+// inflate - replaces the deflated dataset, from the current position, by the inflated one
+func (bd *BufData) inflate() error {
+	position := bd.GetPosition()
+	data := bd.MS.GetData()[:bd.GetSize()]
+	inflated, err := io.ReadAll(flate.NewReader(bytes.NewReader(data[position:])))
+	if err != nil {
+		return fmt.Errorf("BufData::Inflate, %s", err.Error())
+	}
+	bd.MS = NewMemoryStreamFromBytes(append(slices.Clone(data[:position]), inflated...))
+	bd.SetPosition(position)
+	return nil
+}
+
+// deflate - writes the dataset deflated, padded to an even length
+func (bd *BufData) deflate(obj *DcmObj) {
+	dataset := NewEmptyBufData()
+	for _, tag := range obj.GetTags() {
+		dataset.WriteTag(tag, true)
+	}
+	var deflated bytes.Buffer
+	w, _ := flate.NewWriter(&deflated, flate.DefaultCompression)
+	w.Write(dataset.GetAllBytes())
+	w.Close()
+	if deflated.Len()%2 == 1 {
+		deflated.WriteByte(0x00)
+	}
+	if deflated.Len() > 0 {
+		bd.MS.Write(deflated.Bytes(), deflated.Len())
+	}
+}
+
 func (bd *BufData) Send(rw *bufio.ReadWriter) error {
 	bd.SetPosition(0)
 	buffer, _ := bd.MS.Read(bd.GetSize())
@@ -406,6 +609,15 @@ func (bd *BufData) GetAllBytes() []byte {
 	return bd.MS.GetData()
 }
 
+// hasLongLength - explicit VRs with a 32 bits length, PS3.5 7.1.2
+func hasLongLength(vr string) bool {
+	switch vr {
+	case "OB", "OD", "OF", "OL", "OV", "OW", "SQ", "SV", "UC", "UN", "UR", "UT", "UV":
+		return true
+	}
+	return false
+}
+
 func (bd *BufData) readString(length int) string {
 	temp, _ := bd.MS.Read(length)
 	return string(temp)
@@ -4,6 +4,7 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"strconv"
 	"strings"
@@ -16,12 +17,13 @@ import (
 )
 
 type DcmObj struct {
-	Tags           []*DcmTag
-	TransferSyntax *transfersyntax.TransferSyntax
-	ExplicitVR     bool
-	BigEndian      bool
-	SQtag          *DcmTag
-	Size           int // bytes
+	Tags            []*DcmTag
+	TransferSyntax  *transfersyntax.TransferSyntax
+	ExplicitVR      bool
+	BigEndian       bool
+	SQtag           *DcmTag
+	Size            int  // bytes
+	UndefinedLength bool // Item of a sequence written with an undefined length and an item delimitation
 }
 
 type ParseOptions struct {
@@ -64,9 +66,17 @@ func NewDCMObjFromBytes(data []byte) (*DcmObj, error) {
 	return parseBufData(NewBufDataFromBytes(data))
 }
 
-func parseBufData(bufdata *BufData, opt ...*ParseOptions) (*DcmObj, error) {
-	BigEndian := false
+// NewDCMObjFromReader - Read a DICOM stream into a DICOM Object, eg. an HTTP body.
+// The stream is read to its end and held in memory before it is parsed
+func NewDCMObjFromReader(r io.Reader, opt ...*ParseOptions) (*DcmObj, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("DcmObj::Read %s", err.Error())
+	}
+	return parseBufData(NewBufDataFromBytes(data), opt...)
+}
 
+func parseBufData(bufdata *BufData, opt ...*ParseOptions) (*DcmObj, error) {
 	transferSyntax, err := bufdata.ReadMeta()
 	if err != nil {
 		return nil, err
@@ -89,10 +99,11 @@ func parseBufData(bufdata *BufData, opt ...*ParseOptions) (*DcmObj, error) {
 	} else {
 		obj.ExplicitVR = true
 	}
+	// The meta header is always Explicit VR Little Endian
 	if obj.TransferSyntax == transfersyntax.ExplicitVRBigEndian {
-		BigEndian = true
+		obj.BigEndian = true
 	}
-	bufdata.SetBigEndian(BigEndian)
+	bufdata.SetBigEndian(obj.BigEndian)
 
 	if err := bufdata.ReadObj(obj, opt...); err != nil {
 		return nil, err
@@ -105,8 +116,14 @@ func (obj *DcmObj) IsExplicitVR() bool {
 	return obj.ExplicitVR
 }
 
+// SetExplicitVR - also of the items of the sequences, encoded the same
 func (obj *DcmObj) SetExplicitVR(explicit bool) {
 	obj.ExplicitVR = explicit
+	for _, tag := range obj.Tags {
+		for _, item := range tag.Items {
+			item.SetExplicitVR(explicit)
+		}
+	}
 }
 
 func (obj *DcmObj) IsBigEndian() bool {
@@ -167,32 +184,12 @@ func (obj *DcmObj) DelTag(i int) {
 }
 
 func (obj *DcmObj) DumpTags() error {
-	for _, tag := range obj.Tags {
-		if tag.VR == "SQ" {
-			fmt.Printf("\t(%04X,%04X) %s - %s\n", tag.Group, tag.Element, tag.VR, tag.Description)
-			seq, err := tag.ReadSeq(obj.IsExplicitVR())
-			if err != nil {
-				return err
-			}
-			seq.dumpSeq(1)
-			continue
-		}
-		if tag.Length > 128 {
-			fmt.Printf("\t(%04X,%04X) %s - %s : (Not displayed)\n", tag.Group, tag.Element, tag.VR, tag.Description)
-			continue
-		}
-		switch tag.VR {
-		case "US":
-			fmt.Printf("\t(%04X,%04X) %s - %s : %d\n", tag.Group, tag.Element, tag.VR, tag.Description, binary.LittleEndian.Uint16(tag.Data))
-		default:
-			fmt.Printf("\t(%04X,%04X) %s - %s : %s\n", tag.Group, tag.Element, tag.VR, tag.Description, tag.Data)
-		}
-	}
+	obj.dumpSeq(0)
 	fmt.Println()
 	return nil
 }
 
-func (obj *DcmObj) dumpSeq(indent int) error {
+func (obj *DcmObj) dumpSeq(indent int) {
 	tabs := "\t"
 	for i := 0; i < indent; i++ {
 		tabs += "\t"
@@ -201,25 +198,23 @@ func (obj *DcmObj) dumpSeq(indent int) error {
 	for _, tag := range obj.Tags {
 		if tag.isSequence() {
 			fmt.Printf("%s(%04X,%04X) %s - %s\n", tabs, tag.Group, tag.Element, tag.VR, tag.Description)
-			seq, err := tag.ReadSeq(obj.IsExplicitVR())
-			if err != nil {
-				return err
+			for n, item := range tag.Items {
+				fmt.Printf("%s\t(FFFE,E000) - Item %d\n", tabs, n+1)
+				item.dumpSeq(indent + 2)
 			}
-			seq.dumpSeq(indent + 1)
 			continue
 		}
 		if tag.Length > 128 {
 			fmt.Printf("%s(%04X,%04X) %s - %s : (Not displayed)\n", tabs, tag.Group, tag.Element, tag.VR, tag.Description)
 			continue
 		}
-		switch tag.VR {
-		case "US":
-			fmt.Printf("%s(%04X,%04X) %s - %s : %d\n", tabs, tag.Group, tag.Element, tag.VR, tag.Description, binary.LittleEndian.Uint16(tag.Data))
+		switch {
+		case tag.VR == "US" && tag.Length >= 2:
+			fmt.Printf("%s(%04X,%04X) %s - %s : %d\n", tabs, tag.Group, tag.Element, tag.VR, tag.Description, tag.byteOrder().Uint16(tag.Data))
 		default:
 			fmt.Printf("%s(%04X,%04X) %s - %s : %s\n", tabs, tag.Group, tag.Element, tag.VR, tag.Description, tag.Data)
 		}
 	}
-	return nil
 }
 
 func (obj *DcmObj) GetDate(tag *tags.Tag) time.Time {
@@ -266,7 +261,7 @@ func (obj *DcmObj) WriteToBytes() []byte {
 	SOPClassUID := obj.getStringGE(0x08, 0x16)
 	SOPInstanceUID := obj.getStringGE(0x08, 0x18)
 	bufdata.WriteMeta(SOPClassUID, SOPInstanceUID, obj.TransferSyntax.UID)
-	// Don't write metadata header in BigEndian (ReadMeta does not support )
+	// The meta header is always Explicit VR Little Endian, only the dataset is in Big Endian
 	if obj.TransferSyntax.UID == transfersyntax.ExplicitVRBigEndian.UID {
 		bufdata.SetBigEndian(true)
 	}
@@ -379,12 +374,26 @@ func (obj *DcmObj) writeStringGE(group uint16, element uint16, vr string, conten
 		Length:    uint32(length),
 		VR:        vr,
 		Data:      data,
-		BigEndian: false,
+		BigEndian: obj.BigEndian,
 	}
 	FillTag(tag)
 	obj.Tags = append(obj.Tags, tag)
 }
 
+// swapByteOrder - converts the binary values of the tags, also in sequences, to big or little endian
+func (obj *DcmObj) swapByteOrder(bigEndian bool) error {
+	if obj.IsBigEndian() == bigEndian {
+		return nil
+	}
+	for _, tag := range obj.Tags {
+		if err := tag.swapByteOrder(bigEndian); err != nil {
+			return err
+		}
+	}
+	obj.SetBigEndian(bigEndian)
+	return nil
+}
+
 func (obj *DcmObj) GetTransferSyntax() *transfersyntax.TransferSyntax {
 	return obj.TransferSyntax
 }
@@ -408,10 +417,8 @@ func (obj *DcmObj) GetPixelData(frame int) ([]byte, error) {
 	var i int
 	var rows, cols, bitsa, planar uint16
 	var PhotoInt string
-	sq := 0
 	frames := uint32(0)
 	RGB := false
-	icon := false
 
 	if !transfersyntax.SupportedTransferSyntax(obj.TransferSyntax.UID) {
 		return nil, fmt.Errorf("unsupported transfer synxtax %s", obj.TransferSyntax.Name)
@@ -419,86 +426,76 @@ func (obj *DcmObj) GetPixelData(frame int) ([]byte, error) {
 
 	for i = 0; i < len(obj.Tags); i++ {
 		tag := obj.GetTagAt(i)
-		if tag.isSequenceUndefined() {
-			sq++
-		}
-		if sq == 0 {
-			if (tag.Group == 0x0028) && (!icon) {
-				switch tag.Element {
-				case 0x04:
-					PhotoInt = tag.getString()
-					if !strings.Contains(PhotoInt, "MONO") {
-						RGB = true
-					}
-				case 0x06:
-					planar = tag.getUShort()
-				case 0x08:
-					uframes, err := strconv.Atoi(tag.getString())
-					if err != nil {
-						frames = 0
-					} else {
-						frames = uint32(uframes)
-					}
-				case 0x10:
-					rows = tag.getUShort()
-				case 0x11:
-					cols = tag.getUShort()
-				case 0x0100:
-					bitsa = tag.getUShort()
+		if tag.Group == 0x0028 {
+			switch tag.Element {
+			case 0x04:
+				PhotoInt = tag.getString()
+				if !strings.Contains(PhotoInt, "MONO") {
+					RGB = true
+				}
+			case 0x06:
+				planar = tag.getUShort()
+			case 0x08:
+				uframes, err := strconv.Atoi(tag.getString())
+				if err != nil {
+					frames = 0
+				} else {
+					frames = uint32(uframes)
 				}
+			case 0x10:
+				rows = tag.getUShort()
+			case 0x11:
+				cols = tag.getUShort()
+			case 0x0100:
+				bitsa = tag.getUShort()
 			}
-			if (tag.Group == 0x0088) && (tag.Element == 0x0200) && (tag.Length == 0xFFFFFFFF) {
-				icon = true
+		}
+		// The icon images are in their sequences
+		if (tag.Group == 0x7FE0) && (tag.Element == 0x0010) {
+			size := uint32(cols) * uint32(rows) * uint32(bitsa) / 8
+			if RGB {
+				size = 3 * size
 			}
-			if (tag.Group == 0x6003) && (tag.Element == 0x1010) && (tag.Length == 0xFFFFFFFF) {
-				icon = true
+			if frames > 0 {
+				size = uint32(frames) * size
+			} else {
+				frames = 1
+			}
+			if size == 0 {
+				return nil, errors.New("DcmObj::ConvertTransferSyntax, size=0")
 			}
-			if (tag.Group == 0x7FE0) && (tag.Element == 0x0010) && (!icon) {
-				size := uint32(cols) * uint32(rows) * uint32(bitsa) / 8
-				if RGB {
-					size = 3 * size
-				}
-				if frames > 0 {
-					size = uint32(frames) * size
-				} else {
-					frames = 1
-				}
-				if size == 0 {
-					return nil, errors.New("DcmObj::ConvertTransferSyntax, size=0")
-				}
 
-				if frame > int(frames) {
-					return nil, errors.New("invalid frame")
-				}
+			if frame > int(frames) {
+				return nil, errors.New("invalid frame")
+			}
 
-				if tag.Length == 0xFFFFFFFF {
-					return obj.GetTagAt(i + 2 + frame).Data, nil
-				} else {
-					if RGB && (planar == 1) {
-						var img_offset, img_size uint32
-						img_size = size / frames
-						img := make([]byte, img_size)
-						for f := uint32(0); f < frames; f++ {
-							img_offset = img_size * f
-							for j := uint32(0); j < img_size/3; j++ {
-								img[3*j] = tag.Data[j+img_offset]
-								img[3*j+1] = tag.Data[j+img_size/3+img_offset]
-								img[3*j+2] = tag.Data[j+2*img_size/3+img_offset]
-							}
-							if f == uint32(frame) {
-								return img, nil
-							}
+			if tag.Length == 0xFFFFFFFF {
+				return obj.GetTagAt(i + 2 + frame).Data, nil
+			} else {
+				if RGB && (planar == 1) {
+					var img_offset, img_size uint32
+					img_size = size / frames
+					img := make([]byte, img_size)
+					for f := uint32(0); f < frames; f++ {
+						img_offset = img_size * f
+						for j := uint32(0); j < img_size/3; j++ {
+							img[3*j] = tag.Data[j+img_offset]
+							img[3*j+1] = tag.Data[j+img_size/3+img_offset]
+							img[3*j+2] = tag.Data[j+2*img_size/3+img_offset]
+						}
+						if f == uint32(frame) {
+							return img, nil
 						}
-						planar = 0
-					} else {
-						return tag.Data, nil
 					}
+					planar = 0
+				} else if obj.IsBigEndian() {
+					// The pixels are returned in Little Endian
+					return swapBytes(tag.Data, binaryVRSize(singleVR(tag.VR))), nil
+				} else {
+					return tag.Data, nil
 				}
 			}
 		}
-		if tag.isSequenceEnd() {
-			sq--
-		}
 	}
 	return nil, fmt.Errorf("there was an error getting pixel data")
 }
@@ -509,10 +506,8 @@ func (obj *DcmObj) ChangeTransferSynx(outTS *transfersyntax.TransferSyntax) erro
 	var i int
 	var rows, cols, bitss, bitsa, planar uint16
 	var PhotoInt string
-	sq := 0
 	frames := uint32(0)
 	RGB := false
-	icon := false
 
 	if obj.TransferSyntax.UID == outTS.UID {
 		return nil
@@ -522,166 +517,223 @@ func (obj *DcmObj) ChangeTransferSynx(outTS *transfersyntax.TransferSyntax) erro
 		return fmt.Errorf("unsupported transfer synxtax %s", outTS.Name)
 	}
 
+	// Deflate only applies to the encoding of an Explicit VR Little Endian dataset, inflated once read
+	if outTS.UID == transfersyntax.DeflatedExplicitVRLittleEndian.UID {
+		if err := obj.ChangeTransferSynx(transfersyntax.ExplicitVRLittleEndian); err != nil {
+			return err
+		}
+		obj.SetTransferSyntax(outTS)
+		return nil
+	}
+	if obj.TransferSyntax.UID == transfersyntax.DeflatedExplicitVRLittleEndian.UID {
+		obj.SetTransferSyntax(transfersyntax.ExplicitVRLittleEndian)
+		if outTS.UID == transfersyntax.ExplicitVRLittleEndian.UID {
+			return nil
+		}
+	}
+
+	// Big Endian is converted from and to Explicit VR Little Endian, swapping the binary values
+	if obj.TransferSyntax.UID == transfersyntax.ExplicitVRBigEndian.UID {
+		if err := obj.swapByteOrder(false); err != nil {
+			return err
+		}
+		obj.SetTransferSyntax(transfersyntax.ExplicitVRLittleEndian)
+		if outTS.UID == transfersyntax.ExplicitVRLittleEndian.UID {
+			return nil
+		}
+	}
+	if outTS.UID == transfersyntax.ExplicitVRBigEndian.UID {
+		if err := obj.ChangeTransferSynx(transfersyntax.ExplicitVRLittleEndian); err != nil {
+			return err
+		}
+		if err := obj.swapByteOrder(true); err != nil {
+			return err
+		}
+		obj.SetTransferSyntax(outTS)
+		return nil
+	}
+
 	for i = 0; i < len(obj.Tags); i++ {
 		tag := obj.GetTagAt(i)
-		if tag.isSequence() {
-			if tag.Length == 0xFFFFFFFF {
-				sq++
-			} else {
-				if err := tag.transcode(obj.IsExplicitVR(), outTS); err != nil {
-					return err
+		if tag.Group == 0x0028 {
+			switch tag.Element {
+			case 0x04:
+				PhotoInt = tag.getString()
+				if !strings.Contains(PhotoInt, "MONO") {
+					RGB = true
 				}
-				flag = true
+			case 0x06:
+				planar = tag.getUShort()
+			case 0x08:
+				uframes, err := strconv.Atoi(tag.getString())
+				if err != nil {
+					frames = 0
+				} else {
+					frames = uint32(uframes)
+				}
+			case 0x10:
+				rows = tag.getUShort()
+			case 0x11:
+				cols = tag.getUShort()
+			case 0x0100:
+				bitsa = tag.getUShort()
+			case 0x0101:
+				bitss = tag.getUShort()
 			}
 		}
-		if sq == 0 {
-			if (tag.Group == 0x0028) && (!icon) {
-				switch tag.Element {
-				case 0x04:
-					PhotoInt = tag.getString()
-					if !strings.Contains(PhotoInt, "MONO") {
-						RGB = true
-					}
-				case 0x06:
-					planar = tag.getUShort()
-				case 0x08:
-					uframes, err := strconv.Atoi(tag.getString())
-					if err != nil {
-						frames = 0
-					} else {
-						frames = uint32(uframes)
-					}
-				case 0x10:
-					rows = tag.getUShort()
-				case 0x11:
-					cols = tag.getUShort()
-				case 0x0100:
-					bitsa = tag.getUShort()
-				case 0x0101:
-					bitss = tag.getUShort()
-				}
+		// The icon images are in their sequences
+		if (tag.Group == 0x7FE0) && (tag.Element == 0x0010) {
+			size := uint32(cols) * uint32(rows) * uint32(bitsa) / 8
+			if RGB {
+				size = 3 * size
 			}
-			if (tag.Group == 0x0088) && (tag.Element == 0x0200) && (tag.Length == 0xFFFFFFFF) {
-				icon = true
+			if frames > 0 {
+				size = uint32(frames) * size
+			} else {
+				frames = 1
 			}
-			if (tag.Group == 0x6003) && (tag.Element == 0x1010) && (tag.Length == 0xFFFFFFFF) {
-				icon = true
+			if size == 0 {
+				return errors.New("DcmObj::ConvertTransferSyntax, size=0")
 			}
-			if (tag.Group == 0x7FE0) && (tag.Element == 0x0010) && (!icon) {
-				size := uint32(cols) * uint32(rows) * uint32(bitsa) / 8
-				if RGB {
-					size = 3 * size
-				}
-				if frames > 0 {
-					size = uint32(frames) * size
-				} else {
-					frames = 1
-				}
-				if size == 0 {
-					return errors.New("DcmObj::ConvertTransferSyntax, size=0")
-				}
-				img := make([]byte, size)
-				if tag.Length == 0xFFFFFFFF {
-					obj.uncompress(i, img, size, frames, bitsa, PhotoInt)
-				} else { // Uncompressed
-					if RGB && (planar == 1) { // change from planar=1 to planar=0
-						var img_offset, img_size uint32
-						img_size = size / frames
-						for f := uint32(0); f < frames; f++ {
-							img_offset = img_size * f
-							for j := uint32(0); j < img_size/3; j++ {
-								img[3*j+img_offset] = tag.Data[j+img_offset]
-								img[3*j+1+img_offset] = tag.Data[j+img_size/3+img_offset]
-								img[3*j+2+img_offset] = tag.Data[j+2*img_size/3+img_offset]
-							}
+			img := make([]byte, size)
+			if tag.Length == 0xFFFFFFFF {
+				obj.uncompress(i, img, size, frames, bitsa, PhotoInt)
+			} else { // Uncompressed
+				if RGB && (planar == 1) { // change from planar=1 to planar=0
+					var img_offset, img_size uint32
+					img_size = size / frames
+					for f := uint32(0); f < frames; f++ {
+						img_offset = img_size * f
+						for j := uint32(0); j < img_size/3; j++ {
+							img[3*j+img_offset] = tag.Data[j+img_offset]
+							img[3*j+1+img_offset] = tag.Data[j+img_size/3+img_offset]
+							img[3*j+2+img_offset] = tag.Data[j+2*img_size/3+img_offset]
 						}
-						planar = 0
-					} else {
-						copy(img, tag.Data)
 					}
-				}
-				if err := obj.compress(&i, img, RGB, cols, rows, bitss, bitsa, frames, outTS); err != nil {
-					return err
+					planar = 0
 				} else {
-					flag = true
+					copy(img, tag.Data)
 				}
 			}
-		}
-		if tag.isSequenceEnd() {
-			sq--
+			if err := obj.compress(&i, img, RGB, cols, rows, bitss, bitsa, frames, outTS); err != nil {
+				return err
+			} else {
+				flag = true
+			}
 		}
 	}
-	if flag {
+	// Without pixel data, eg. SR, the tags are only encoded differently
+	if flag || obj.GetTagGE(0x7FE0, 0x0010) == nil {
 		obj.SetTransferSyntax(outTS)
 		return nil
 	}
 	return fmt.Errorf("there was an error changing the transfer synxtax")
 }
 
-// AddConceptNameSeq - Concept Name Sequence for DICOM SR
-func (obj *DcmObj) AddConceptNameSeq(group uint16, element uint16, CodeValue string, CodeMeaning string) {
-	item := &DcmObj{
-		Tags:           make([]*DcmTag, 0),
-		TransferSyntax: nil,
-		ExplicitVR:     false,
-		BigEndian:      false,
-		SQtag:          new(DcmTag),
+// WriteSeq - Add or replace a sequence tag holding the items
+func (obj *DcmObj) WriteSeq(tag *tags.Tag, items []*DcmObj) {
+	sq := &DcmTag{
+		Group:     tag.Group,
+		Element:   tag.Element,
+		VR:        "SQ",
+		BigEndian: obj.IsBigEndian(),
+		Items:     make([]*DcmObj, 0),
 	}
-	seq := &DcmObj{
-		Tags:           make([]*DcmTag, 0),
-		TransferSyntax: nil,
-		ExplicitVR:     false,
-		BigEndian:      false,
-		SQtag:          new(DcmTag),
+	for _, item := range items {
+		item.SetExplicitVR(obj.IsExplicitVR())
+		// Items built in the other byte order are converted
+		sq.AddItem(item)
+	}
+	FillTag(sq)
+	for i, t := range obj.Tags {
+		if t.Group == tag.Group && t.Element == tag.Element {
+			obj.Tags[i] = sq
+			return
+		}
+	}
+	obj.Add(sq)
+}
+
+// GetSeq - return the items of a sequence tag, with defined or undefined length
+func (obj *DcmObj) GetSeq(tag *tags.Tag) ([]*DcmObj, error) {
+	t := obj.GetTag(tag)
+	if t == nil {
+		return nil, nil
+	}
+	if t.Items == nil && len(t.Data) > 0 && t.Length != 0xFFFFFFFF {
+		// Sequence added with its encoded items
+		if err := (&BufData{BigEndian: t.BigEndian}).readItems(t, obj.IsExplicitVR()); err != nil {
+			return nil, err
+		}
+	}
+	return t.getItems(), nil
+}
+
+// AddSeqItem - appends an item to a sequence tag, added when missing
+func (obj *DcmObj) AddSeqItem(tag *tags.Tag, item *DcmObj) error {
+	items, err := obj.GetSeq(tag)
+	if err != nil {
+		return err
+	}
+	if items == nil {
+		obj.WriteSeq(tag, []*DcmObj{item})
+		return nil
+	}
+	item.SetExplicitVR(obj.IsExplicitVR())
+	obj.GetTag(tag).AddItem(item)
+	return nil
+}
+
+// RemoveSeqItem - removes the item at index from a sequence tag
+func (obj *DcmObj) RemoveSeqItem(tag *tags.Tag, index int) error {
+	if _, err := obj.GetSeq(tag); err != nil {
+		return err
+	}
+	t := obj.GetTag(tag)
+	if t == nil {
+		return fmt.Errorf("DcmObj::RemoveSeqItem, (%04X,%04X) not found", tag.Group, tag.Element)
+	}
+	return t.RemoveItem(index)
+}
+
+// SetUndefinedLengths - all the sequences and items, nested ones included, are written with undefined lengths
+// and delimitations, or with their lengths
+func (obj *DcmObj) SetUndefinedLengths(undefined bool) {
+	for _, tag := range obj.Tags {
+		if !tag.isSequence() {
+			continue
+		}
+		tag.SetUndefinedLength(undefined)
+		for _, item := range tag.Items {
+			item.UndefinedLength = undefined
+			item.SetUndefinedLengths(undefined)
+		}
 	}
-	tag := new(DcmTag)
+}
 
-	item.BigEndian = obj.BigEndian
-	item.ExplicitVR = obj.ExplicitVR
-	seq.BigEndian = obj.BigEndian
-	seq.ExplicitVR = obj.ExplicitVR
+// AddConceptNameSeq - Concept Name Sequence for DICOM SR
+func (obj *DcmObj) AddConceptNameSeq(group uint16, element uint16, CodeValue string, CodeMeaning string) {
+	item := NewEmptyDCMObj()
+	item.SetBigEndian(obj.IsBigEndian())
+	item.SetExplicitVR(obj.IsExplicitVR())
 
 	item.WriteString(tags.CodeValue, CodeValue)
 	item.WriteString(tags.CodingSchemeDesignator, "odb")
 	item.WriteString(tags.CodeMeaning, CodeMeaning)
-	tag.writeItem(item)
-	seq.Add(tag)
-	tag = new(DcmTag)
-	tag.writeSeq(group, element, seq)
-	obj.Add(tag)
+	obj.WriteSeq(&tags.Tag{Group: group, Element: element}, []*DcmObj{item})
 }
 
 // AddSRText - add Text to SR
 func (obj *DcmObj) AddSRText(text string) {
-	item := &DcmObj{
-		Tags:           make([]*DcmTag, 0),
-		TransferSyntax: nil,
-		ExplicitVR:     false,
-		BigEndian:      false,
-		SQtag:          new(DcmTag),
-	}
-	seq := &DcmObj{
-		Tags:           make([]*DcmTag, 0),
-		TransferSyntax: nil,
-		ExplicitVR:     false,
-		BigEndian:      false,
-		SQtag:          new(DcmTag),
-	}
-	tag := new(DcmTag)
-
-	item.BigEndian = obj.BigEndian
-	item.ExplicitVR = obj.ExplicitVR
-	seq.BigEndian = obj.BigEndian
-	seq.ExplicitVR = obj.ExplicitVR
+	item := NewEmptyDCMObj()
+	item.SetBigEndian(obj.IsBigEndian())
+	item.SetExplicitVR(obj.IsExplicitVR())
 
 	item.WriteString(tags.RelationshipType, "CONTAINS")
 	item.WriteString(tags.ValueType, "TEXT")
 	item.AddConceptNameSeq(0x40, 0xA043, "2222", "Report Text")
 	item.WriteString(tags.TextValue, text)
-	tag.writeSeq(0xFFFE, 0xE000, item)
-	seq.Add(tag)
-	tag.writeSeq(0x40, 0xA730, seq)
-	obj.Add(tag)
+	obj.WriteSeq(tags.ContentSequence, []*DcmObj{item})
 }
 
 // CreateSR - Create a DICOM SR object
@@ -1,12 +1,15 @@
 package media
 
 import (
+	"encoding/binary"
 	"fmt"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/t2care/obd-dicom/dictionary/sopclass"
 	"github.com/t2care/obd-dicom/dictionary/tags"
 	"github.com/t2care/obd-dicom/dictionary/transfersyntax"
 )
@@ -26,19 +29,19 @@ func TestNewDCMObjFromFile(t *testing.T) {
 		{
 			name:          "Should load DICOM file from bugged DICOM written by us",
 			args:          args{fileName: "../samples/test2-2.dcm"},
-			wantTagsCount: 116,
+			wantTagsCount: 80,
 			wantErr:       false,
 		},
 		{
 			name:          "Should load DICOM file from post bugged DICOM written by us",
 			args:          args{fileName: "../samples/test2-3.dcm"},
-			wantTagsCount: 116,
+			wantTagsCount: 80,
 			wantErr:       false,
 		},
 		{
 			name:          "Should load DICOM file",
 			args:          args{fileName: "../samples/test2.dcm"},
-			wantTagsCount: 116,
+			wantTagsCount: 80,
 			wantErr:       false,
 		},
 		{
@@ -86,14 +89,15 @@ func TestChangeTransferSynx(t *testing.T) {
 			fileName: "../samples/test-losslessSV1.dcm",
 		},
 	}
+	out := filepath.Join(t.TempDir(), "tmp.dcm")
 	for _, tt := range tests {
 		for _, ts := range transfersyntax.SupportedTransferSyntaxes {
-			assert.NoError(t, changeSyntax(tt.fileName, ts), fmt.Sprintf("%s to %s", tt.name, ts.Name))
+			assert.NoError(t, changeSyntax(tt.fileName, out, ts), fmt.Sprintf("%s to %s", tt.name, ts.Name))
 		}
 	}
 }
 
-func changeSyntax(filename string, ts *transfersyntax.TransferSyntax) (err error) {
+func changeSyntax(filename string, out string, ts *transfersyntax.TransferSyntax) (err error) {
 	dcmObj, err := NewDCMObjFromFile(filename)
 	if err != nil {
 		return
@@ -101,7 +105,6 @@ func changeSyntax(filename string, ts *transfersyntax.TransferSyntax) (err error
 	if err = dcmObj.ChangeTransferSynx(ts); err != nil {
 		return
 	}
-	out := "tmp"
 	if err = dcmObj.WriteToFile(out); err != nil {
 		return
 	}
@@ -267,3 +270,212 @@ func Test_WriteString(t *testing.T) {
 		assert.Equal(t, tt.newValue, o.GetString(tt.tag), tt.name)
 	}
 }
+
+func TestDeflatedExplicitVRLittleEndian(t *testing.T) {
+	for _, fileName := range []string{"../samples/test2.dcm", "../samples/test-losslessSV1.dcm"} {
+		t.Run(fileName, func(t *testing.T) {
+			dcmObj, err := NewDCMObjFromFile(fileName)
+			if !assert.NoError(t, err) {
+				return
+			}
+			assert.NoError(t, dcmObj.ChangeTransferSynx(transfersyntax.ExplicitVRLittleEndian))
+			explicit := dcmObj.WriteToBytes()
+			assert.NoError(t, dcmObj.ChangeTransferSynx(transfersyntax.DeflatedExplicitVRLittleEndian))
+			deflated := dcmObj.WriteToBytes()
+			assert.Less(t, len(deflated), len(explicit))
+
+			inflated, err := NewDCMObjFromBytes(deflated)
+			if !assert.NoError(t, err) {
+				return
+			}
+			assert.Equal(t, transfersyntax.DeflatedExplicitVRLittleEndian, inflated.GetTransferSyntax())
+			assert.Equal(t, dcmObj.TagCount(), inflated.TagCount())
+			assert.Equal(t, dcmObj.GetString(tags.PatientName), inflated.GetString(tags.PatientName))
+
+			assert.NoError(t, inflated.ChangeTransferSynx(transfersyntax.ExplicitVRLittleEndian))
+			assert.Equal(t, explicit, inflated.WriteToBytes())
+		})
+	}
+}
+
+func TestExplicitVRBigEndian(t *testing.T) {
+	for _, fileName := range []string{"../samples/test.dcm", "../samples/test2.dcm"} {
+		t.Run(fileName, func(t *testing.T) {
+			dcmObj, err := NewDCMObjFromFile(fileName)
+			if !assert.NoError(t, err) {
+				return
+			}
+			assert.NoError(t, dcmObj.ChangeTransferSynx(transfersyntax.ExplicitVRLittleEndian))
+			explicit := dcmObj.WriteToBytes()
+			want, err := NewJSONObjFromDcmObj(dcmObj)
+			assert.NoError(t, err)
+			pixels, err := dcmObj.GetPixelData(0)
+			assert.NoError(t, err)
+
+			assert.NoError(t, dcmObj.ChangeTransferSynx(transfersyntax.ExplicitVRBigEndian))
+			bigEndian, err := NewDCMObjFromBytes(dcmObj.WriteToBytes())
+			if !assert.NoError(t, err) {
+				return
+			}
+			assert.True(t, bigEndian.IsBigEndian())
+			assert.Equal(t, dcmObj.GetUShort(tags.Rows), bigEndian.GetUShort(tags.Rows))
+			bigPixels, err := bigEndian.GetPixelData(0)
+			assert.NoError(t, err)
+			assert.Equal(t, pixels, bigPixels, "pixels in Little Endian")
+			// Every binary value, also in sequences, decoded the same
+			got, err := NewJSONObjFromDcmObj(bigEndian)
+			assert.NoError(t, err)
+			assert.Equal(t, want, got)
+
+			// And back, the lengths of the sequences and items are kept
+			assert.NoError(t, bigEndian.ChangeTransferSynx(transfersyntax.ExplicitVRLittleEndian))
+			littleEndian, err := NewDCMObjFromBytes(bigEndian.WriteToBytes())
+			if !assert.NoError(t, err) {
+				return
+			}
+			got, err = NewJSONObjFromDcmObj(littleEndian)
+			assert.NoError(t, err)
+			assert.Equal(t, want, got)
+			assert.Equal(t, explicit, littleEndian.WriteToBytes())
+		})
+	}
+}
+
+func TestSequenceRoundTrip(t *testing.T) {
+	for _, fileName := range []string{"../samples/test2.dcm", "../samples/test.dcm"} {
+		t.Run(fileName, func(t *testing.T) {
+			dcmObj, err := NewDCMObjFromFile(fileName)
+			if !assert.NoError(t, err) {
+				return
+			}
+			// The undefined and defined lengths of the sequences and items are kept
+			data, err := os.ReadFile(fileName)
+			assert.NoError(t, err)
+			assert.Equal(t, dataset(t, data), dataset(t, dcmObj.WriteToBytes()))
+		})
+	}
+}
+
+// dataset - the bytes following the meta header
+func dataset(t *testing.T, data []byte) []byte {
+	bufdata := NewBufDataFromBytes(data)
+	_, err := bufdata.ReadMeta()
+	assert.NoError(t, err)
+	return data[bufdata.GetPosition():]
+}
+
+func TestSequenceItems(t *testing.T) {
+	instance := func(uid string) *DcmObj {
+		item := NewEmptyDCMObj()
+		item.WriteString(tags.ReferencedSOPClassUID, sopclass.CTImageStorage.UID)
+		item.WriteString(tags.ReferencedSOPInstanceUID, uid)
+		return item
+	}
+	series := NewEmptyDCMObj()
+	series.WriteString(tags.SeriesInstanceUID, "1.2.3")
+	series.WriteSeq(tags.ReferencedInstanceSequence, []*DcmObj{instance("1.2.3.1"), instance("1.2.3.2")})
+
+	obj := NewEmptyDCMObj()
+	obj.SetTransferSyntax(transfersyntax.ExplicitVRLittleEndian)
+	obj.WriteString(tags.SOPInstanceUID, "1.2.3.4")
+	obj.WriteSeq(tags.ReferencedSeriesSequence, []*DcmObj{series})
+
+	// Items added, removed and edited in place, nested ones included
+	assert.NoError(t, obj.AddSeqItem(tags.ReferencedSeriesSequence, NewEmptyDCMObj()))
+	assert.NoError(t, obj.RemoveSeqItem(tags.ReferencedSeriesSequence, 1))
+	assert.Error(t, obj.RemoveSeqItem(tags.ReferencedSeriesSequence, 1))
+	items, err := obj.GetSeq(tags.ReferencedSeriesSequence)
+	if !assert.NoError(t, err) || !assert.Len(t, items, 1) {
+		return
+	}
+	instances, err := items[0].GetSeq(tags.ReferencedInstanceSequence)
+	if !assert.NoError(t, err) || !assert.Len(t, instances, 2) {
+		return
+	}
+	instances[1].WriteString(tags.ReferencedSOPInstanceUID, "1.2.3.3")
+	assert.NoError(t, items[0].AddSeqItem(tags.ReferencedInstanceSequence, instance("1.2.3.4")))
+
+	for _, ts := range []*transfersyntax.TransferSyntax{transfersyntax.ExplicitVRLittleEndian, transfersyntax.ImplicitVRLittleEndian, transfersyntax.ExplicitVRBigEndian} {
+		for _, undefined := range []bool{true, false} {
+			t.Run(fmt.Sprintf("%s undefined %t", ts.Name, undefined), func(t *testing.T) {
+				if !assert.NoError(t, obj.ChangeTransferSynx(ts)) {
+					return
+				}
+				obj.SetUndefinedLengths(undefined)
+				reread, err := NewDCMObjFromBytes(obj.WriteToBytes())
+				if !assert.NoError(t, err) {
+					return
+				}
+				sq := reread.GetTag(tags.ReferencedSeriesSequence)
+				if !assert.NotNil(t, sq) || !assert.Len(t, sq.GetItems(), 1) {
+					return
+				}
+				assert.Equal(t, undefined, sq.Length == 0xFFFFFFFF)
+				assert.Equal(t, undefined, sq.GetItems()[0].UndefinedLength)
+				assert.Equal(t, "1.2.3", sq.GetItems()[0].GetString(tags.SeriesInstanceUID))
+				instances, err := sq.GetItems()[0].GetSeq(tags.ReferencedInstanceSequence)
+				if !assert.NoError(t, err) || !assert.Len(t, instances, 3) {
+					return
+				}
+				assert.Equal(t, undefined, instances[2].UndefinedLength)
+				for i, uid := range []string{"1.2.3.1", "1.2.3.3", "1.2.3.4"} {
+					assert.Equal(t, uid, instances[i].GetString(tags.ReferencedSOPInstanceUID))
+				}
+				assert.Equal(t, obj.WriteToBytes(), reread.WriteToBytes())
+			})
+		}
+	}
+}
+
+func TestSequenceUN(t *testing.T) {
+	// Item of an undefined length UN, Implicit VR Little Endian whatever the transfer syntax
+	item := binary.LittleEndian.AppendUint32([]byte{0xFE, 0xFF, 0x00, 0xE0}, 0xFFFFFFFF)
+	item = append(item, 0x08, 0x00, 0x04, 0x01, 0x06, 0x00, 0x00, 0x00)
+	item = append(item, "Brain "...)
+	item = append(item, 0x28, 0x00, 0x10, 0x00, 0x02, 0x00, 0x00, 0x00, 0x00, 0x02)
+	item = append(item, 0xFE, 0xFF, 0x0D, 0xE0, 0x00, 0x00, 0x00, 0x00)
+	item = append(item, 0xFE, 0xFF, 0xDD, 0xE0, 0x00, 0x00, 0x00, 0x00)
+
+	for _, ts := range []*transfersyntax.TransferSyntax{transfersyntax.ExplicitVRLittleEndian, transfersyntax.ExplicitVRBigEndian} {
+		t.Run(ts.Name, func(t *testing.T) {
+			var order binary.AppendByteOrder = binary.LittleEndian
+			if ts == transfersyntax.ExplicitVRBigEndian {
+				order = binary.BigEndian
+			}
+			header := func(data []byte, group uint16, element uint16, vr string) []byte {
+				data = order.AppendUint16(order.AppendUint16(data, group), element)
+				return append(data, vr...)
+			}
+			obj := NewEmptyDCMObj()
+			obj.SetTransferSyntax(ts)
+			obj.WriteString(tags.Modality, "OT")
+			data := obj.WriteToBytes()
+			data = order.AppendUint16(header(data, 0x0009, 0x0010, "LO"), 4)
+			data = append(data, "ACME"...)
+			data = order.AppendUint32(order.AppendUint16(header(data, 0x0009, 0x1001, "UN"), 0), 0xFFFFFFFF)
+			data = append(data, item...)
+			data = order.AppendUint16(header(data, 0x0010, 0x0010, "PN"), 8)
+			data = append(data, "Doe^John"...)
+
+			parsed, err := NewDCMObjFromBytes(data)
+			if !assert.NoError(t, err) {
+				return
+			}
+			// A sequence in the encoding of the dataset once parsed, also when written again
+			reread, err := NewDCMObjFromBytes(parsed.WriteToBytes())
+			if !assert.NoError(t, err) {
+				return
+			}
+			for _, dcmObj := range []*DcmObj{parsed, reread} {
+				sq := dcmObj.GetTagGE(0x0009, 0x1001)
+				if !assert.NotNil(t, sq) || !assert.Len(t, sq.GetItems(), 1) {
+					return
+				}
+				assert.Equal(t, "SQ", sq.VR)
+				assert.Equal(t, "Brain", sq.GetItems()[0].GetString(tags.CodeMeaning))
+				assert.Equal(t, uint16(512), sq.GetItems()[0].GetUShort(tags.Rows))
+				assert.Equal(t, "Doe^John", dcmObj.GetString(tags.PatientName))
+			}
+		})
+	}
+}
@@ -5,8 +5,6 @@ import (
 	"encoding/binary"
 	"fmt"
 	"strings"
-
-	"github.com/t2care/obd-dicom/dictionary/transfersyntax"
 )
 
 // DcmTag DICOM tag structure
@@ -20,6 +18,7 @@ type DcmTag struct {
 	VM          string
 	Data        []byte
 	BigEndian   bool
+	Items       []*DcmObj // Items of a sequence tag, Data is then empty
 }
 
 // getUShort convert tag.Data to uint16
@@ -33,6 +32,39 @@ func (tag *DcmTag) getUShort() uint16 {
 	return 0
 }
 
+// byteOrder - of the binary values of the tag
+func (tag *DcmTag) byteOrder() binary.ByteOrder {
+	if tag.BigEndian {
+		return binary.BigEndian
+	}
+	return binary.LittleEndian
+}
+
+// swapByteOrder - converts the binary values to big or little endian, also the ones of the items of a sequence
+func (tag *DcmTag) swapByteOrder(bigEndian bool) error {
+	if tag.BigEndian == bigEndian {
+		return nil
+	}
+	switch {
+	case tag.Items != nil:
+		for _, item := range tag.Items {
+			if err := item.swapByteOrder(bigEndian); err != nil {
+				return err
+			}
+		}
+	case tag.Group == 0xFFFE || tag.Length == 0xFFFFFFFF:
+		// Fragments are byte streams
+	default:
+		data := tag.Data
+		if int(tag.Length) < len(data) {
+			data = data[:tag.Length]
+		}
+		tag.Data = swapBytes(data, binaryVRSize(singleVR(tag.VR)))
+	}
+	tag.BigEndian = bigEndian
+	return nil
+}
+
 // getString convert tag.Data to string
 func (tag *DcmTag) getString() string {
 	n := bytes.IndexByte(tag.Data, 0)
@@ -42,111 +74,86 @@ func (tag *DcmTag) getString() string {
 	return strings.TrimSpace(string(tag.Data[:n]))
 }
 
-// writeSeq - Create an SQ tag from a DICOM Object
-func (tag *DcmTag) writeSeq(group uint16, element uint16, seq *DcmObj) {
-	bufdata := &BufData{
-		BigEndian: false,
-		MS:        NewEmptyMemoryStream(),
-	}
+// GetItems - the items of a sequence tag
+func (tag *DcmTag) GetItems() []*DcmObj {
+	return tag.Items
+}
 
-	bufdata.BigEndian = seq.IsBigEndian()
-	tag.BigEndian = seq.IsBigEndian()
-	tag.Group = group
-	tag.Element = element
-	if tag.Group == 0xFFFE {
-		tag.VR = ""
-	} else {
+// AddItem - appends an item to a sequence tag, converted to the byte order of the tag
+func (tag *DcmTag) AddItem(item *DcmObj) {
+	item.swapByteOrder(tag.BigEndian)
+	if tag.VR == "" || tag.VR == "UN" {
 		tag.VR = "SQ"
 	}
-	for i := 0; i < seq.TagCount(); i++ {
-		temptag := seq.GetTagAt(i)
-		bufdata.WriteTag(temptag, seq.IsExplicitVR())
+	tag.Data = nil
+	tag.Items = append(tag.getItems(), item)
+}
+
+// RemoveItem - removes the item at index from a sequence tag
+func (tag *DcmTag) RemoveItem(index int) error {
+	if index < 0 || index >= len(tag.Items) {
+		return fmt.Errorf("DcmTag::RemoveItem, item %d out of %d in (%04X,%04X)", index, len(tag.Items), tag.Group, tag.Element)
 	}
-	tag.Length = uint32(bufdata.GetSize())
-	if tag.Length%2 == 1 {
-		tag.Length++
-		bufdata.MS.Write([]byte{0x00}, 1)
+	tag.Items = append(tag.Items[:index], tag.Items[index+1:]...)
+	return nil
+}
+
+// SetUndefinedLength - the sequence is written with an undefined length and a sequence delimitation, or with its length
+func (tag *DcmTag) SetUndefinedLength(undefined bool) {
+	if undefined {
+		tag.Length = 0xFFFFFFFF
+	} else if tag.Length == 0xFFFFFFFF {
+		// Computed when written
+		tag.Length = 0
 	}
-	if tag.Length > 0 {
-		bufdata.SetPosition(0)
-		data, _ := bufdata.MS.Read(int(tag.Length))
-		tag.Data = data
+}
+
+// getItems - never nil, an empty sequence still is a sequence
+func (tag *DcmTag) getItems() []*DcmObj {
+	if tag.Items == nil {
+		return make([]*DcmObj, 0)
 	}
+	return tag.Items
 }
 
-// ReadSeq - reads a dicom sequence
+// ReadSeq - reads a dicom sequence, the item tags of a sequence or the tags of an item tag.
+//
+// Deprecated: the items of the sequences are parsed with the object, use GetItems
 func (tag *DcmTag) ReadSeq(ExplicitVR bool) (*DcmObj, error) {
 	seq := NewEmptyDCMObj()
+	seq.SetBigEndian(tag.BigEndian)
 	bufdata := &BufData{
-		BigEndian: false,
+		BigEndian: tag.BigEndian,
 		MS:        NewEmptyMemoryStream(),
 	}
 
-	bufdata.Write(tag.Data, int(tag.Length))
+	if tag.Items != nil {
+		// Item tags of a defined length, holding the encoded item
+		for _, item := range tag.Items {
+			bufdata.writeItem(&DcmObj{Tags: item.Tags}, ExplicitVR)
+		}
+	} else {
+		bufdata.Write(tag.Data, int(tag.Length))
+	}
 	bufdata.MS.SetPosition(0)
-	var tempTags *DcmObj
-	haveItem := false
 	for bufdata.MS.GetPosition() < bufdata.MS.GetSize() {
 		temptag, err := bufdata.ReadTag(ExplicitVR)
 		if err != nil {
 			return seq, fmt.Errorf("cannot read (%04X,%04X). Error: %s", tag.Group, tag.Element, err.Error())
 		}
-
 		if !ExplicitVR {
-			temptag.VR = getDictionaryVR(tag.Group, tag.Element)
+			temptag.VR = getDictionaryVR(temptag.Group, temptag.Element)
 		}
-		switch temptag.Element {
-		case 0xE000:
-			if temptag.Length != 0xFFFFFFFF {
-				seq.Add(temptag)
-				continue
-			}
-			haveItem = true
-			tempTags = new(DcmObj)
-			tempTags.SetExplicitVR(ExplicitVR)
-		case 0xE00D:
-			item := new(DcmTag)
-			item.writeItem(tempTags)
-			seq.Add(item)
-		default:
-			if haveItem {
-				tempTags.Add(temptag)
-			} else {
-				seq.Add(temptag)
+		if temptag.VR == "SQ" {
+			if err := bufdata.readItems(temptag, ExplicitVR); err != nil {
+				return seq, err
 			}
 		}
+		seq.Add(temptag)
 	}
 	return seq, nil
 }
 
-func (tag *DcmTag) writeItem(obj *DcmObj) {
-	tag.writeSeq(0xFFFE, 0xE000, obj)
-}
-
-func (tag *DcmTag) transcode(explicitVR bool, outTS *transfersyntax.TransferSyntax) error {
-	if tag.isSequence() {
-		seq, err := tag.ReadSeq(explicitVR)
-		if err != nil {
-			return err
-		}
-		for _, item := range seq.GetTags() {
-			item.transcode(explicitVR, outTS)
-		}
-		seq.SetTransferSyntax(outTS)
-		tag.writeSeq(tag.Group, tag.Element, seq)
-	}
-	return nil
-}
-
 func (tag *DcmTag) isSequence() bool {
-	return tag.VR == "SQ" || (tag.Group == 0xFFFE && tag.Element == 0xE000)
-}
-
-// Length is undefined 0xFFFFFFFF
-func (tag *DcmTag) isSequenceUndefined() bool {
-	return tag.isSequence() && tag.Length == 0xFFFFFFFF
-}
-
-func (tag *DcmTag) isSequenceEnd() bool {
-	return (tag.Group == 0xFFFE && tag.Element == 0xE00D) || (tag.Group == 0xFFFE && tag.Element == 0xE0DD)
+	return tag.VR == "SQ" || tag.Items != nil
 }
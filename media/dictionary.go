@@ -4,6 +4,8 @@ import (
 	"encoding/xml"
 	"os"
 	"strconv"
+	"sync"
+	"sync/atomic"
 
 	"github.com/t2care/obd-dicom/dictionary/tags"
 )
@@ -26,7 +28,24 @@ type tagKey struct {
 	element uint16
 }
 
-var codes map[tagKey]*tags.Tag
+// codes - replaced as a whole by InitDict, read concurrently by the associations
+var (
+	codes    atomic.Pointer[map[tagKey]*tags.Tag]
+	initOnce sync.Once
+)
+
+// loadedCodes - the tags of the dictionary, loaded on first use when InitDict was not called
+func loadedCodes() map[tagKey]*tags.Tag {
+	if c := codes.Load(); c != nil {
+		return *c
+	}
+	initOnce.Do(func() {
+		if codes.Load() == nil {
+			InitDict()
+		}
+	})
+	return *codes.Load()
+}
 
 // FillTag - Populates with data from dictionary
 func FillTag(tag *DcmTag) {
@@ -47,10 +66,7 @@ func FillTag(tag *DcmTag) {
 
 // getDictionaryTag - get tag from Dictionary
 func getDictionaryTag(group uint16, element uint16) *tags.Tag {
-	if codes == nil {
-		InitDict()
-	}
-	if t, ok := codes[tagKey{group: group, element: element}]; ok {
+	if t, ok := loadedCodes()[tagKey{group: group, element: element}]; ok {
 		return t
 	}
 	return &tags.Tag{
@@ -65,16 +81,13 @@ func getDictionaryTag(group uint16, element uint16) *tags.Tag {
 
 // getDictionaryVR - get info from Dictionary
 func getDictionaryVR(group uint16, element uint16) string {
-	if codes == nil {
-		InitDict()
-	}
-	if t, ok := codes[tagKey{group: group, element: element}]; ok {
+	if t, ok := loadedCodes()[tagKey{group: group, element: element}]; ok {
 		return t.VR
 	}
 	return "UN"
 }
 
-func loadPrivateDictionary() {
+func loadPrivateDictionary(codes map[tagKey]*tags.Tag) {
 	privateDictionaryFile := "./private.xml"
 	data, err := os.ReadFile(privateDictionaryFile)
 	if err != nil {
@@ -112,9 +125,10 @@ func loadPrivateDictionary() {
 // InitDict Initialize Dictionary
 func InitDict() {
 	tagList := tags.GetTags()
-	codes = make(map[tagKey]*tags.Tag, len(tagList))
+	dict := make(map[tagKey]*tags.Tag, len(tagList))
 	for _, t := range tagList {
-		codes[tagKey{group: t.Group, element: t.Element}] = t
+		dict[tagKey{group: t.Group, element: t.Element}] = t
 	}
-	loadPrivateDictionary()
+	loadPrivateDictionary(dict)
+	codes.Store(&dict)
 }
@@ -1,26 +1,64 @@
 package media
 
-// JSONTag - JSON struct for a DICOM tag
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/t2care/obd-dicom/dictionary/tags"
+	"github.com/t2care/obd-dicom/dictionary/transfersyntax"
+)
+
+// JSONTag - JSON struct for a DICOM tag, PS3.18 Annex F
 type JSONTag struct {
-	VR    string        `json:"vr"`
-	Value []interface{} `json:"Value"`
+	VR           string        `json:"vr"`
+	Value        []interface{} `json:"Value,omitempty"`
+	BulkDataURI  string        `json:"BulkDataURI,omitempty"`
+	InlineBinary string        `json:"InlineBinary,omitempty"`
 }
 
-// JSONPNValue - JSON struct for PN value
+// JSONPNValue - JSON struct for PN value, one string per component group
 type JSONPNValue struct {
-	Alphabetic  JSONAlphabeticValue `json:"Alphabetic"`
-	Ideographic JSONAlphabeticValue `json:"Ideographic,omitempty"`
+	Alphabetic  string `json:"Alphabetic,omitempty"`
+	Ideographic string `json:"Ideographic,omitempty"`
+	Phonetic    string `json:"Phonetic,omitempty"`
 }
 
 // JSONAlphabeticValue - JSON struct for alphabetic value
+//
+// Deprecated: JSONPNValue has one string per component group, PS3.18 F.2.2
 type JSONAlphabeticValue struct {
 	Family []string `json:"Family,omitempty"`
 	Given  []string `json:"Given,omitempty"`
 	Suffix []string `json:"Suffix,omitempty"`
 }
 
+// JSONOptions - Bulk data handling of the binary VRs
+type JSONOptions struct {
+	BulkDataThreshold int                              // Binary values longer are referenced by BulkDataURI instead of InlineBinary
+	BulkDataURI       func(tag *DcmTag) string         // URI of the bulk data of a tag, binary values are always inline when nil
+	LoadBulkData      func(uri string) ([]byte, error) // Value of a BulkDataURI, the tag is left empty when nil
+}
+
 // JSONObj - JSON struct for DICOM data
 type JSONObj interface {
+	GetTags() map[string]JSONTag
+	ToDcmObj(opt ...*JSONOptions) (*DcmObj, error)
+	MarshalJSON() ([]byte, error)
+	UnmarshalJSON(data []byte) error
+}
+
+// byteOrder - of the values of the DICOM object
+type byteOrder interface {
+	binary.ByteOrder
+	binary.AppendByteOrder
 }
 
 type jsonObj struct {
@@ -34,7 +72,672 @@ func NewJSONObj() JSONObj {
 	}
 }
 
+// NewJSONObjFromBytes - Parses a DICOM JSON object
+func NewJSONObjFromBytes(data []byte) (JSONObj, error) {
+	obj := &jsonObj{}
+	if err := obj.UnmarshalJSON(data); err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
 // NewJSONObjFromDcmObj - Creates a new jsonObj and parses DcmObj into it
-func NewJSONObjFromDcmObj(dcm DcmObj) JSONObj {
-	return &jsonObj{}
+func NewJSONObjFromDcmObj(dcm *DcmObj, opt ...*JSONOptions) (JSONObj, error) {
+	options := &JSONOptions{}
+	if len(opt) > 0 && opt[0] != nil {
+		options = opt[0]
+	}
+	return newJSONObj(dcm, options, "")
+}
+
+// GetTags - the tags by "ggggeeee" key
+func (j *jsonObj) GetTags() map[string]JSONTag {
+	return j.Tags
+}
+
+// MarshalJSON - DICOM JSON object, the tags are sorted
+func (j *jsonObj) MarshalJSON() ([]byte, error) {
+	if j.Tags == nil {
+		return []byte("{}"), nil
+	}
+	return json.Marshal(j.Tags)
+}
+
+// UnmarshalJSON - numbers are kept as json.Number, PN values as JSONPNValue and sequence items as JSONObj
+func (j *jsonObj) UnmarshalJSON(data []byte) error {
+	var raw map[string]struct {
+		VR           string            `json:"vr"`
+		Value        []json.RawMessage `json:"Value"`
+		BulkDataURI  string            `json:"BulkDataURI"`
+		InlineBinary string            `json:"InlineBinary"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return fmt.Errorf("JSONObj::Unmarshal, %s", err.Error())
+	}
+	j.Tags = make(map[string]JSONTag, len(raw))
+	for key, rt := range raw {
+		tag := JSONTag{VR: rt.VR, BulkDataURI: rt.BulkDataURI, InlineBinary: rt.InlineBinary}
+		for _, rv := range rt.Value {
+			value, err := unmarshalJSONValue(rt.VR, rv)
+			if err != nil {
+				return fmt.Errorf("JSONObj::Unmarshal, %s %s", key, err.Error())
+			}
+			tag.Value = append(tag.Value, value)
+		}
+		j.Tags[strings.ToUpper(key)] = tag
+	}
+	return nil
+}
+
+func unmarshalJSONValue(vr string, data json.RawMessage) (interface{}, error) {
+	if string(data) == "null" {
+		return nil, nil
+	}
+	switch {
+	case vr == "SQ":
+		item := &jsonObj{}
+		if err := item.UnmarshalJSON(data); err != nil {
+			return nil, err
+		}
+		return item, nil
+	case vr == "PN":
+		var pn JSONPNValue
+		err := json.Unmarshal(data, &pn)
+		return pn, err
+	case isJSONNumberVR(vr):
+		var n json.Number
+		err := json.Unmarshal(data, &n)
+		return n, err
+	default:
+		var s string
+		err := json.Unmarshal(data, &s)
+		return s, err
+	}
+}
+
+// ToDcmObj - DICOM object in Explicit VR Little Endian
+func (j *jsonObj) ToDcmObj(opt ...*JSONOptions) (*DcmObj, error) {
+	options := &JSONOptions{}
+	if len(opt) > 0 && opt[0] != nil {
+		options = opt[0]
+	}
+	obj := NewEmptyDCMObj()
+	obj.SetTransferSyntax(transfersyntax.ExplicitVRLittleEndian)
+	if err := j.fill(obj, options); err != nil {
+		return nil, err
+	}
+	if err := obj.fromUTF8(""); err != nil {
+		return nil, fmt.Errorf("JSONObj::ToDcmObj, %s", err.Error())
+	}
+	return obj, nil
+}
+
+func newJSONObj(obj *DcmObj, opt *JSONOptions, charset string) (*jsonObj, error) {
+	j := &jsonObj{Tags: make(map[string]JSONTag)}
+	charset = obj.characterSet(charset)
+	var order byteOrder = binary.LittleEndian
+	if obj.IsBigEndian() {
+		order = binary.BigEndian
+	}
+	for i := 0; i < len(obj.Tags); i++ {
+		tag := obj.Tags[i]
+		// Group lengths and delimiters are not encoded
+		if tag.Element == 0x0000 || tag.Group == 0xFFFE {
+			continue
+		}
+		vr := singleVR(tag.VR)
+		jt := JSONTag{VR: vr}
+		switch {
+		case vr == "SQ":
+			for _, item := range tag.Items {
+				ji, err := newJSONObj(item, opt, charset)
+				if err != nil {
+					return nil, err
+				}
+				jt.Value = append(jt.Value, ji)
+			}
+		case isBinaryVR(vr):
+			jt.BulkDataURI, jt.InlineBinary, i = obj.encodeBinaryValue(i, opt.BulkDataThreshold, opt.BulkDataURI)
+		default:
+			text, err := utf8Tag(tag, vr, charset)
+			var values []interface{}
+			if err == nil {
+				values, err = jsonValues(text, vr, order)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("JSONObj::Encode, (%04X,%04X) %s", tag.Group, tag.Element, err.Error())
+			}
+			jt.Value = values
+		}
+		j.Tags[fmt.Sprintf("%04X%04X", tag.Group, tag.Element)] = jt
+	}
+	return j, nil
+}
+
+// encodeBinaryValue - the bulk data URI of the binary tag at index when its value is longer than threshold, its
+// value in base64 otherwise. Both are empty for an empty value. Returns the index of the last tag encoded
+func (obj *DcmObj) encodeBinaryValue(index int, threshold int, bulkDataURI func(tag *DcmTag) string) (uri string, inline string, last int) {
+	tag := obj.Tags[index]
+	data := tag.Data
+	if tag.Length == 0xFFFFFFFF {
+		// Encapsulated fragments, kept with their item tags
+		data, index = obj.encapsulatedData(index)
+	} else if obj.IsBigEndian() {
+		data = swapBytes(data, binaryVRSize(singleVR(tag.VR)))
+	}
+	if len(data) == 0 {
+		return "", "", index
+	}
+	if bulkDataURI != nil && len(data) > threshold {
+		return bulkDataURI(tag), "", index
+	}
+	return "", base64.StdEncoding.EncodeToString(data), index
+}
+
+// characterSet - the Specific Character Set of the text values of obj, the one of its parent dataset when it has none
+func (obj *DcmObj) characterSet(parent string) string {
+	if obj.GetTag(tags.SpecificCharacterSet) == nil {
+		return parent
+	}
+	return obj.GetString(tags.SpecificCharacterSet)
+}
+
+// utf8Tag - a copy of the tag with its text value converted to UTF-8, the tag itself when no conversion is needed.
+// JSON and XML are UTF-8: the default repertoire, ISO_IR 100 and ISO_IR 192 are converted, the other character
+// sets only have their values in ASCII
+func utf8Tag(tag *DcmTag, vr string, charset string) (*DcmTag, error) {
+	if !isTextVR(vr) {
+		return tag, nil
+	}
+	data := tag.Data
+	if int(tag.Length) < len(data) {
+		data = data[:tag.Length]
+	}
+	if isASCII(data) {
+		return tag, nil
+	}
+	switch charset {
+	case "ISO_IR 100":
+		runes := make([]rune, 0, len(data))
+		for _, b := range data {
+			runes = append(runes, rune(b))
+		}
+		converted := *tag
+		converted.Data = []byte(string(runes))
+		converted.Length = uint32(len(converted.Data))
+		return &converted, nil
+	case "ISO_IR 192":
+		if !utf8.Valid(data) {
+			return nil, errors.New("invalid UTF-8 value")
+		}
+		return tag, nil
+	}
+	return nil, characterSetError(charset)
+}
+
+// fromUTF8 - converts the text values decoded from JSON or XML to the character set of obj, see utf8Tag
+func (obj *DcmObj) fromUTF8(charset string) error {
+	charset = obj.characterSet(charset)
+	for _, tag := range obj.Tags {
+		for _, item := range tag.Items {
+			if err := item.fromUTF8(charset); err != nil {
+				return err
+			}
+		}
+		if !isTextVR(singleVR(tag.VR)) || isASCII(tag.Data) || charset == "ISO_IR 192" {
+			continue
+		}
+		if charset != "ISO_IR 100" {
+			return fmt.Errorf("(%04X,%04X) %s", tag.Group, tag.Element, characterSetError(charset).Error())
+		}
+		data := make([]byte, 0, len(tag.Data))
+		for _, r := range strings.TrimRight(string(tag.Data), " ") {
+			if r > 0xFF {
+				return fmt.Errorf("(%04X,%04X) %q cannot be encoded in ISO_IR 100", tag.Group, tag.Element, r)
+			}
+			data = append(data, byte(r))
+		}
+		if len(data)%2 == 1 {
+			data = append(data, 0x20)
+		}
+		tag.Data = data
+		tag.Length = uint32(len(data))
+	}
+	return nil
+}
+
+// isASCII - the value is the same in all the character sets, no byte above 0x7F nor ISO 2022 escape sequence
+func isASCII(data []byte) bool {
+	for _, b := range data {
+		if b > 0x7F || b == 0x1B {
+			return false
+		}
+	}
+	return true
+}
+
+func characterSetError(charset string) error {
+	if charset == "" || charset == "ISO_IR 6" {
+		return errors.New("value not in the default character repertoire")
+	}
+	return fmt.Errorf("character set %s not supported", charset)
+}
+
+// encapsulatedData - the fragments following an undefined length tag, with their item tags, and the index of the delimiter
+func (obj *DcmObj) encapsulatedData(index int) ([]byte, int) {
+	bufdata := NewEmptyBufData()
+	for i := index + 1; i < len(obj.Tags); i++ {
+		t := obj.Tags[i]
+		if t.Group == 0xFFFE && t.Element == 0xE0DD {
+			return bufdata.GetAllBytes(), i
+		}
+		bufdata.WriteTag(t, false)
+	}
+	return bufdata.GetAllBytes(), len(obj.Tags) - 1
+}
+
+// jsonValues - Value of the tags with a string or numeric VR
+func jsonValues(tag *DcmTag, vr string, order byteOrder) ([]interface{}, error) {
+	data := tag.Data
+	if int(tag.Length) < len(data) {
+		data = data[:tag.Length]
+	}
+	if len(data) == 0 {
+		return nil, nil
+	}
+	values := make([]interface{}, 0)
+	switch vr {
+	case "AT":
+		for i := 0; i+4 <= len(data); i += 4 {
+			values = append(values, fmt.Sprintf("%04X%04X", order.Uint16(data[i:]), order.Uint16(data[i+2:])))
+		}
+	case "US":
+		for i := 0; i+2 <= len(data); i += 2 {
+			values = append(values, order.Uint16(data[i:]))
+		}
+	case "SS":
+		for i := 0; i+2 <= len(data); i += 2 {
+			values = append(values, int16(order.Uint16(data[i:])))
+		}
+	case "UL":
+		for i := 0; i+4 <= len(data); i += 4 {
+			values = append(values, order.Uint32(data[i:]))
+		}
+	case "SL":
+		for i := 0; i+4 <= len(data); i += 4 {
+			values = append(values, int32(order.Uint32(data[i:])))
+		}
+	case "UV":
+		for i := 0; i+8 <= len(data); i += 8 {
+			values = append(values, order.Uint64(data[i:]))
+		}
+	case "SV":
+		for i := 0; i+8 <= len(data); i += 8 {
+			values = append(values, int64(order.Uint64(data[i:])))
+		}
+	case "FL":
+		for i := 0; i+4 <= len(data); i += 4 {
+			f := float64(math.Float32frombits(order.Uint32(data[i:])))
+			if math.IsNaN(f) || math.IsInf(f, 0) {
+				return nil, fmt.Errorf("%v cannot be encoded", f)
+			}
+			values = append(values, json.Number(strconv.FormatFloat(f, 'g', -1, 32)))
+		}
+	case "FD":
+		for i := 0; i+8 <= len(data); i += 8 {
+			f := math.Float64frombits(order.Uint64(data[i:]))
+			if math.IsNaN(f) || math.IsInf(f, 0) {
+				return nil, fmt.Errorf("%v cannot be encoded", f)
+			}
+			values = append(values, json.Number(strconv.FormatFloat(f, 'g', -1, 64)))
+		}
+	default:
+		content := strings.TrimRight(string(data), "\x00 ")
+		if content == "" {
+			return nil, nil
+		}
+		parts := []string{content}
+		if vr != "LT" && vr != "ST" && vr != "UT" && vr != "UR" {
+			parts = strings.Split(content, "\\")
+		}
+		for _, part := range parts {
+			if vr != "LT" && vr != "ST" && vr != "UT" {
+				part = strings.TrimSpace(part)
+			}
+			if part == "" {
+				values = append(values, nil)
+				continue
+			}
+			switch vr {
+			case "PN":
+				groups := strings.SplitN(part, "=", 3)
+				groups = append(groups, "", "")
+				values = append(values, JSONPNValue{Alphabetic: groups[0], Ideographic: groups[1], Phonetic: groups[2]})
+			case "DS", "IS":
+				values = append(values, jsonNumber(part))
+			default:
+				values = append(values, part)
+			}
+		}
+	}
+	return values, nil
+}
+
+// jsonNumber - DS and IS are JSON numbers, the invalid ones are kept as strings
+func jsonNumber(s string) interface{} {
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return s
+	}
+	if json.Valid([]byte(s)) {
+		return json.Number(s)
+	}
+	// NaN and infinities have no JSON number
+	if formatted := strconv.FormatFloat(f, 'g', -1, 64); json.Valid([]byte(formatted)) {
+		return json.Number(formatted)
+	}
+	return s
+}
+
+// fill - adds the tags to obj, sorted
+func (j *jsonObj) fill(obj *DcmObj, opt *JSONOptions) error {
+	keys := make([]string, 0, len(j.Tags))
+	for key := range j.Tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var order byteOrder = binary.LittleEndian
+	if obj.IsBigEndian() {
+		order = binary.BigEndian
+	}
+	for _, key := range keys {
+		jt := j.Tags[key]
+		tagID, err := strconv.ParseUint(key, 16, 32)
+		if err != nil || len(key) != 8 {
+			return fmt.Errorf("JSONObj::ToDcmObj, invalid tag %s", key)
+		}
+		group := uint16(tagID >> 16)
+		element := uint16(tagID)
+		if jt.VR == "SQ" {
+			items := make([]*DcmObj, 0, len(jt.Value))
+			for _, v := range jt.Value {
+				ji, ok := v.(*jsonObj)
+				if !ok {
+					return fmt.Errorf("JSONObj::ToDcmObj, %s item is not an object", key)
+				}
+				item := NewEmptyDCMObj()
+				item.SetExplicitVR(obj.IsExplicitVR())
+				item.SetBigEndian(obj.IsBigEndian())
+				if err := ji.fill(item, opt); err != nil {
+					return err
+				}
+				items = append(items, item)
+			}
+			obj.WriteSeq(&tags.Tag{Group: group, Element: element}, items)
+			continue
+		}
+		tag := &DcmTag{
+			Group:     group,
+			Element:   element,
+			VR:        jt.VR,
+			BigEndian: obj.IsBigEndian(),
+		}
+		var data []byte
+		switch {
+		case jt.InlineBinary != "":
+			data, err = base64.StdEncoding.DecodeString(jt.InlineBinary)
+		case jt.BulkDataURI != "" && opt.LoadBulkData != nil:
+			data, err = opt.LoadBulkData(jt.BulkDataURI)
+		case len(jt.Value) > 0:
+			data, err = dicomValue(jt.VR, jt.Value, order)
+		}
+		if err != nil {
+			return fmt.Errorf("JSONObj::ToDcmObj, %s %s", key, err.Error())
+		}
+		obj.addValue(tag, data)
+	}
+	return nil
+}
+
+// addValue - adds the tag with its value, padded to an even length. Binary values are given in Little Endian,
+// encapsulated pixel data is added with its fragments
+func (obj *DcmObj) addValue(tag *DcmTag, data []byte) {
+	if isBinaryVR(tag.VR) {
+		if fragments := encapsulatedFragments(tag, data); fragments != nil {
+			FillTag(tag)
+			obj.Add(tag)
+			for _, fragment := range fragments {
+				obj.Add(fragment)
+			}
+			return
+		}
+		if obj.IsBigEndian() {
+			data = swapBytes(data, binaryVRSize(tag.VR))
+		}
+	}
+	if len(data)%2 == 1 {
+		if tag.VR == "UI" || isBinaryVR(tag.VR) {
+			data = append(data, 0x00)
+		} else {
+			data = append(data, 0x20)
+		}
+	}
+	tag.Data = data
+	tag.Length = uint32(len(data))
+	FillTag(tag)
+	obj.Add(tag)
+}
+
+// encapsulatedFragments - the fragments of encapsulated pixel data, nil when data is not encapsulated
+func encapsulatedFragments(tag *DcmTag, data []byte) []*DcmTag {
+	if tag.Group != 0x7FE0 || tag.Element != 0x0010 || len(data) < 8 ||
+		binary.LittleEndian.Uint16(data) != 0xFFFE || binary.LittleEndian.Uint16(data[2:]) != 0xE000 {
+		return nil
+	}
+	bufdata := NewBufDataFromBytes(data)
+	fragments := make([]*DcmTag, 0)
+	for bufdata.GetPosition() < bufdata.GetSize() {
+		fragment, err := bufdata.ReadTag(false)
+		if err != nil || fragment.Group != 0xFFFE {
+			return nil
+		}
+		fragments = append(fragments, fragment)
+	}
+	tag.Length = 0xFFFFFFFF
+	return append(fragments, &DcmTag{Group: 0xFFFE, Element: 0xE0DD})
+}
+
+// dicomValue - data of the tags with a string or numeric VR
+func dicomValue(vr string, values []interface{}, order byteOrder) ([]byte, error) {
+	data := make([]byte, 0)
+	strs := make([]string, 0, len(values))
+	for _, v := range values {
+		switch vr {
+		case "AT":
+			s, _ := v.(string)
+			t, err := strconv.ParseUint(s, 16, 32)
+			if err != nil {
+				return nil, fmt.Errorf("invalid AT %v", v)
+			}
+			data = order.AppendUint16(data, uint16(t>>16))
+			data = order.AppendUint16(data, uint16(t))
+		case "US", "SS", "UL", "SL", "UV", "SV", "FL", "FD":
+			n, err := numberString(v)
+			if err != nil {
+				return nil, err
+			}
+			if data, err = appendNumber(data, vr, n, order); err != nil {
+				return nil, err
+			}
+		case "PN":
+			switch pn := v.(type) {
+			case nil:
+				strs = append(strs, "")
+			case JSONPNValue:
+				strs = append(strs, strings.TrimRight(strings.Join([]string{pn.Alphabetic, pn.Ideographic, pn.Phonetic}, "="), "="))
+			case string:
+				strs = append(strs, pn)
+			default:
+				return nil, fmt.Errorf("invalid PN %v", v)
+			}
+		case "DS", "IS":
+			if v == nil {
+				strs = append(strs, "")
+				continue
+			}
+			n, err := numberString(v)
+			if err != nil {
+				return nil, err
+			}
+			strs = append(strs, n)
+		default:
+			s, ok := v.(string)
+			if v != nil && !ok {
+				return nil, fmt.Errorf("invalid %s %v", vr, v)
+			}
+			strs = append(strs, s)
+		}
+	}
+	if len(strs) > 0 {
+		data = []byte(strings.Join(strs, "\\"))
+	}
+	return data, nil
+}
+
+// numberString - the numbers decoded are json.Number, the ones encoded or set by the caller Go numbers
+func numberString(v interface{}) (string, error) {
+	switch n := v.(type) {
+	case json.Number:
+		return n.String(), nil
+	case string:
+		return n, nil
+	case float64:
+		return strconv.FormatFloat(n, 'g', -1, 64), nil
+	case float32:
+		return strconv.FormatFloat(float64(n), 'g', -1, 32), nil
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		return fmt.Sprint(n), nil
+	}
+	return "", fmt.Errorf("invalid number %v", v)
+}
+
+func appendNumber(data []byte, vr string, n string, order byteOrder) ([]byte, error) {
+	invalid := fmt.Errorf("invalid %s %s", vr, n)
+	switch vr {
+	case "US":
+		u, err := strconv.ParseUint(n, 10, 16)
+		if err != nil {
+			return nil, invalid
+		}
+		return order.AppendUint16(data, uint16(u)), nil
+	case "UL":
+		u, err := strconv.ParseUint(n, 10, 32)
+		if err != nil {
+			return nil, invalid
+		}
+		return order.AppendUint32(data, uint32(u)), nil
+	case "UV":
+		u, err := strconv.ParseUint(n, 10, 64)
+		if err != nil {
+			return nil, invalid
+		}
+		return order.AppendUint64(data, u), nil
+	case "SS":
+		i, err := strconv.ParseInt(n, 10, 16)
+		if err != nil {
+			return nil, invalid
+		}
+		return order.AppendUint16(data, uint16(i)), nil
+	case "SL":
+		i, err := strconv.ParseInt(n, 10, 32)
+		if err != nil {
+			return nil, invalid
+		}
+		return order.AppendUint32(data, uint32(i)), nil
+	case "SV":
+		i, err := strconv.ParseInt(n, 10, 64)
+		if err != nil {
+			return nil, invalid
+		}
+		return order.AppendUint64(data, uint64(i)), nil
+	case "FL":
+		f, err := strconv.ParseFloat(n, 32)
+		if err != nil {
+			return nil, invalid
+		}
+		return order.AppendUint32(data, math.Float32bits(float32(f))), nil
+	case "FD":
+		f, err := strconv.ParseFloat(n, 64)
+		if err != nil {
+			return nil, invalid
+		}
+		return order.AppendUint64(data, math.Float64bits(f)), nil
+	}
+	return nil, invalid
+}
+
+// singleVR - a single VR for the tags read without one
+func singleVR(vr string) string {
+	switch {
+	case vr == "":
+		return "UN"
+	case vr == "OB/OW":
+		return "OW"
+	case strings.Contains(vr, "/"):
+		return vr[:2]
+	}
+	return vr
+}
+
+func isBinaryVR(vr string) bool {
+	switch vr {
+	case "OB", "OD", "OF", "OL", "OV", "OW", "UN":
+		return true
+	}
+	return false
+}
+
+// isTextVR - VRs whose values are in the Specific Character Set
+func isTextVR(vr string) bool {
+	switch vr {
+	case "LO", "LT", "PN", "SH", "ST", "UC", "UT":
+		return true
+	}
+	return false
+}
+
+func isJSONNumberVR(vr string) bool {
+	switch vr {
+	case "DS", "IS", "FL", "FD", "SL", "SS", "SV", "UL", "US", "UV":
+		return true
+	}
+	return false
+}
+
+// binaryVRSize - size of the words of the binary values, swapped between byte orders
+func binaryVRSize(vr string) int {
+	switch vr {
+	case "OW", "US", "SS", "AT":
+		return 2
+	case "OF", "OL", "UL", "SL", "FL":
+		return 4
+	case "OD", "OV", "UV", "SV", "FD":
+		return 8
+	}
+	return 1
+}
+
+// swapBytes - InlineBinary is Little Endian
+func swapBytes(data []byte, size int) []byte {
+	if size == 1 {
+		return data
+	}
+	swapped := make([]byte, len(data))
+	copy(swapped, data)
+	for i := 0; i+size <= len(swapped); i += size {
+		for a, b := i, i+size-1; a < b; a, b = a+1, b-1 {
+			swapped[a], swapped[b] = swapped[b], swapped[a]
+		}
+	}
+	return swapped
 }
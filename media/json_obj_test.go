@@ -0,0 +1,159 @@
+package media
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/t2care/obd-dicom/dictionary/tags"
+)
+
+func TestJSONObjFromFile(t *testing.T) {
+	for _, fileName := range []string{"../samples/test2.dcm", "../samples/test.dcm", "../samples/rle_gray.dcm", "../samples/test-losslessSV1.dcm"} {
+		t.Run(fileName, func(t *testing.T) {
+			dcmObj, err := NewDCMObjFromFile(fileName)
+			if !assert.NoError(t, err) {
+				return
+			}
+			jsonObj, err := NewJSONObjFromDcmObj(dcmObj)
+			if !assert.NoError(t, err) {
+				return
+			}
+			data, err := json.Marshal(jsonObj)
+			assert.NoError(t, err)
+
+			// JSON -> DICOM -> JSON gives the same JSON
+			parsed, err := NewJSONObjFromBytes(data)
+			assert.NoError(t, err)
+			decoded, err := parsed.ToDcmObj()
+			if !assert.NoError(t, err) {
+				return
+			}
+			assert.Equal(t, dcmObj.GetString(tags.SOPInstanceUID), decoded.GetString(tags.SOPInstanceUID))
+			assert.Equal(t, dcmObj.GetTag(tags.PixelData).Length, decoded.GetTag(tags.PixelData).Length, "encapsulated pixel data")
+			reencoded, err := NewJSONObjFromDcmObj(decoded)
+			assert.NoError(t, err)
+			redata, err := json.Marshal(reencoded)
+			assert.NoError(t, err)
+			assert.JSONEq(t, string(data), string(redata))
+		})
+	}
+}
+
+func TestJSONObjValues(t *testing.T) {
+	item := NewEmptyDCMObj()
+	item.SetExplicitVR(true)
+	item.WriteString(tags.CodeValue, "T-A0100")
+	item.WriteString(tags.CodeMeaning, "Brain")
+
+	obj := NewEmptyDCMObj()
+	obj.SetExplicitVR(true)
+	obj.WriteString(tags.SpecificCharacterSet, "ISO_IR 192")
+	obj.WriteString(tags.PatientName, "Yamada^Tarou=山田^太郎=やまだ^たろう")
+	obj.WriteString(tags.ImageType, `ORIGINAL\\PRIMARY`)
+	obj.WriteString(tags.PixelSpacing, `0.5\0.25`)
+	obj.WriteUint16(tags.Rows, 512)
+	obj.WriteSeq(tags.AnatomicRegionSequence, []*DcmObj{item})
+	obj.Add(&DcmTag{Group: 0x0009, Element: 0x0010, VR: "LO", Length: 4, Data: []byte("ACME")})
+	obj.Add(&DcmTag{Group: 0x0009, Element: 0x1001, VR: "OB", Length: 4, Data: []byte{1, 2, 3, 4}})
+	obj.Add(&DcmTag{Group: 0x0009, Element: 0x1002, VR: "OB", Length: 6, Data: []byte{1, 2, 3, 4, 5, 6}})
+
+	options := &JSONOptions{
+		BulkDataThreshold: 4,
+		BulkDataURI: func(tag *DcmTag) string {
+			return fmt.Sprintf("https://pacs/bulk/%04X%04X", tag.Group, tag.Element)
+		},
+	}
+	jsonObj, err := NewJSONObjFromDcmObj(obj, options)
+	assert.NoError(t, err)
+	data, err := json.Marshal(jsonObj)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{
+		"00080005": {"vr": "CS", "Value": ["ISO_IR 192"]},
+		"00080008": {"vr": "CS", "Value": ["ORIGINAL", null, "PRIMARY"]},
+		"00082218": {"vr": "SQ", "Value": [{
+			"00080100": {"vr": "SH", "Value": ["T-A0100"]},
+			"00080104": {"vr": "LO", "Value": ["Brain"]}
+		}]},
+		"00090010": {"vr": "LO", "Value": ["ACME"]},
+		"00091001": {"vr": "OB", "InlineBinary": "AQIDBA=="},
+		"00091002": {"vr": "OB", "BulkDataURI": "https://pacs/bulk/00091002"},
+		"00100010": {"vr": "PN", "Value": [{"Alphabetic": "Yamada^Tarou", "Ideographic": "山田^太郎", "Phonetic": "やまだ^たろう"}]},
+		"00280010": {"vr": "US", "Value": [512]},
+		"00280030": {"vr": "DS", "Value": [0.5, 0.25]}
+	}`, string(data))
+
+	parsed, err := NewJSONObjFromBytes(data)
+	assert.NoError(t, err)
+	decoded, err := parsed.ToDcmObj(&JSONOptions{LoadBulkData: func(uri string) ([]byte, error) {
+		return []byte{1, 2, 3, 4, 5, 6}, nil
+	}})
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "Yamada^Tarou=山田^太郎=やまだ^たろう", decoded.GetString(tags.PatientName))
+	assert.Equal(t, `ORIGINAL\\PRIMARY`, decoded.GetString(tags.ImageType))
+	assert.Equal(t, uint16(512), decoded.GetUShort(tags.Rows))
+	assert.Equal(t, []byte{1, 2, 3, 4, 5, 6}, decoded.GetTagGE(0x0009, 0x1002).Data)
+	items, err := decoded.GetSeq(tags.AnatomicRegionSequence)
+	if assert.NoError(t, err) && assert.Len(t, items, 1) {
+		assert.Equal(t, "Brain", items[0].GetString(tags.CodeMeaning))
+	}
+}
+
+func TestJSONObjNumbers(t *testing.T) {
+	obj := NewEmptyDCMObj()
+	obj.SetExplicitVR(true)
+	obj.WriteString(tags.PixelSpacing, `+1.5\nan\inf\1e999`)
+
+	jsonObj, err := NewJSONObjFromDcmObj(obj)
+	assert.NoError(t, err)
+	// Values without a JSON number are kept as strings
+	data, err := json.Marshal(jsonObj)
+	if assert.NoError(t, err) {
+		assert.JSONEq(t, `{"00280030": {"vr": "DS", "Value": [1.5, "nan", "inf", "1e999"]}}`, string(data))
+	}
+}
+
+func TestJSONObjCharacterSet(t *testing.T) {
+	obj := NewEmptyDCMObj()
+	obj.SetExplicitVR(true)
+	obj.WriteString(tags.SpecificCharacterSet, "ISO_IR 100")
+	obj.WriteString(tags.PatientName, "Fran\xe7ois^Ren\xe9e")
+
+	jsonObj, err := NewJSONObjFromDcmObj(obj)
+	assert.NoError(t, err)
+	data, err := json.Marshal(jsonObj)
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), `"Alphabetic":"François^Renée"`)
+
+	parsed, err := NewJSONObjFromBytes(data)
+	assert.NoError(t, err)
+	decoded, err := parsed.ToDcmObj()
+	if assert.NoError(t, err) {
+		assert.Equal(t, obj.GetTag(tags.PatientName).Data, decoded.GetTag(tags.PatientName).Data)
+	}
+
+	// Values in ASCII are the same in all the character sets, the others are only converted from the supported ones
+	for charset, supported := range map[string]bool{"": false, "ISO_IR 100": true, "ISO_IR 192": false, `\ISO_IR 100`: false, "ISO_IR 144": false} {
+		obj := NewEmptyDCMObj()
+		obj.SetExplicitVR(true)
+		if charset != "" {
+			obj.WriteString(tags.SpecificCharacterSet, charset)
+		}
+		obj.WriteString(tags.PatientName, "Doe^John")
+		_, err := NewJSONObjFromDcmObj(obj)
+		assert.NoError(t, err, charset)
+		obj.WriteString(tags.PatientName, "Fran\xe7ois")
+		_, err = NewJSONObjFromDcmObj(obj)
+		assert.Equal(t, supported, err == nil, charset)
+	}
+	for charset, supported := range map[string]bool{"": false, "ISO_IR 100": true, "ISO_IR 192": true, `\\ISO_IR 100`: false, "ISO_IR 144": false} {
+		parsed, err := NewJSONObjFromBytes([]byte(`{"00080005": {"vr": "CS", "Value": ["` + charset + `"]}, "00100010": {"vr": "PN", "Value": [{"Alphabetic": "François"}]}}`))
+		if assert.NoError(t, err) {
+			_, err = parsed.ToDcmObj()
+			assert.Equal(t, supported, err == nil, charset)
+		}
+	}
+}
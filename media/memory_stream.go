@@ -107,7 +107,6 @@ func (ms *MemoryStream) ReadFully(rw *bufio.ReadWriter, length int) error {
 	if _, err := io.ReadFull(rw, data); err != nil {
 		return err
 	}
-	rw.Flush()
 	ms.Data = append(ms.Data, data...)
 	ms.Size += length
 	return nil
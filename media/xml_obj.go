@@ -0,0 +1,390 @@
+package media
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/xml"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/t2care/obd-dicom/dictionary/tags"
+	"github.com/t2care/obd-dicom/dictionary/transfersyntax"
+)
+
+// XMLAttribute - XML struct for a DICOM tag, PS3.19 Native DICOM Model
+type XMLAttribute struct {
+	Tag            string          `xml:"tag,attr"`
+	VR             string          `xml:"vr,attr"`
+	Keyword        string          `xml:"keyword,attr,omitempty"`
+	PrivateCreator string          `xml:"privateCreator,attr,omitempty"`
+	Values         []XMLValue      `xml:"Value"`
+	PersonNames    []XMLPersonName `xml:"PersonName"`
+	Items          []XMLItem       `xml:"Item"`
+	BulkData       *XMLBulkData    `xml:"BulkData"`
+	InlineBinary   string          `xml:"InlineBinary,omitempty"`
+}
+
+// XMLValue - XML struct for a value, numbered from 1
+type XMLValue struct {
+	Number int    `xml:"number,attr"`
+	Value  string `xml:",chardata"`
+}
+
+// XMLItem - XML struct for a sequence item, numbered from 1
+type XMLItem struct {
+	Number     int            `xml:"number,attr"`
+	Attributes []XMLAttribute `xml:"DicomAttribute"`
+}
+
+// XMLBulkData - XML struct for a reference to the value of a binary tag
+type XMLBulkData struct {
+	URI string `xml:"uri,attr"`
+}
+
+// XMLPersonName - XML struct for PN value, nil component groups are absent
+type XMLPersonName struct {
+	Number      int                `xml:"number,attr"`
+	Alphabetic  *XMLNameComponents `xml:"Alphabetic"`
+	Ideographic *XMLNameComponents `xml:"Ideographic"`
+	Phonetic    *XMLNameComponents `xml:"Phonetic"`
+}
+
+// XMLNameComponents - XML struct for a PN component group, nil components are absent
+type XMLNameComponents struct {
+	FamilyName *string `xml:"FamilyName"`
+	GivenName  *string `xml:"GivenName"`
+	MiddleName *string `xml:"MiddleName"`
+	NamePrefix *string `xml:"NamePrefix"`
+	NameSuffix *string `xml:"NameSuffix"`
+}
+
+// XMLOptions - Bulk data handling of the binary VRs and transfer syntax of the DICOM object decoded
+type XMLOptions struct {
+	BulkDataThreshold int                              // Binary values longer are referenced by BulkData instead of InlineBinary
+	BulkDataURI       func(tag *DcmTag) string         // URI of the bulk data of a tag, binary values are always inline when nil
+	LoadBulkData      func(uri string) ([]byte, error) // Value of a BulkData URI, the tag is left empty when nil
+	TransferSyntax    *transfersyntax.TransferSyntax   // Explicit VR Little Endian when nil
+}
+
+// XMLObj - XML struct for DICOM data, marshaled with encoding/xml
+type XMLObj interface {
+	GetAttributes() []XMLAttribute
+	ToDcmObj(opt ...*XMLOptions) (*DcmObj, error)
+}
+
+type xmlObj struct {
+	XMLName    xml.Name       `xml:"http://dicom.nema.org/PS3.19/models/NativeDICOM NativeDicomModel"`
+	Space      string         `xml:"xml:space,attr,omitempty"`
+	Attributes []XMLAttribute `xml:"DicomAttribute"`
+}
+
+// NewXMLObj - Creates a new xmlObj and returns an interface to it
+func NewXMLObj() XMLObj {
+	return &xmlObj{Space: "preserve"}
+}
+
+// NewXMLObjFromBytes - Parses a Native DICOM Model document
+func NewXMLObjFromBytes(data []byte) (XMLObj, error) {
+	obj := &xmlObj{}
+	if err := xml.Unmarshal(data, obj); err != nil {
+		return nil, fmt.Errorf("XMLObj::Unmarshal, %s", err.Error())
+	}
+	return obj, nil
+}
+
+// NewXMLObjFromDcmObj - Creates a new xmlObj and parses DcmObj into it
+func NewXMLObjFromDcmObj(dcm *DcmObj, opt ...*XMLOptions) (XMLObj, error) {
+	options := &XMLOptions{}
+	if len(opt) > 0 && opt[0] != nil {
+		options = opt[0]
+	}
+	attributes, err := xmlAttributes(dcm, options, "")
+	if err != nil {
+		return nil, err
+	}
+	return &xmlObj{Space: "preserve", Attributes: attributes}, nil
+}
+
+// GetAttributes - the tags, in the order of the DICOM object
+func (x *xmlObj) GetAttributes() []XMLAttribute {
+	return x.Attributes
+}
+
+// ToDcmObj - DICOM object with the tags in the order of the document
+func (x *xmlObj) ToDcmObj(opt ...*XMLOptions) (*DcmObj, error) {
+	options := &XMLOptions{}
+	if len(opt) > 0 && opt[0] != nil {
+		options = opt[0]
+	}
+	ts := options.TransferSyntax
+	if ts == nil {
+		ts = transfersyntax.ExplicitVRLittleEndian
+	}
+	obj := NewEmptyDCMObj()
+	obj.SetTransferSyntax(ts)
+	if err := fillXMLAttributes(obj, x.Attributes, options); err != nil {
+		return nil, err
+	}
+	if err := obj.fromUTF8(""); err != nil {
+		return nil, fmt.Errorf("XMLObj::ToDcmObj, %s", err.Error())
+	}
+	return obj, nil
+}
+
+func xmlAttributes(obj *DcmObj, opt *XMLOptions, charset string) ([]XMLAttribute, error) {
+	attributes := make([]XMLAttribute, 0, len(obj.Tags))
+	charset = obj.characterSet(charset)
+	var order byteOrder = binary.LittleEndian
+	if obj.IsBigEndian() {
+		order = binary.BigEndian
+	}
+	for i := 0; i < len(obj.Tags); i++ {
+		tag := obj.Tags[i]
+		// Group lengths and delimiters are not encoded
+		if tag.Element == 0x0000 || tag.Group == 0xFFFE {
+			continue
+		}
+		vr := singleVR(tag.VR)
+		attribute := XMLAttribute{
+			Tag:            fmt.Sprintf("%04X%04X", tag.Group, tag.Element),
+			VR:             vr,
+			PrivateCreator: obj.privateCreator(tag),
+		}
+		if tag.Group%2 == 0 && tag.Name != "" && tag.Name != "Unknown" {
+			attribute.Keyword = tag.Name
+		}
+		switch {
+		case vr == "SQ":
+			for n, item := range tag.Items {
+				itemAttributes, err := xmlAttributes(item, opt, charset)
+				if err != nil {
+					return nil, err
+				}
+				attribute.Items = append(attribute.Items, XMLItem{Number: n + 1, Attributes: itemAttributes})
+			}
+		case isBinaryVR(vr):
+			var uri string
+			uri, attribute.InlineBinary, i = obj.encodeBinaryValue(i, opt.BulkDataThreshold, opt.BulkDataURI)
+			if uri != "" {
+				attribute.BulkData = &XMLBulkData{URI: uri}
+			}
+		default:
+			text, err := utf8Tag(tag, vr, charset)
+			var values []string
+			if err == nil {
+				values, err = xmlValues(text, vr, order)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("XMLObj::Encode, (%04X,%04X) %s", tag.Group, tag.Element, err.Error())
+			}
+			for n, value := range values {
+				if vr == "PN" {
+					attribute.PersonNames = append(attribute.PersonNames, xmlPersonName(n+1, value))
+				} else {
+					attribute.Values = append(attribute.Values, XMLValue{Number: n + 1, Value: value})
+				}
+			}
+		}
+		attributes = append(attributes, attribute)
+	}
+	return attributes, nil
+}
+
+// privateCreator - value of the private creator reserving the block of a private tag
+func (obj *DcmObj) privateCreator(tag *DcmTag) string {
+	if tag.Group%2 == 0 || tag.Element < 0x1000 {
+		return ""
+	}
+	return obj.getStringGE(tag.Group, tag.Element>>8)
+}
+
+// xmlValues - the values of the tags with a string or numeric VR. Only the padding is removed, the values are kept as is
+func xmlValues(tag *DcmTag, vr string, order byteOrder) ([]string, error) {
+	data := tag.Data
+	if int(tag.Length) < len(data) {
+		data = data[:tag.Length]
+	}
+	if len(data) == 0 {
+		return nil, nil
+	}
+	switch vr {
+	case "AT", "US", "SS", "UL", "SL", "UV", "SV", "FL", "FD":
+		numbers, err := jsonValues(tag, vr, order)
+		if err != nil {
+			return nil, err
+		}
+		values := make([]string, 0, len(numbers))
+		for _, n := range numbers {
+			values = append(values, fmt.Sprint(n))
+		}
+		return values, nil
+	}
+	content := string(data)
+	if last := content[len(content)-1]; last == ' ' || last == 0x00 {
+		content = content[:len(content)-1]
+	}
+	if vr == "LT" || vr == "ST" || vr == "UT" || vr == "UR" {
+		return []string{content}, nil
+	}
+	return strings.Split(content, "\\"), nil
+}
+
+// xmlPersonName - the component groups and components present in value, even empty, are kept
+func xmlPersonName(number int, value string) XMLPersonName {
+	pn := XMLPersonName{Number: number}
+	if value == "" {
+		return pn
+	}
+	groups := []**XMLNameComponents{&pn.Alphabetic, &pn.Ideographic, &pn.Phonetic}
+	for i, group := range strings.SplitN(value, "=", len(groups)) {
+		components := &XMLNameComponents{}
+		fields := []**string{&components.FamilyName, &components.GivenName, &components.MiddleName, &components.NamePrefix, &components.NameSuffix}
+		if group != "" {
+			for j, component := range strings.SplitN(group, "^", len(fields)) {
+				c := component
+				*fields[j] = &c
+			}
+		}
+		*groups[i] = components
+	}
+	return pn
+}
+
+// String - the PN value
+func (pn XMLPersonName) String() string {
+	groups := make([]string, 0, 3)
+	for i, components := range []*XMLNameComponents{pn.Alphabetic, pn.Ideographic, pn.Phonetic} {
+		if components == nil {
+			continue
+		}
+		for len(groups) < i {
+			groups = append(groups, "")
+		}
+		groups = append(groups, components.String())
+	}
+	return strings.Join(groups, "=")
+}
+
+// String - the PN component group
+func (c *XMLNameComponents) String() string {
+	fields := make([]string, 0, 5)
+	for i, field := range []*string{c.FamilyName, c.GivenName, c.MiddleName, c.NamePrefix, c.NameSuffix} {
+		if field == nil {
+			continue
+		}
+		for len(fields) < i {
+			fields = append(fields, "")
+		}
+		fields = append(fields, *field)
+	}
+	return strings.Join(fields, "^")
+}
+
+func fillXMLAttributes(obj *DcmObj, attributes []XMLAttribute, opt *XMLOptions) error {
+	var order byteOrder = binary.LittleEndian
+	if obj.IsBigEndian() {
+		order = binary.BigEndian
+	}
+	for _, attribute := range attributes {
+		tagID, err := strconv.ParseUint(attribute.Tag, 16, 32)
+		if err != nil || len(attribute.Tag) != 8 {
+			return fmt.Errorf("XMLObj::ToDcmObj, invalid tag %s", attribute.Tag)
+		}
+		group := uint16(tagID >> 16)
+		element := uint16(tagID)
+		if attribute.VR == "SQ" {
+			items := make([]*DcmObj, 0, len(attribute.Items))
+			for _, xi := range attribute.Items {
+				item := NewEmptyDCMObj()
+				item.SetExplicitVR(obj.IsExplicitVR())
+				item.SetBigEndian(obj.IsBigEndian())
+				if err := fillXMLAttributes(item, xi.Attributes, opt); err != nil {
+					return err
+				}
+				items = append(items, item)
+			}
+			obj.WriteSeq(&tags.Tag{Group: group, Element: element}, items)
+			continue
+		}
+		tag := &DcmTag{
+			Group:     group,
+			Element:   element,
+			VR:        attribute.VR,
+			BigEndian: obj.IsBigEndian(),
+		}
+		var data []byte
+		switch {
+		case attribute.InlineBinary != "":
+			data, err = base64.StdEncoding.DecodeString(strings.TrimSpace(attribute.InlineBinary))
+		case attribute.BulkData != nil && opt.LoadBulkData != nil:
+			data, err = opt.LoadBulkData(attribute.BulkData.URI)
+		case len(attribute.PersonNames) > 0:
+			values := make([]string, 0, len(attribute.PersonNames))
+			for _, pn := range attribute.PersonNames {
+				if values, err = setXMLValue(values, len(attribute.PersonNames), pn.Number, pn.String()); err != nil {
+					break
+				}
+			}
+			data = []byte(strings.Join(values, "\\"))
+		case len(attribute.Values) > 0:
+			values := make([]string, 0, len(attribute.Values))
+			for _, v := range attribute.Values {
+				if values, err = setXMLValue(values, len(attribute.Values), v.Number, v.Value); err != nil {
+					break
+				}
+			}
+			if err == nil {
+				data, err = dicomXMLValue(attribute.VR, values, order)
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("XMLObj::ToDcmObj, %s %s", attribute.Tag, err.Error())
+		}
+		obj.addValue(tag, data)
+	}
+	return nil
+}
+
+// setXMLValue - sets the value numbered from 1, in document order when not numbered. The number
+// cannot exceed count, the number of values of the attribute
+func setXMLValue(values []string, count int, number int, value string) ([]string, error) {
+	if number <= 0 {
+		return append(values, value), nil
+	}
+	if number > count {
+		return values, fmt.Errorf("value number %d out of %d values", number, count)
+	}
+	for len(values) < number {
+		values = append(values, "")
+	}
+	values[number-1] = value
+	return values, nil
+}
+
+// dicomXMLValue - data of the tags with a string or numeric VR
+func dicomXMLValue(vr string, values []string, order byteOrder) ([]byte, error) {
+	switch vr {
+	case "AT":
+		data := make([]byte, 0, 4*len(values))
+		for _, v := range values {
+			t, err := strconv.ParseUint(strings.TrimSpace(v), 16, 32)
+			if err != nil {
+				return nil, fmt.Errorf("invalid AT %s", v)
+			}
+			data = order.AppendUint16(data, uint16(t>>16))
+			data = order.AppendUint16(data, uint16(t))
+		}
+		return data, nil
+	case "US", "SS", "UL", "SL", "UV", "SV", "FL", "FD":
+		data := make([]byte, 0)
+		for _, v := range values {
+			var err error
+			if data, err = appendNumber(data, vr, strings.TrimSpace(v), order); err != nil {
+				return nil, err
+			}
+		}
+		return data, nil
+	}
+	return []byte(strings.Join(values, "\\")), nil
+}
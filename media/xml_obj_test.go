@@ -0,0 +1,217 @@
+package media
+
+import (
+	"encoding/xml"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/t2care/obd-dicom/dictionary/tags"
+)
+
+func TestXMLObjFromFile(t *testing.T) {
+	for _, fileName := range []string{"../samples/test2.dcm", "../samples/test.dcm", "../samples/rle_gray.dcm", "../samples/test-losslessSV1.dcm"} {
+		t.Run(fileName, func(t *testing.T) {
+			dcmObj, err := NewDCMObjFromFile(fileName)
+			if !assert.NoError(t, err) {
+				return
+			}
+			xmlObj, err := NewXMLObjFromDcmObj(dcmObj)
+			if !assert.NoError(t, err) {
+				return
+			}
+			data, err := xml.Marshal(xmlObj)
+			assert.NoError(t, err)
+
+			// XML -> DICOM file -> XML gives the same XML
+			parsed, err := NewXMLObjFromBytes(data)
+			assert.NoError(t, err)
+			decoded, err := parsed.ToDcmObj(&XMLOptions{TransferSyntax: dcmObj.GetTransferSyntax()})
+			if !assert.NoError(t, err) {
+				return
+			}
+			// Same dataset as the original file, but for what the model does not keep
+			original, err := NewDCMObjFromFile(fileName)
+			assert.NoError(t, err)
+			assertNativeModel(t, original, decoded)
+			reread, err := NewDCMObjFromBytes(decoded.WriteToBytes())
+			if !assert.NoError(t, err) {
+				return
+			}
+			reencoded, err := NewXMLObjFromDcmObj(reread)
+			assert.NoError(t, err)
+			redata, err := xml.Marshal(reencoded)
+			assert.NoError(t, err)
+			assert.Equal(t, string(data), string(redata))
+		})
+	}
+}
+
+// assertNativeModel - decoded has the tags, VRs, items and values of original. The Native DICOM Model does not keep
+// the group lengths, the undefined lengths of the sequences and items, and the padding character of the values
+func assertNativeModel(t *testing.T, original, decoded *DcmObj) {
+	kept := make([]*DcmTag, 0, original.TagCount())
+	for _, tag := range original.GetTags() {
+		if tag.Element != 0x0000 {
+			kept = append(kept, tag)
+		}
+	}
+	if !assert.Len(t, decoded.GetTags(), len(kept)) {
+		return
+	}
+	for i, tag := range kept {
+		got := decoded.GetTagAt(i)
+		name := fmt.Sprintf("(%04X,%04X)", tag.Group, tag.Element)
+		assert.Equal(t, [2]uint16{tag.Group, tag.Element}, [2]uint16{got.Group, got.Element}, name)
+		assert.Equal(t, singleVR(tag.VR), singleVR(got.VR), name)
+		if tag.isSequence() {
+			if assert.Len(t, got.Items, len(tag.Items), name) {
+				for j, item := range tag.Items {
+					assertNativeModel(t, item, got.Items[j])
+				}
+			}
+			continue
+		}
+		assert.Equal(t, unpadded(tag), unpadded(got), name)
+	}
+}
+
+// unpadded - the data of the tag without the padding of its text value
+func unpadded(tag *DcmTag) []byte {
+	data := tag.Data
+	if len(data) == 0 {
+		return nil
+	}
+	if vr := singleVR(tag.VR); isBinaryVR(vr) || binaryVRSize(vr) > 1 {
+		return data
+	}
+	if len(data) > 0 && (data[len(data)-1] == 0x00 || data[len(data)-1] == ' ') {
+		return data[:len(data)-1]
+	}
+	return data
+}
+
+func TestXMLObjValues(t *testing.T) {
+	item := NewEmptyDCMObj()
+	item.SetExplicitVR(true)
+	item.WriteString(tags.CodeMeaning, "Brain")
+
+	obj := NewEmptyDCMObj()
+	obj.SetExplicitVR(true)
+	obj.WriteString(tags.PatientName, "Doe^John^^^=^Jean")
+	obj.WriteString(tags.ImageType, `ORIGINAL\\PRIMARY`)
+	obj.WriteUint16(tags.Rows, 512)
+	obj.WriteSeq(tags.AnatomicRegionSequence, []*DcmObj{item})
+	obj.Add(&DcmTag{Group: 0x0009, Element: 0x0010, VR: "LO", Length: 4, Data: []byte("ACME")})
+	obj.Add(&DcmTag{Group: 0x0009, Element: 0x1001, VR: "OB", Length: 6, Data: []byte{1, 2, 3, 4, 5, 6}})
+
+	xmlObj, err := NewXMLObjFromDcmObj(obj, &XMLOptions{
+		BulkDataURI: func(tag *DcmTag) string {
+			return fmt.Sprintf("https://pacs/bulk/%04X%04X", tag.Group, tag.Element)
+		},
+	})
+	assert.NoError(t, err)
+	data, err := xml.Marshal(xmlObj)
+	assert.NoError(t, err)
+	assert.Equal(t, `<NativeDicomModel xmlns="http://dicom.nema.org/PS3.19/models/NativeDICOM" xml:space="preserve">`+
+		`<DicomAttribute tag="00100010" vr="PN" keyword="PatientName"><PersonName number="1">`+
+		`<Alphabetic><FamilyName>Doe</FamilyName><GivenName>John</GivenName><MiddleName></MiddleName><NamePrefix></NamePrefix><NameSuffix></NameSuffix></Alphabetic>`+
+		`<Ideographic><FamilyName></FamilyName><GivenName>Jean</GivenName></Ideographic></PersonName></DicomAttribute>`+
+		`<DicomAttribute tag="00080008" vr="CS" keyword="ImageType"><Value number="1">ORIGINAL</Value><Value number="2"></Value><Value number="3">PRIMARY</Value></DicomAttribute>`+
+		`<DicomAttribute tag="00280010" vr="US" keyword="Rows"><Value number="1">512</Value></DicomAttribute>`+
+		`<DicomAttribute tag="00082218" vr="SQ" keyword="AnatomicRegionSequence"><Item number="1">`+
+		`<DicomAttribute tag="00080104" vr="LO" keyword="CodeMeaning"><Value number="1">Brain</Value></DicomAttribute></Item></DicomAttribute>`+
+		`<DicomAttribute tag="00090010" vr="LO"><Value number="1">ACME</Value></DicomAttribute>`+
+		`<DicomAttribute tag="00091001" vr="OB" privateCreator="ACME"><BulkData uri="https://pacs/bulk/00091001"></BulkData></DicomAttribute>`+
+		`</NativeDicomModel>`, string(data))
+
+	parsed, err := NewXMLObjFromBytes(data)
+	assert.NoError(t, err)
+	decoded, err := parsed.ToDcmObj(&XMLOptions{LoadBulkData: func(uri string) ([]byte, error) {
+		return []byte{1, 2, 3, 4, 5, 6}, nil
+	}})
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "Doe^John^^^=^Jean", decoded.GetString(tags.PatientName))
+	assert.Equal(t, `ORIGINAL\\PRIMARY`, decoded.GetString(tags.ImageType))
+	assert.Equal(t, uint16(512), decoded.GetUShort(tags.Rows))
+	assert.Equal(t, []byte{1, 2, 3, 4, 5, 6}, decoded.GetTagGE(0x0009, 0x1001).Data)
+	items, err := decoded.GetSeq(tags.AnatomicRegionSequence)
+	if assert.NoError(t, err) && assert.Len(t, items, 1) {
+		assert.Equal(t, "Brain", items[0].GetString(tags.CodeMeaning))
+	}
+
+	// Value numbers beyond the values of the attribute are rejected
+	for _, value := range []string{`<Value number="1000000000">ORIGINAL</Value>`, `<PersonName number="3"><Alphabetic><FamilyName>Doe</FamilyName></Alphabetic></PersonName>`} {
+		parsed, err = NewXMLObjFromBytes([]byte(`<NativeDicomModel xmlns="http://dicom.nema.org/PS3.19/models/NativeDICOM"><DicomAttribute tag="00080008" vr="CS">` + value + `</DicomAttribute></NativeDicomModel>`))
+		if assert.NoError(t, err) {
+			_, err = parsed.ToDcmObj()
+			assert.ErrorContains(t, err, "XMLObj::ToDcmObj, 00080008")
+		}
+	}
+}
+
+func TestXMLObjCharacterSet(t *testing.T) {
+	item := NewEmptyDCMObj()
+	item.SetExplicitVR(true)
+	item.WriteString(tags.CodeMeaning, "T\xeate")
+
+	obj := NewEmptyDCMObj()
+	obj.SetExplicitVR(true)
+	obj.WriteString(tags.SpecificCharacterSet, "ISO_IR 100")
+	obj.WriteString(tags.PatientName, "Fran\xe7ois^Ren\xe9e")
+	obj.WriteSeq(tags.AnatomicRegionSequence, []*DcmObj{item})
+
+	xmlObj, err := NewXMLObjFromDcmObj(obj)
+	assert.NoError(t, err)
+	data, err := xml.Marshal(xmlObj)
+	assert.NoError(t, err)
+	// UTF-8 in the document, items inherit the character set
+	assert.Contains(t, string(data), "<FamilyName>François</FamilyName><GivenName>Renée</GivenName>")
+	assert.Contains(t, string(data), `<Value number="1">Tête</Value>`)
+
+	parsed, err := NewXMLObjFromBytes(data)
+	assert.NoError(t, err)
+	decoded, err := parsed.ToDcmObj()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, obj.GetTag(tags.PatientName).Data, decoded.GetTag(tags.PatientName).Data)
+	items, err := decoded.GetSeq(tags.AnatomicRegionSequence)
+	if assert.NoError(t, err) && assert.Len(t, items, 1) {
+		assert.Equal(t, item.GetTag(tags.CodeMeaning).Data, items[0].GetTag(tags.CodeMeaning).Data)
+	}
+
+	// Not in ISO_IR 100
+	parsed, err = NewXMLObjFromBytes([]byte(`<NativeDicomModel xmlns="http://dicom.nema.org/PS3.19/models/NativeDICOM">` +
+		`<DicomAttribute tag="00080005" vr="CS"><Value number="1">ISO_IR 100</Value></DicomAttribute>` +
+		`<DicomAttribute tag="00100020" vr="LO"><Value number="1">雪</Value></DicomAttribute></NativeDicomModel>`))
+	assert.NoError(t, err)
+	_, err = parsed.ToDcmObj()
+	assert.Error(t, err)
+
+	// Values in ASCII are the same in all the character sets, the others are only converted from the supported ones
+	for charset, supported := range map[string]bool{"": false, "ISO_IR 100": true, "ISO_IR 192": false, "ISO_IR 144": false} {
+		obj := NewEmptyDCMObj()
+		obj.SetExplicitVR(true)
+		if charset != "" {
+			obj.WriteString(tags.SpecificCharacterSet, charset)
+		}
+		obj.WriteString(tags.PatientName, "Doe^John")
+		_, err := NewXMLObjFromDcmObj(obj)
+		assert.NoError(t, err, charset)
+		obj.WriteString(tags.PatientName, "Fran\xe7ois")
+		_, err = NewXMLObjFromDcmObj(obj)
+		assert.Equal(t, supported, err == nil, charset)
+	}
+	for charset, supported := range map[string]bool{"": false, "ISO_IR 100": true, "ISO_IR 192": true, "ISO_IR 144": false} {
+		parsed, err := NewXMLObjFromBytes([]byte(`<NativeDicomModel xmlns="http://dicom.nema.org/PS3.19/models/NativeDICOM">` +
+			`<DicomAttribute tag="00080005" vr="CS"><Value number="1">` + charset + `</Value></DicomAttribute>` +
+			`<DicomAttribute tag="00100020" vr="LO"><Value number="1">François</Value></DicomAttribute></NativeDicomModel>`))
+		if assert.NoError(t, err) {
+			_, err = parsed.ToDcmObj()
+			assert.Equal(t, supported, err == nil, charset)
+		}
+	}
+}
@@ -2,6 +2,8 @@ package network
 
 import (
 	"bufio"
+	"context"
+	"crypto/x509"
 	"errors"
 	"fmt"
 	"log/slog"
@@ -14,18 +16,21 @@ import (
 )
 
 type AAssociationRQ struct {
-	ItemType        byte // 0x01
-	Reserved1       byte
-	Length          uint32
-	ProtocolVersion uint16 // 0x01
-	Reserved2       uint16
-	CallingAE       [16]byte // 16 bytes transfered
-	CalledAE        [16]byte // 16 bytes transfered
-	Reserved3       [32]byte
-	AppContext      *uidItem
-	PresContexts    []*presentationContext
-	UserInfo        *userInformation
-	ID              int64
+	ItemType         byte // 0x01
+	Reserved1        byte
+	Length           uint32
+	ProtocolVersion  uint16 // 0x01
+	Reserved2        uint16
+	CallingAE        [16]byte // 16 bytes transfered
+	CalledAE         [16]byte // 16 bytes transfered
+	Reserved3        [32]byte
+	AppContext       *uidItem
+	PresContexts     []*presentationContext
+	UserInfo         *userInformation
+	ID               int64
+	peerCertificates []*x509.Certificate
+	ctx              context.Context
+	identityResponse []byte
 }
 
 // NewAAssociationRQ - NewAAssociationRQ
@@ -226,10 +231,54 @@ func (aarq *AAssociationRQ) Read(ms *media.MemoryStream) (err error) {
 	return errors.New("aarq::ReadDynamic, Count is not zero")
 }
 
+// AddPresContexts - proposes the presentation context, given the next odd ID unless it has one
 func (aarq *AAssociationRQ) AddPresContexts(presentationContext *presentationContext) {
+	if presentationContext.GetPresentationContextID() == 0 {
+		presentationContext.SetPresentationContextID(byte(2*len(aarq.PresContexts) + 1))
+	}
 	aarq.PresContexts = append(aarq.PresContexts, presentationContext)
 }
 
 func (aarq *AAssociationRQ) GetID() int64 {
 	return aarq.ID
 }
+
+// GetPeerCertificates - certificates presented by the peer over TLS, nil on a plain connection
+func (aarq *AAssociationRQ) GetPeerCertificates() []*x509.Certificate {
+	return aarq.peerCertificates
+}
+
+// GetUserIdentity - User Identity proposed by the requestor, nil when not sent
+func (aarq *AAssociationRQ) GetUserIdentity() *userIdentity {
+	return aarq.UserInfo.GetUserIdentity()
+}
+
+// GetExtendedNegotiation - Service-class-application-information proposed for the SOP class, nil when not sent.
+// See ParseQueryRetrieveOptions
+func (aarq *AAssociationRQ) GetExtendedNegotiation(sopClassUID string) []byte {
+	return aarq.UserInfo.GetExtendedNegotiation(sopClassUID)
+}
+
+// GetCommonExtendedNegotiation - Service class and related general SOP classes of the SOP class, nil when not sent
+func (aarq *AAssociationRQ) GetCommonExtendedNegotiation(sopClassUID string) *sopClassCommonExtendedNegotiation {
+	for _, ext := range aarq.UserInfo.GetCommonExtendedNegotiations() {
+		if ext.GetUID() == sopClassUID {
+			return ext
+		}
+	}
+	return nil
+}
+
+// SetUserIdentityResponse - Server response sent in the A-ASSOCIATE-AC when the requestor asks for a positive response,
+// eg. the Kerberos server ticket or the SAML response. Empty for a username
+func (aarq *AAssociationRQ) SetUserIdentityResponse(serverResponse []byte) {
+	aarq.identityResponse = serverResponse
+}
+
+// Context - cancelled by a C-CANCEL-RQ of the C-FIND or C-MOVE being performed, or when the association ends
+func (aarq *AAssociationRQ) Context() context.Context {
+	if aarq.ctx == nil {
+		return context.Background()
+	}
+	return aarq.ctx
+}
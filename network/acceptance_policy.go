@@ -0,0 +1,123 @@
+package network
+
+import (
+	"github.com/t2care/obd-dicom/dictionary/sopclass"
+	"github.com/t2care/obd-dicom/dictionary/transfersyntax"
+)
+
+// Presentation context results of an A-ASSOCIATE-AC
+const (
+	PresentationContextAccepted                     byte = 0
+	PresentationContextUserRejection                byte = 1
+	PresentationContextNoReason                     byte = 2
+	PresentationContextAbstractSyntaxNotSupported   byte = 3
+	PresentationContextTransferSyntaxesNotSupported byte = 4
+)
+
+// AcceptancePolicy - presentation contexts accepted by a SCP
+type AcceptancePolicy struct {
+	transferSyntaxes []*transfersyntax.TransferSyntax
+	sopClasses       map[string][]*transfersyntax.TransferSyntax
+	roles            map[string]*roleSelect
+	extNegotiations  map[string]func(proposed []byte) []byte
+}
+
+// NewAcceptancePolicy - Accepts every SOP class until one is added, with the given transfer syntaxes in order of preference.
+// Without transfer syntaxes, the first proposed one that can be decoded is accepted
+func NewAcceptancePolicy(transferSyntaxes ...*transfersyntax.TransferSyntax) *AcceptancePolicy {
+	return &AcceptancePolicy{
+		transferSyntaxes: transferSyntaxes,
+		sopClasses:       make(map[string][]*transfersyntax.TransferSyntax),
+		roles:            make(map[string]*roleSelect),
+		extNegotiations:  make(map[string]func(proposed []byte) []byte),
+	}
+}
+
+// AddSOPClass - Accepts the SOP class with its own transfer syntaxes in order of preference, the policy ones otherwise
+func (p *AcceptancePolicy) AddSOPClass(sopClass *sopclass.SOPClass, transferSyntaxes ...*transfersyntax.TransferSyntax) {
+	p.sopClasses[sopClass.UID] = transferSyntaxes
+}
+
+// AddSOPClasses - Accepts all the SOP classes with the same transfer syntaxes, eg. sopclass.DcmShortSCUStorageSOPClassUIDs
+func (p *AcceptancePolicy) AddSOPClasses(sopClasses []*sopclass.SOPClass, transferSyntaxes ...*transfersyntax.TransferSyntax) {
+	for _, sopClass := range sopClasses {
+		p.AddSOPClass(sopClass, transferSyntaxes...)
+	}
+}
+
+// Accept - Result of a presentation context and the accepted transfer syntax
+func (p *AcceptancePolicy) Accept(abstractSyntax string, proposed []string) (byte, string) {
+	preferred := p.transferSyntaxes
+	if len(p.sopClasses) > 0 {
+		transferSyntaxes, ok := p.sopClasses[abstractSyntax]
+		if !ok {
+			return PresentationContextAbstractSyntaxNotSupported, ""
+		}
+		if len(transferSyntaxes) > 0 {
+			preferred = transferSyntaxes
+		}
+	}
+	if len(preferred) == 0 {
+		for _, ts := range proposed {
+			if transfersyntax.SupportedTransferSyntax(ts) {
+				return PresentationContextAccepted, ts
+			}
+		}
+		return PresentationContextTransferSyntaxesNotSupported, ""
+	}
+	for _, ts := range preferred {
+		for _, uid := range proposed {
+			if ts.UID == uid {
+				return PresentationContextAccepted, uid
+			}
+		}
+	}
+	return PresentationContextTransferSyntaxesNotSupported, ""
+}
+
+// AddRole - Roles the requestor may take for the SOP class when it proposes them, eg. scpRole for the storage classes of a C-GET.
+// Every proposed role is granted until one is added, the others are then only granted the SCU role
+func (p *AcceptancePolicy) AddRole(sopClass *sopclass.SOPClass, scuRole bool, scpRole bool) {
+	p.roles[sopClass.UID] = NewRoleSelectUID(sopClass.UID, roleValue(scuRole), roleValue(scpRole))
+}
+
+// AddRoles - Same roles for all the SOP classes, eg. sopclass.DcmShortSCUStorageSOPClassUIDs
+func (p *AcceptancePolicy) AddRoles(sopClasses []*sopclass.SOPClass, scuRole bool, scpRole bool) {
+	for _, sopClass := range sopClasses {
+		p.AddRole(sopClass, scuRole, scpRole)
+	}
+}
+
+// Role - SCU and SCP roles granted to the requestor among the proposed ones
+func (p *AcceptancePolicy) Role(sopClassUID string, scuRole byte, scpRole byte) (byte, byte) {
+	if len(p.roles) == 0 {
+		return scuRole, scpRole
+	}
+	role, ok := p.roles[sopClassUID]
+	if !ok {
+		return scuRole, 0
+	}
+	return scuRole & role.SCURole, scpRole & role.SCPRole
+}
+
+// AddExtendedNegotiation - Answers the SOP Class Extended Negotiation proposed for the SOP class, nil is no answer.
+// eg. QueryRetrieveOptions supported among the proposed ones, or the StorageOptions of the SCP
+func (p *AcceptancePolicy) AddExtendedNegotiation(sopClass *sopclass.SOPClass, answer func(proposed []byte) []byte) {
+	p.extNegotiations[sopClass.UID] = answer
+}
+
+// ExtendedNegotiation - Service-class-application-information answered, nil when the SOP class has none
+func (p *AcceptancePolicy) ExtendedNegotiation(sopClassUID string, proposed []byte) []byte {
+	answer, ok := p.extNegotiations[sopClassUID]
+	if !ok {
+		return nil
+	}
+	return answer(proposed)
+}
+
+func roleValue(granted bool) byte {
+	if granted {
+		return 1
+	}
+	return 0
+}
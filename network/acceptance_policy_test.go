@@ -0,0 +1,98 @@
+package network
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/t2care/obd-dicom/dictionary/sopclass"
+	"github.com/t2care/obd-dicom/dictionary/transfersyntax"
+)
+
+func TestAcceptancePolicy(t *testing.T) {
+	restricted := NewAcceptancePolicy(transfersyntax.ImplicitVRLittleEndian)
+	restricted.AddSOPClass(sopclass.Verification)
+	restricted.AddSOPClass(sopclass.CTImageStorage, transfersyntax.ExplicitVRLittleEndian, transfersyntax.ImplicitVRLittleEndian)
+	tests := []struct {
+		name     string
+		policy   *AcceptancePolicy
+		sopClass *sopclass.SOPClass
+		proposed []*transfersyntax.TransferSyntax
+		result   byte
+		ts       *transfersyntax.TransferSyntax
+	}{
+		{
+			name:     "Default should accept the first decodable transfer syntax",
+			policy:   NewAcceptancePolicy(),
+			sopClass: sopclass.MRImageStorage,
+			proposed: []*transfersyntax.TransferSyntax{transfersyntax.JPEG2000, transfersyntax.ExplicitVRLittleEndian, transfersyntax.ImplicitVRLittleEndian},
+			result:   PresentationContextAccepted,
+			ts:       transfersyntax.ExplicitVRLittleEndian,
+		},
+		{
+			name:     "Default should reject undecodable transfer syntaxes",
+			policy:   NewAcceptancePolicy(),
+			sopClass: sopclass.MRImageStorage,
+			proposed: []*transfersyntax.TransferSyntax{transfersyntax.JPEG2000},
+			result:   PresentationContextTransferSyntaxesNotSupported,
+		},
+		{
+			name:     "Should reject unsupported SOP class",
+			policy:   restricted,
+			sopClass: sopclass.MRImageStorage,
+			proposed: []*transfersyntax.TransferSyntax{transfersyntax.ImplicitVRLittleEndian},
+			result:   PresentationContextAbstractSyntaxNotSupported,
+		},
+		{
+			name:     "Should accept the preferred transfer syntax of the SOP class",
+			policy:   restricted,
+			sopClass: sopclass.CTImageStorage,
+			proposed: []*transfersyntax.TransferSyntax{transfersyntax.ImplicitVRLittleEndian, transfersyntax.ExplicitVRLittleEndian},
+			result:   PresentationContextAccepted,
+			ts:       transfersyntax.ExplicitVRLittleEndian,
+		},
+		{
+			name:     "Should use the policy transfer syntaxes",
+			policy:   restricted,
+			sopClass: sopclass.Verification,
+			proposed: []*transfersyntax.TransferSyntax{transfersyntax.ExplicitVRLittleEndian},
+			result:   PresentationContextTransferSyntaxesNotSupported,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proposed := []string{}
+			for _, ts := range tt.proposed {
+				proposed = append(proposed, ts.UID)
+			}
+			result, ts := tt.policy.Accept(tt.sopClass.UID, proposed)
+			assert.Equal(t, tt.result, result)
+			if tt.ts != nil {
+				assert.Equal(t, tt.ts.UID, ts)
+			}
+		})
+	}
+}
+
+func TestAcceptancePolicyRole(t *testing.T) {
+	restricted := NewAcceptancePolicy()
+	restricted.AddRole(sopclass.CTImageStorage, false, true)
+	tests := []struct {
+		name     string
+		policy   *AcceptancePolicy
+		sopClass *sopclass.SOPClass
+		scuRole  byte
+		scpRole  byte
+		wantSCU  byte
+		wantSCP  byte
+	}{
+		{name: "Default should grant the proposed roles", policy: NewAcceptancePolicy(), sopClass: sopclass.MRImageStorage, scuRole: 1, scpRole: 1, wantSCU: 1, wantSCP: 1},
+		{name: "Should grant the SCP role", policy: restricted, sopClass: sopclass.CTImageStorage, scuRole: 1, scpRole: 1, wantSCU: 0, wantSCP: 1},
+		{name: "Should not grant a role not proposed", policy: restricted, sopClass: sopclass.CTImageStorage, scuRole: 1, scpRole: 0, wantSCU: 0, wantSCP: 0},
+		{name: "Should only grant the SCU role to other SOP classes", policy: restricted, sopClass: sopclass.MRImageStorage, scuRole: 1, scpRole: 1, wantSCU: 1, wantSCP: 0},
+	}
+	for _, tt := range tests {
+		scuRole, scpRole := tt.policy.Role(tt.sopClass.UID, tt.scuRole, tt.scpRole)
+		assert.Equal(t, tt.wantSCU, scuRole, tt.name)
+		assert.Equal(t, tt.wantSCP, scpRole, tt.name)
+	}
+}
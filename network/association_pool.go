@@ -0,0 +1,199 @@
+package network
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/t2care/obd-dicom/dictionary/sopclass"
+	"github.com/t2care/obd-dicom/media"
+	"github.com/t2care/obd-dicom/network/dicomcommand"
+)
+
+// AssociationPool - Idle associations kept open for the next operations of the SCUs sharing the pool.
+// They are reused for the same destination and the same proposed contexts, and are safe for concurrent use
+type AssociationPool struct {
+	mu                  sync.Mutex
+	idle                map[string][]*pooledAssociation
+	maxIdle             int
+	idleTimeout         time.Duration
+	healthCheckInterval time.Duration
+	closed              bool
+}
+
+type pooledAssociation struct {
+	pdu   *pduService
+	since time.Time
+	timer *time.Timer
+}
+
+// NewAssociationPool - keeps up to maxIdle idle associations per destination and contexts, each released after idleTimeout.
+// 0 is unlimited for both
+func NewAssociationPool(maxIdle int, idleTimeout time.Duration) *AssociationPool {
+	return &AssociationPool{
+		idle:        make(map[string][]*pooledAssociation),
+		maxIdle:     maxIdle,
+		idleTimeout: idleTimeout,
+	}
+}
+
+// SetHealthCheckInterval - an association idle for longer is checked with a C-ECHO before being reused, 0 never
+func (p *AssociationPool) SetHealthCheckInterval(interval time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.healthCheckInterval = interval
+}
+
+// Idle - number of idle associations
+func (p *AssociationPool) Idle() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	count := 0
+	for _, entries := range p.idle {
+		count += len(entries)
+	}
+	return count
+}
+
+// Close - releases the idle associations, the ones in use are released once their operation is done
+func (p *AssociationPool) Close() {
+	p.mu.Lock()
+	p.closed = true
+	var entries []*pooledAssociation
+	for key, idle := range p.idle {
+		entries = append(entries, idle...)
+		delete(p.idle, key)
+	}
+	p.mu.Unlock()
+	for _, entry := range entries {
+		if entry.timer != nil && !entry.timer.Stop() {
+			continue
+		}
+		releaseIdle(entry.pdu)
+	}
+}
+
+// get - an idle association still alive, attached to the context of the operation. nil when there is none
+func (p *AssociationPool) get(ctx context.Context, key string, timeout int) *pduService {
+	for {
+		p.mu.Lock()
+		idle := p.idle[key]
+		if len(idle) == 0 {
+			p.mu.Unlock()
+			return nil
+		}
+		// Most recently used first, the oldest ones expire
+		entry := idle[len(idle)-1]
+		p.idle[key] = idle[:len(idle)-1]
+		checkInterval := p.healthCheckInterval
+		p.mu.Unlock()
+
+		if entry.timer != nil && !entry.timer.Stop() {
+			// Being released by its idle timeout
+			continue
+		}
+		if !entry.pdu.alive() {
+			slog.Info("AssociationPool: association closed by the peer", "CalledAE", entry.pdu.GetCalledAE())
+			entry.pdu.Conn.Close()
+			continue
+		}
+		entry.pdu.rearm(ctx, timeout)
+		if checkInterval > 0 && time.Since(entry.since) > checkInterval {
+			if err := echoAssociation(entry.pdu); err != nil {
+				slog.Warn("AssociationPool: health check failed", "CalledAE", entry.pdu.GetCalledAE(), "Error", err.Error())
+				entry.pdu.Conn.Close()
+				continue
+			}
+			entry.pdu.rearm(ctx, timeout)
+		}
+		return entry.pdu
+	}
+}
+
+// put - keeps the association for the next operation, released when the pool is full or closed
+func (p *AssociationPool) put(key string, pdu *pduService) {
+	if !pdu.idle() {
+		pdu.Close()
+		return
+	}
+	p.mu.Lock()
+	if p.closed || (p.maxIdle > 0 && len(p.idle[key]) >= p.maxIdle) {
+		p.mu.Unlock()
+		pdu.Close()
+		return
+	}
+	entry := &pooledAssociation{pdu: pdu, since: time.Now()}
+	if p.idleTimeout > 0 {
+		entry.timer = time.AfterFunc(p.idleTimeout, func() { p.expire(key, entry) })
+	}
+	p.idle[key] = append(p.idle[key], entry)
+	p.mu.Unlock()
+}
+
+// expire - releases an association idle for too long
+func (p *AssociationPool) expire(key string, entry *pooledAssociation) {
+	p.mu.Lock()
+	idle := p.idle[key]
+	for i, e := range idle {
+		if e == entry {
+			p.idle[key] = append(idle[:i:i], idle[i+1:]...)
+			break
+		}
+	}
+	if len(p.idle[key]) == 0 {
+		delete(p.idle, key)
+	}
+	p.mu.Unlock()
+	releaseIdle(entry.pdu)
+}
+
+// releaseIdle - the peer of an idle association may be gone, its release is not awaited for long
+func releaseIdle(pdu *pduService) {
+	pdu.Conn.SetDeadline(time.Now().Add(cancelTimeout))
+	pdu.Close()
+}
+
+// echoAssociation - C-ECHO on the Verification context of the association
+func echoAssociation(pdu *pduService) error {
+	pcid := pdu.getPresentationContextID(sopclass.Verification.UID)
+	if pcid == 0 {
+		return fmt.Errorf("serviceuser::EchoSCU, %s not accepted", sopclass.Verification.Name)
+	}
+	pdu.SetPresentationContextID(pcid)
+	if err := pdu.WriteRQ(dicomcommand.CEchoRequest, media.NewEmptyDCMObj()); err != nil {
+		return err
+	}
+	_, _, err := pdu.ReadResp()
+	return err
+}
+
+// associationKey - associations are only shared by operations proposing the same to the same destination
+func associationKey(destination *Destination, pdu *pduService) string {
+	var key strings.Builder
+	fmt.Fprintf(&key, "%s|%s|%s|%d|%t|%p|%d|%d/%d|%s/%s", destination.CalledAE, destination.CallingAE, destination.HostName, destination.Port,
+		destination.IsTLS, destination.TLSConfig, pdu.maxPDULength, pdu.maxOpsInvoked, pdu.maxOpsPerformed, pdu.impClassUID, pdu.impVersion)
+	rq := pdu.AssocRQ
+	for _, pc := range rq.GetPresContexts() {
+		fmt.Fprintf(&key, "|%s", pc.GetAbstractSyntax().GetUID())
+		for _, ts := range pc.GetTransferSyntaxes() {
+			fmt.Fprintf(&key, ",%s", ts.GetUID())
+		}
+	}
+	userInfo := rq.GetUserInformation()
+	for _, role := range userInfo.GetRoleSelects() {
+		fmt.Fprintf(&key, "|role:%s,%d,%d", role.GetUID(), role.GetSCURole(), role.GetSCPRole())
+	}
+	for _, ext := range userInfo.GetExtendedNegotiations() {
+		fmt.Fprintf(&key, "|ext:%s,%x", ext.GetUID(), ext.GetInfo())
+	}
+	for _, ext := range userInfo.GetCommonExtendedNegotiations() {
+		fmt.Fprintf(&key, "|common:%s,%s,%s", ext.GetUID(), ext.GetServiceClassUID(), strings.Join(ext.GetRelatedGeneralSOPClassUIDs(), ","))
+	}
+	if identity := userInfo.GetUserIdentity(); identity != nil {
+		fmt.Fprintf(&key, "|identity:%d,%x,%x", identity.GetUserIdentityType(), identity.GetPrimaryField(), identity.GetSecondaryField())
+	}
+	return key.String()
+}
@@ -1,6 +1,10 @@
 package network
 
-import "github.com/t2care/obd-dicom/media"
+import (
+	"bufio"
+
+	"github.com/t2care/obd-dicom/media"
+)
 
 type asyncOperationWindow struct {
 	ItemType                     byte //0x53
@@ -25,10 +29,38 @@ func (async *asyncOperationWindow) GetMaxNumberOperationsPerformed() uint16 {
 	return async.MaxNumberOperationsPerformed
 }
 
+// SetMaxNumberOperations - 0 is unlimited, the item is only sent once set
+func (async *asyncOperationWindow) SetMaxNumberOperations(invoked uint16, performed uint16) {
+	async.Length = 4
+	async.MaxNumberOperationsInvoked = invoked
+	async.MaxNumberOperationsPerformed = performed
+}
+
+// IsSet - the item was negotiated, operations are synchronous otherwise
+func (async *asyncOperationWindow) IsSet() bool {
+	return async.Length > 0
+}
+
 func (async *asyncOperationWindow) Size() uint16 {
 	return async.Length + 4
 }
 
+func (async *asyncOperationWindow) Write(rw *bufio.ReadWriter) bool {
+	bd := media.NewEmptyBufData()
+
+	bd.SetBigEndian(true)
+	bd.WriteByte(async.ItemType)
+	bd.WriteByte(async.Reserved1)
+	bd.WriteUint16(async.Length)
+	bd.WriteUint16(async.MaxNumberOperationsInvoked)
+	bd.WriteUint16(async.MaxNumberOperationsPerformed)
+
+	if err := bd.Send(rw); err != nil {
+		return false
+	}
+	return true
+}
+
 func (async *asyncOperationWindow) Read(ms *media.MemoryStream) (err error) {
 	if async.ItemType, err = ms.GetByte(); err != nil {
 		return err
@@ -51,3 +83,11 @@ func (async *asyncOperationWindow) ReadDynamic(ms *media.MemoryStream) (err erro
 	}
 	return
 }
+
+// operationsWindow - the smallest window, 0 is unlimited
+func operationsWindow(a uint16, b uint16) uint16 {
+	if a == 0 || (b != 0 && b < a) {
+		return b
+	}
+	return a
+}
@@ -1,5 +1,7 @@
 package network
 
+import "crypto/tls"
+
 // Destination - a DICOM destination
 type Destination struct {
 	ID        string
@@ -14,4 +16,5 @@ type Destination struct {
 	IsMWL     bool
 	IsTLS     bool
 	Anonymize bool
+	TLSConfig *tls.Config // Used when IsTLS, client certificates for mutual authentication
 }
@@ -39,3 +39,14 @@ const CommandDataSetTypeNonNull uint16 = 1
 // C-MOVE/C-GET-specific status codes.
 const CMoveMoveDestinationUnknown uint16 = 0xa801
 const CMoveOutOfResourcesUnableToPerformSubOperations uint16 = 0xa702
+const CMoveWarningOneOrMoreFailures uint16 = 0xb000
+
+// C-STORE-specific status codes.
+const CStoreWarningCoercionOfDataElements uint16 = 0xb000
+const CStoreWarningElementsDiscarded uint16 = 0xb006
+const CStoreWarningDataSetDoesNotMatchSOPClass uint16 = 0xb007
+
+// IsWarning - the operation was performed with a warning, 0x0001 or 0xbxxx
+func IsWarning(status uint16) bool {
+	return status == Warning || status&0xf000 == 0xb000
+}
@@ -3,6 +3,7 @@ package network
 import (
 	"bufio"
 	"errors"
+	"fmt"
 
 	"github.com/t2care/obd-dicom/media"
 )
@@ -19,6 +20,7 @@ type PDataTF struct {
 	pdv                   PDV
 	PresentationContextID byte
 	MsgHeader             byte
+	armWrite              func() // Called before each PDU is written, eg. to set a write deadline
 }
 
 // ReadDynamic - ReadDynamic
@@ -96,6 +98,9 @@ func (pd *PDataTF) Write(rw *bufio.ReadWriter) error {
 		pd.ItemType = 0x04
 		pd.Reserved1 = 0
 		bd := media.NewEmptyBufData()
+		if pd.armWrite != nil {
+			pd.armWrite()
+		}
 
 		bd.SetBigEndian(true)
 		bd.WriteByte(pd.ItemType)
@@ -119,7 +124,9 @@ func (pd *PDataTF) Write(rw *bufio.ReadWriter) error {
 			return errors.New("pdata::Write, " + err.Error())
 		}
 
-		rw.Flush()
+		if err := rw.Flush(); err != nil {
+			return fmt.Errorf("pdata::Write, %w", err)
+		}
 
 		if n != int(pd.BlockSize) {
 			return errors.New("pdata::Write, n!=int(pd.BlockSize)")
@@ -2,10 +2,18 @@ package network
 
 import (
 	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"log/slog"
+	"math"
 	"net"
+	"os"
+	"slices"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/t2care/obd-dicom/dictionary/sopclass"
@@ -37,27 +45,106 @@ type pduService struct {
 	OnAssociationRelease         func(request *AAssociationRQ)
 	Conn                         net.Conn
 	commandField                 uint16
+	tlsConfig                    *tls.Config
+	acceptancePolicy             *AcceptancePolicy
+	ctx                          context.Context
+	stopCancel                   func() bool
+	writeMu                      sync.Mutex
+	messageID                    uint16 // Outstanding request, 0 when none
+	requestPCID                  byte
+	cancelable                   bool
+	maxPDULength                 uint32 // Local receive limit, 0 is unlimited
+	peerMaxPDULength             uint32 // Advertised by the peer, 0 is unlimited
+	maxOpsInvoked                uint16
+	maxOpsPerformed              uint16
+	opsWindow                    uint16          // Negotiated operations invoked, 0 is unlimited
+	scpRoles                     map[string]bool // SOP classes for which the requestor was granted the SCP role
+	poolKey                      string          // Association pool the association goes back to, empty when not pooled
+	lastMessageID                uint16
+	impClassUID                  string
+	impVersion                   string
+	readTimeout                  time.Duration                      // Time given to the peer for each PDU, 0 is unlimited
+	writeTimeout                 time.Duration                      // Time given to the peer to read each PDU, 0 is unlimited
+	admit                        func(request *AAssociationRQ) bool // Local limits, rejected as transient when false
 }
 
 // newPDUService - creates a pointer to PDUService
 func newPDUService() *pduService {
-	return &pduService{
-		ms:        media.NewEmptyMemoryStream(),
-		AssocRQ:   NewAAssociationRQ(),
-		AssocAC:   NewAAssociationAC(),
-		AssocRJ:   NewAAssociationRJ(),
-		ReleaseRQ: NewAReleaseRQ(),
-		ReleaseRP: NewAReleaseRP(),
-		AbortRQ:   NewAAbortRQ(),
+	pdu := &pduService{
+		ms:               media.NewEmptyMemoryStream(),
+		AssocRQ:          NewAAssociationRQ(),
+		AssocAC:          NewAAssociationAC(),
+		AssocRJ:          NewAAssociationRJ(),
+		ReleaseRQ:        NewAReleaseRQ(),
+		ReleaseRP:        NewAReleaseRP(),
+		AbortRQ:          NewAAbortRQ(),
+		acceptancePolicy: NewAcceptancePolicy(),
+		ctx:              context.Background(),
+		maxPDULength:     DefaultMaxPDULength,
+		maxOpsInvoked:    1,
+		maxOpsPerformed:  1,
+		opsWindow:        1,
+		scpRoles:         make(map[string]bool),
+		impClassUID:      imp.GetImpClassUID(),
+		impVersion:       imp.GetImpVersion(),
 	}
+	pdu.Pdata.armWrite = pdu.armWrite
+	return pdu
 }
 
-var maxPduLength uint32 = 16384
+// armWrite - gives the peer the write timeout to read the next PDU, unless the operation is interrupted.
+// Called with writeMu held
+func (pdu *pduService) armWrite() {
+	if pdu.writeTimeout > 0 && pdu.ctx.Err() == nil {
+		pdu.Conn.SetWriteDeadline(time.Now().Add(pdu.writeTimeout))
+	}
+}
+
+// cancelTimeout - time given to the peer to answer a C-CANCEL or a release once the context is done
+const cancelTimeout = 5 * time.Second
+
+// connectTimeout - time given to the TCP connection and the TLS handshake when the association has no timeout
+const connectTimeout = 30 * time.Second
+
+// DefaultMaxPDULength - Maximum length of the P-DATA-TF received, unless configured otherwise
+const DefaultMaxPDULength uint32 = 16384
 
 func (pdu *pduService) SetConn(rw *bufio.ReadWriter) {
 	pdu.readWriter = rw
 }
 
+// SetAcceptancePolicy - Presentation contexts accepted from an A-ASSOCIATE-RQ
+func (pdu *pduService) SetAcceptancePolicy(policy *AcceptancePolicy) {
+	pdu.acceptancePolicy = policy
+}
+
+// SetMaxPDULength - Maximum length of the P-DATA-TF received, 0 is unlimited
+func (pdu *pduService) SetMaxPDULength(length uint32) {
+	pdu.maxPDULength = length
+}
+
+// SetAsyncOperationsWindow - Maximum number of operations invoked and performed asynchronously, 0 is unlimited
+func (pdu *pduService) SetAsyncOperationsWindow(invoked uint16, performed uint16) {
+	pdu.maxOpsInvoked = invoked
+	pdu.maxOpsPerformed = performed
+}
+
+// SetImplementation - Implementation Class UID and Version Name sent in the A-ASSOCIATE-RQ or AC
+func (pdu *pduService) SetImplementation(classUID string, version string) {
+	pdu.impClassUID = classUID
+	pdu.impVersion = version
+}
+
+// SetContext - Cancels the association when the context is done
+func (pdu *pduService) SetContext(ctx context.Context) {
+	pdu.ctx = ctx
+}
+
+// SetTLSConfig - Connect over TLS with this configuration
+func (pdu *pduService) SetTLSConfig(config *tls.Config) {
+	pdu.tlsConfig = config
+}
+
 func (pdu *pduService) GetTransferSyntax(pcid byte) *transfersyntax.TransferSyntax {
 	for _, pca := range pdu.AcceptedPresentationContexts {
 		if pca.GetPresentationContextID() == pcid {
@@ -72,11 +159,23 @@ func (pdu *pduService) SetTimeout(timeout int) {
 }
 
 func (pdu *pduService) Connect(IP string, Port string) error {
-	conn, err := net.Dial("tcp", IP+":"+Port)
+	var conn net.Conn
+	var err error
+	// Bounds the TLS handshake as well
+	dialer := &net.Dialer{Timeout: connectTimeout}
+	if pdu.Timeout > 0 {
+		dialer.Timeout = time.Duration(int32(pdu.Timeout)) * time.Second
+	}
+	if pdu.tlsConfig != nil {
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: pdu.tlsConfig}).DialContext(pdu.ctx, "tcp", IP+":"+Port)
+	} else {
+		conn, err = dialer.DialContext(pdu.ctx, "tcp", IP+":"+Port)
+	}
 	if err != nil {
 		return errors.New("pduservice::Connect - " + err.Error())
 	}
 	pdu.Conn = conn
+	pdu.stopCancel = context.AfterFunc(pdu.ctx, pdu.cancel)
 	if pdu.Timeout > 0 {
 		conn.SetDeadline(time.Now().Add(time.Duration(int32(pdu.Timeout)) * time.Second))
 	}
@@ -84,9 +183,12 @@ func (pdu *pduService) Connect(IP string, Port string) error {
 	rw := bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn))
 
 	pdu.readWriter = rw
-	pdu.AssocRQ.SetMaxSubLength(maxPduLength)
-	pdu.AssocRQ.SetImpClassUID(imp.GetImpClassUID())
-	pdu.AssocRQ.SetImpVersionName(imp.GetImpVersion())
+	pdu.AssocRQ.SetMaxSubLength(pdu.maxPDULength)
+	if pdu.maxOpsInvoked != 1 || pdu.maxOpsPerformed != 1 {
+		pdu.AssocRQ.GetUserInformation().GetAsyncOperationWindow().SetMaxNumberOperations(pdu.maxOpsInvoked, pdu.maxOpsPerformed)
+	}
+	pdu.AssocRQ.SetImpClassUID(pdu.impClassUID)
+	pdu.AssocRQ.SetImpVersionName(pdu.impVersion)
 
 	if err = pdu.AssocRQ.Write(pdu.readWriter); err != nil {
 		return err
@@ -143,11 +245,198 @@ func (pdu *pduService) Connect(IP string, Port string) error {
 }
 
 func (pdu *pduService) Close() {
+	if pdu.stopCancel != nil {
+		pdu.stopCancel()
+	}
+	pdu.writeMu.Lock()
+	defer pdu.writeMu.Unlock()
+	if pdu.ctx.Err() != nil {
+		pdu.Conn.SetDeadline(time.Now().Add(cancelTimeout))
+		// The request was not completed, the association cannot be released
+		if pdu.messageID != 0 {
+			slog.Info("ASSOC-ABORT-RQ:", "CallingAE", pdu.AssocRQ.GetCallingAE(), "CalledAE", pdu.AssocRQ.GetCalledAE(), "Reason", pdu.ctx.Err())
+			pdu.AbortRQ.Write(pdu.readWriter)
+			pdu.Conn.Close()
+			return
+		}
+	}
 	pdu.ReleaseRQ.Write(pdu.readWriter)
 	pdu.ReleaseRP.Read(pdu.ms)
 	pdu.Conn.Close()
 }
 
+// abort - sends an A-ABORT and closes the association, whatever it is doing
+func (pdu *pduService) abort() {
+	// A write blocked by the peer is interrupted first
+	pdu.Conn.SetDeadline(time.Now())
+	pdu.writeMu.Lock()
+	defer pdu.writeMu.Unlock()
+	pdu.Conn.SetDeadline(time.Now().Add(cancelTimeout))
+	pdu.AbortRQ.Write(pdu.readWriter)
+	pdu.Conn.Close()
+}
+
+// idle - detaches the association from the context of its operation, false when it can not be used again
+func (pdu *pduService) idle() bool {
+	if pdu.Conn == nil || (pdu.stopCancel != nil && !pdu.stopCancel()) {
+		return false
+	}
+	pdu.writeMu.Lock()
+	defer pdu.writeMu.Unlock()
+	if pdu.messageID != 0 || pdu.ctx.Err() != nil {
+		return false
+	}
+	// Released or aborted later by the pool, whatever became of the context
+	pdu.ctx = context.Background()
+	pdu.stopCancel = nil
+	return true
+}
+
+// alive - the peer did not release, abort or close the idle association
+func (pdu *pduService) alive() bool {
+	pdu.Conn.SetReadDeadline(time.Now().Add(time.Millisecond))
+	_, err := pdu.readWriter.Peek(1)
+	pdu.Conn.SetReadDeadline(time.Time{})
+	return errors.Is(err, os.ErrDeadlineExceeded)
+}
+
+// rearm - attaches an idle association to the context and timeout of a new operation
+func (pdu *pduService) rearm(ctx context.Context, timeout int) {
+	pdu.ctx = ctx
+	pdu.Timeout = timeout
+	deadline := time.Time{}
+	if timeout > 0 {
+		deadline = time.Now().Add(time.Duration(int32(timeout)) * time.Second)
+	}
+	pdu.Conn.SetDeadline(deadline)
+	if len(pdu.AcceptedPresentationContexts) > 0 {
+		pdu.SetPresentationContextID(pdu.AcceptedPresentationContexts[0].GetPresentationContextID())
+	}
+	pdu.stopCancel = context.AfterFunc(ctx, pdu.cancel)
+}
+
+// cancel - the context is done, sends a C-CANCEL-RQ for an outstanding C-FIND, C-MOVE or C-GET.
+// Any other operation is interrupted
+func (pdu *pduService) cancel() {
+	pdu.writeMu.Lock()
+	defer pdu.writeMu.Unlock()
+	if pdu.messageID == 0 || !pdu.cancelable {
+		pdu.Conn.SetDeadline(time.Now())
+		return
+	}
+	pdu.Conn.SetDeadline(time.Now().Add(cancelTimeout))
+	dco := media.NewEmptyDCMObj()
+	dco.SetTransferSyntax(transfersyntax.ImplicitVRLittleEndian)
+	dco.WriteUint16(tags.CommandField, dicomcommand.CCancelRequest)
+	dco.WriteUint16(tags.MessageIDBeingRespondedTo, pdu.messageID)
+	dco.WriteUint16(tags.CommandDataSetType, dicomstatus.CommandDataSetTypeNull)
+	// Own buffer, the association is read meanwhile
+	pdata := &PDataTF{Buffer: media.NewEmptyBufData(), PresentationContextID: pdu.requestPCID, MsgHeader: 0x01}
+	pdata.Buffer.WriteObj(dco)
+	slog.Info("PDU-Service: C-CANCEL-RQ", "MessageID", pdu.messageID, "CalledAE", pdu.GetCalledAE())
+	if err := pdata.Write(pdu.readWriter); err != nil {
+		slog.Warn("PDU-Service: C-CANCEL-RQ", "Error", err.Error())
+		pdu.Conn.SetDeadline(time.Now())
+	}
+}
+
+// watchCancel - reads the association while the request messageID is performed, the context is cancelled by its
+// C-CANCEL-RQ or when the association ends. Other PDUs are left for NextPDU, stop must be called before reading again
+func (pdu *pduService) watchCancel(messageID uint16) (ctx context.Context, stop func()) {
+	ctx, cancel := context.WithCancel(pdu.ctx)
+	var mu sync.Mutex
+	stopping := false
+	done := make(chan struct{})
+	// The peer waits for the responses, the idle timeout does not apply
+	pdu.Conn.SetReadDeadline(time.Time{})
+	go func() {
+		defer close(done)
+		for {
+			header, err := pdu.readWriter.Peek(6)
+			if err != nil {
+				if !errors.Is(err, os.ErrDeadlineExceeded) {
+					cancel()
+				}
+				return
+			}
+			if header[0] == pdutype.AssociationAbortRequest {
+				cancel()
+			}
+			length := int(binary.BigEndian.Uint32(header[2:6])) + 6
+			if header[0] != pdutype.PDUDataTransfer || length > pdu.readWriter.Reader.Size() {
+				return
+			}
+			data, err := pdu.readWriter.Peek(length)
+			if err != nil {
+				if !errors.Is(err, os.ErrDeadlineExceeded) {
+					cancel()
+				}
+				return
+			}
+			mu.Lock()
+			dco := parseCancelRQ(data)
+			if stopping || dco == nil {
+				mu.Unlock()
+				return
+			}
+			// Only consumed once peeked entirely, a C-CANCEL of another request is ignored
+			pdu.readWriter.Discard(length)
+			mu.Unlock()
+			slog.Info("PDU-Service: C-CANCEL-RQ", "MessageID", dco.GetUShort(tags.MessageIDBeingRespondedTo), "CallingAE", pdu.AssocRQ.GetCallingAE())
+			if dco.GetUShort(tags.MessageIDBeingRespondedTo) == messageID {
+				cancel()
+			}
+		}
+	}()
+	return ctx, func() {
+		mu.Lock()
+		stopping = true
+		pdu.Conn.SetReadDeadline(time.Now())
+		mu.Unlock()
+		<-done
+		pdu.Conn.SetReadDeadline(time.Time{})
+		cancel()
+	}
+}
+
+// parseCancelRQ - the C-CANCEL-RQ command of a P-DATA-TF, nil for anything else
+func parseCancelRQ(data []byte) *media.DcmObj {
+	if len(data) < 12 || data[11]&0x01 == 0 {
+		return nil
+	}
+	end := 10 + int(binary.BigEndian.Uint32(data[6:10]))
+	if end > len(data) {
+		return nil
+	}
+	dco := media.NewEmptyDCMObj()
+	dco.SetTransferSyntax(transfersyntax.ImplicitVRLittleEndian)
+	if err := media.NewBufDataFromBytes(slices.Clone(data[12:end])).ReadObj(dco); err != nil {
+		return nil
+	}
+	if dco.GetUShort(tags.CommandField) != dicomcommand.CCancelRequest {
+		return nil
+	}
+	return dco
+}
+
+// nextMessageID - Message IDs are unique on the association, 0 is never used
+func (pdu *pduService) nextMessageID() uint16 {
+	pdu.lastMessageID++
+	if pdu.lastMessageID == 0 {
+		pdu.lastMessageID++
+	}
+	return pdu.lastMessageID
+}
+
+// setOutstanding - the request waiting for its final response, messageID 0 once received
+func (pdu *pduService) setOutstanding(messageID uint16, command uint16) {
+	pdu.writeMu.Lock()
+	defer pdu.writeMu.Unlock()
+	pdu.messageID = messageID
+	pdu.requestPCID = pdu.Pdata.PresentationContextID
+	pdu.cancelable = command == dicomcommand.CFindRequest || command == dicomcommand.CMoveRequest || command == dicomcommand.CGetRequest
+}
+
 func (pdu *pduService) NextPDU() (command *media.DcmObj, err error) {
 	if pdu.Pdata.Buffer != nil {
 		pdu.Pdata.Buffer.ClearMemoryStream()
@@ -171,6 +460,9 @@ func (pdu *pduService) NextPDU() (command *media.DcmObj, err error) {
 	for {
 		pdu.ms = media.NewEmptyMemoryStream()
 
+		if pdu.readTimeout > 0 {
+			pdu.Conn.SetReadDeadline(time.Now().Add(pdu.readTimeout))
+		}
 		if err := pdu.ms.ReadFully(pdu.readWriter, 10); err != nil {
 			return nil, err
 		}
@@ -271,6 +563,48 @@ func (pdu *pduService) GetPresentationContextID() byte {
 	return pdu.Pdata.PresentationContextID
 }
 
+func (pdu *pduService) SetPresentationContextID(pcid byte) {
+	pdu.Pdata.PresentationContextID = pcid
+}
+
+// getPresentationContextID - returns the accepted presentation context ID for a SOP class, 0 if none
+func (pdu *pduService) getPresentationContextID(sopClassUID string) byte {
+	for _, pca := range pdu.AcceptedPresentationContexts {
+		if pca.GetAbstractSyntax().GetUID() == sopClassUID {
+			return pca.GetPresentationContextID()
+		}
+	}
+	return 0
+}
+
+// storePresentationContextID - accepted presentation context for an instance, the one of its transfer syntax first.
+// 0 when none was accepted for its SOP class
+func (pdu *pduService) storePresentationContextID(sopClassUID string, ts *transfersyntax.TransferSyntax) byte {
+	var pcid byte
+	for _, pca := range pdu.AcceptedPresentationContexts {
+		if pca.GetAbstractSyntax().GetUID() != sopClassUID {
+			continue
+		}
+		if ts != nil && pca.GetTrnSyntax().GetUID() == ts.UID {
+			return pca.GetPresentationContextID()
+		}
+		if pcid == 0 {
+			pcid = pca.GetPresentationContextID()
+		}
+	}
+	return pcid
+}
+
+// getAbstractSyntax - returns the abstract syntax negotiated for a presentation context ID
+func (pdu *pduService) getAbstractSyntax(pcid byte) string {
+	for _, pca := range pdu.AcceptedPresentationContexts {
+		if pca.GetPresentationContextID() == pcid {
+			return pca.GetAbstractSyntax().GetUID()
+		}
+	}
+	return ""
+}
+
 func (pdu *pduService) SetOnAssociationRequest(f func(request *AAssociationRQ) bool) {
 	pdu.OnAssociationRequest = f
 }
@@ -290,23 +624,25 @@ func (pdu *pduService) Write(DCO *media.DcmObj, ItemType byte) error {
 		return errors.New("pduservice::Write - PresentationContextID==0")
 	}
 
-	if !pdu.parseDCMIntoRaw(DCO) {
+	if !pdu.parseDCMIntoRaw(DCO, ItemType) {
 		return errors.New("pduservice::Write - ParseDCMIntoRaw failed")
 	}
 
 	pdu.Pdata.MsgHeader = ItemType
-	if pdu.AssocAC.GetUserInformation().GetMaxSubLength().GetMaximumLength() > maxPduLength {
-		pdu.AssocAC.SetMaxSubLength(maxPduLength)
+	// Fragments fit the length advertised by the peer, the PDV header takes 6 bytes
+	pdu.Pdata.BlockSize = math.MaxUint32 - 6
+	if pdu.peerMaxPDULength > 6 {
+		pdu.Pdata.BlockSize = pdu.peerMaxPDULength - 6
 	}
 
-	// Fixed MaxLength - 6 20200811
-	pdu.Pdata.BlockSize = pdu.AssocAC.GetMaxSubLength() - 6
-
 	if ItemType > 0x00 {
-		sopClass := sopclass.GetSOPClassFromUID(DCO.GetString(tags.AffectedSOPClassUID))
-		slog.Info("PDU-Service: SOP Class", "UID", sopClass.UID, "Description", sopClass.Description, "CalledAE", pdu.GetCalledAE())
+		if sopClass := sopclass.GetSOPClassFromUID(pdu.getAbstractSyntax(pdu.Pdata.PresentationContextID)); sopClass != nil {
+			slog.Info("PDU-Service: SOP Class", "UID", sopClass.UID, "Description", sopClass.Description, "CalledAE", pdu.GetCalledAE())
+		}
 	}
 
+	pdu.writeMu.Lock()
+	defer pdu.writeMu.Unlock()
 	return pdu.Pdata.Write(pdu.readWriter)
 }
 
@@ -315,6 +651,11 @@ func (pdu *pduService) interogateAAssociateAC() bool {
 	TS := ""
 
 	for _, presContextAccept := range pdu.AssocAC.GetPresContextAccepts() {
+		for _, presContext := range pdu.AssocRQ.GetPresContexts() {
+			if presContext.GetPresentationContextID() == presContextAccept.GetPresentationContextID() {
+				presContextAccept.SetAbstractSyntax(presContext.GetAbstractSyntax().GetUID())
+			}
+		}
 		if presContextAccept.GetResult() == 0 {
 			pdu.AcceptedPresentationContexts = append(pdu.AcceptedPresentationContexts, presContextAccept)
 			if len(TS) == 0 {
@@ -325,12 +666,26 @@ func (pdu *pduService) interogateAAssociateAC() bool {
 	}
 	if (len(TS) > 0) && (len(pdu.AcceptedPresentationContexts) > 0) {
 		pdu.Pdata.PresentationContextID = PresentationContextID
+		pdu.peerMaxPDULength = pdu.AssocAC.GetMaxSubLength()
+		if window := pdu.AssocAC.GetUserInformation().GetAsyncOperationWindow(); window.IsSet() {
+			// The A-ASSOCIATE-AC keeps the view of the requestor, PS3.7 D.3.3.3
+			pdu.opsWindow = operationsWindow(pdu.maxOpsInvoked, window.GetMaxNumberOperationsInvoked())
+		}
+		// A role not answered is denied
+		for _, role := range pdu.AssocAC.GetUserInformation().GetRoleSelects() {
+			pdu.scpRoles[strings.TrimRight(role.GetUID(), "\x00")] = role.GetSCPRole() == 1
+		}
 		return true
 	}
 	return false
 }
 
 func (pdu *pduService) interogateAAssociateRQ(rw *bufio.ReadWriter) error {
+	if pdu.admit != nil && !pdu.admit(pdu.AssocRQ) {
+		slog.Warn("ASSOC-RQ: local limit exceeded", "CallingAE", pdu.AssocRQ.GetCallingAE(), "CalledAE", pdu.AssocRQ.GetCalledAE())
+		pdu.AssocRJ.Set(2, 2)
+		return pdu.AssocRJ.Write(rw)
+	}
 	if pdu.OnAssociationRequest == nil || !pdu.OnAssociationRequest(pdu.AssocRQ) {
 		pdu.AssocRJ.Set(1, 7)
 		return pdu.AssocRJ.Write(rw)
@@ -345,13 +700,22 @@ func (pdu *pduService) interogateAAssociateRQ(rw *bufio.ReadWriter) error {
 	slog.Info("ASSOC-RQ:", "ImpClass", pdu.AssocRQ.GetUserInformation().GetImpClass().GetUID())
 	slog.Info("ASSOC-RQ:", "ImpVersion", pdu.AssocRQ.GetUserInformation().GetImpVersion().GetUID())
 	slog.Info("ASSOC-RQ:", "MaxPDULength", pdu.AssocRQ.GetUserInformation().GetMaxSubLength().GetMaximumLength())
+	if identity := pdu.AssocRQ.GetUserIdentity(); identity != nil {
+		slog.Info("ASSOC-RQ:", "UserIdentityType", identity.GetUserIdentityType(), "Username", identity.GetUsername())
+	}
 	slog.Info("ASSOC-RQ:", "MaxOpsInvoked", pdu.AssocRQ.GetUserInformation().GetAsyncOperationWindow().GetMaxNumberOperationsInvoked(), "MaxOpsPerformed", pdu.AssocRQ.GetUserInformation().GetAsyncOperationWindow().GetMaxNumberOperationsPerformed())
 
+	pdu.peerMaxPDULength = pdu.AssocRQ.GetMaxSubLength()
 	for presIndex, PresContext := range pdu.AssocRQ.GetPresContexts() {
 		slog.Info("ASSOC-RQ: PresentationContext", "Index", presIndex)
 
-		sopClass := sopclass.GetSOPClassFromUID(PresContext.GetAbstractSyntax().GetUID())
-		slog.Info("ASSOC-RQ: \tAbstractContext", "UID", sopClass.UID, "Description", sopClass.Description)
+		abstractSyntax := strings.TrimRight(PresContext.GetAbstractSyntax().GetUID(), "\x00")
+		if sopClass := sopclass.GetSOPClassFromUID(abstractSyntax); sopClass != nil {
+			slog.Info("ASSOC-RQ: \tAbstractContext", "UID", sopClass.UID, "Description", sopClass.Description)
+		} else {
+			slog.Info("ASSOC-RQ: \tAbstractContext", "UID", abstractSyntax)
+		}
+		proposed := make([]string, 0, len(PresContext.GetTransferSyntaxes()))
 		for _, TransferSyn := range PresContext.GetTransferSyntaxes() {
 			tsName := ""
 			transferSyntax := transfersyntax.GetTransferSyntaxFromUID(TransferSyn.GetUID())
@@ -359,24 +723,23 @@ func (pdu *pduService) interogateAAssociateRQ(rw *bufio.ReadWriter) error {
 				tsName = transferSyntax.Description
 			}
 			slog.Info("ASSOC-RQ: \tTransferSynxtax:", "UID", TransferSyn.GetUID(), "Description", tsName)
+			proposed = append(proposed, strings.TrimRight(TransferSyn.GetUID(), "\x00"))
 		}
 
 		PresContextAccept := NewPresentationContextAccept()
-		PresContextAccept.SetResult(4)
-		PresContextAccept.SetAbstractSyntax(PresContext.GetAbstractSyntax().GetUID())
-		TS := ""
-		for _, TrnSyntax := range PresContext.GetTransferSyntaxes() {
-			TS = TrnSyntax.GetUID()
-			break
-		}
-		if TS == "" {
+		PresContextAccept.SetAbstractSyntax(abstractSyntax)
+		result, TS := pdu.acceptancePolicy.Accept(abstractSyntax, proposed)
+		PresContextAccept.SetResult(result)
+		if result != PresentationContextAccepted {
+			slog.Info("ASSOC-RQ: \tRejected", "Result", result)
+			// Not significant when rejected
 			TS = transfersyntax.ImplicitVRLittleEndian.UID
 		}
-
-		PresContextAccept.SetResult(0)
 		PresContextAccept.SetTransferSyntax(TS)
 		PresContextAccept.SetPresentationContextID(PresContext.GetPresentationContextID())
-		pdu.AcceptedPresentationContexts = append(pdu.AcceptedPresentationContexts, PresContextAccept)
+		if result == PresentationContextAccepted {
+			pdu.AcceptedPresentationContexts = append(pdu.AcceptedPresentationContexts, PresContextAccept)
+		}
 		pdu.AssocAC.AddPresContextAccept(PresContextAccept)
 	}
 
@@ -384,10 +747,33 @@ func (pdu *pduService) interogateAAssociateRQ(rw *bufio.ReadWriter) error {
 		MaxSubLength := NewMaximumSubLength()
 		UserInfo := NewUserInformation()
 
-		MaxSubLength.SetMaximumLength(maxPduLength)
-		UserInfo.SetImpClassUID(imp.GetImpClassUID())
-		UserInfo.SetImpVersionName(imp.GetImpVersion())
+		MaxSubLength.SetMaximumLength(pdu.maxPDULength)
+		UserInfo.SetImpClassUID(pdu.impClassUID)
+		UserInfo.SetImpVersionName(pdu.impVersion)
 		UserInfo.SetMaxSubLength(MaxSubLength)
+		// The acceptor answers with no more than proposed, in the view of the requestor, PS3.7 D.3.3.3.
+		// The acceptor invokes the operations performed by the requestor
+		if window := pdu.AssocRQ.GetUserInformation().GetAsyncOperationWindow(); window.IsSet() {
+			performed := operationsWindow(pdu.maxOpsPerformed, window.GetMaxNumberOperationsPerformed())
+			UserInfo.GetAsyncOperationWindow().SetMaxNumberOperations(operationsWindow(pdu.maxOpsInvoked, window.GetMaxNumberOperationsInvoked()), performed)
+			pdu.opsWindow = performed
+		}
+		if identity := pdu.AssocRQ.GetUserIdentity(); identity != nil && identity.IsPositiveResponseRequested() {
+			UserInfo.SetUserIdentityAC(NewUserIdentityAC(pdu.AssocRQ.identityResponse))
+		}
+		for _, ext := range pdu.AssocRQ.GetUserInformation().GetExtendedNegotiations() {
+			slog.Info("ASSOC-RQ: ExtendedNegotiation", "UID", ext.GetUID(), "Info", ext.GetInfo())
+			if info := pdu.acceptancePolicy.ExtendedNegotiation(ext.GetUID(), ext.GetInfo()); info != nil {
+				UserInfo.AddExtendedNegotiation(NewSOPClassExtendedNegotiation(ext.GetUID(), info))
+			}
+		}
+		for _, role := range pdu.AssocRQ.GetUserInformation().GetRoleSelects() {
+			uid := strings.TrimRight(role.GetUID(), "\x00")
+			scuRole, scpRole := pdu.acceptancePolicy.Role(uid, role.GetSCURole(), role.GetSCPRole())
+			slog.Info("ASSOC-RQ: RoleSelection", "UID", uid, "SCURole", scuRole, "SCPRole", scpRole)
+			UserInfo.AddRoleSelect(NewRoleSelectUID(uid, scuRole, scpRole))
+			pdu.scpRoles[uid] = scpRole == 1
+		}
 		pdu.AssocAC.SetUserInformation(UserInfo)
 		return pdu.AssocAC.Write(rw)
 	}
@@ -396,7 +782,16 @@ func (pdu *pduService) interogateAAssociateRQ(rw *bufio.ReadWriter) error {
 	return pdu.AssocRJ.Write(rw)
 }
 
-func (pdu *pduService) parseDCMIntoRaw(DCO *media.DcmObj) bool {
+func (pdu *pduService) parseDCMIntoRaw(DCO *media.DcmObj, ItemType byte) bool {
+	// Command sets are Implicit VR Little Endian, objects built in memory are encoded with the negotiated transfer syntax
+	if ItemType&0x01 != 0 {
+		DCO.SetTransferSyntax(transfersyntax.ImplicitVRLittleEndian)
+	} else if DCO.GetTransferSyntax() == nil {
+		if ts := pdu.GetTransferSyntax(pdu.Pdata.PresentationContextID); ts != nil {
+			DCO.SetTransferSyntax(ts)
+		}
+	}
+	pdu.Pdata.Buffer.SetBigEndian(DCO.GetTransferSyntax() == transfersyntax.ExplicitVRBigEndian)
 	pdu.Pdata.Buffer.WriteObj(DCO)
 	return true
 }
@@ -407,7 +802,12 @@ func (pdu *pduService) parseRawVRIntoDCM(DCO *media.DcmObj) bool {
 		slog.Info("pduservice::ParseRawVRIntoDCM - Transfer syntax length is 0")
 		return false
 	}
+	// The last fragment tells a command set, always Implicit VR Little Endian, from a dataset
+	if pdu.Pdata.pdv.MsgHeader&0x01 != 0 {
+		TrnSyntax = transfersyntax.ImplicitVRLittleEndian
+	}
 	DCO.SetTransferSyntax(TrnSyntax)
+	pdu.Pdata.Buffer.SetBigEndian(TrnSyntax == transfersyntax.ExplicitVRBigEndian)
 	pdu.Pdata.Buffer.SetPosition(0)
 	return pdu.Pdata.Buffer.ReadObj(DCO) == nil
 }
@@ -423,14 +823,30 @@ func (pdu *pduService) WriteResp(rqCommand uint16, DCO, ddo *media.DcmObj, statu
 		defer pdu.Write(ddo, 0x00)
 	}
 
+	// DIMSE-N requests carry the Requested SOP Class/Instance, answered as Affected
+	sopClassUID := DCO.GetString(tags.AffectedSOPClassUID)
+	if sopClassUID == "" {
+		sopClassUID = DCO.GetString(tags.RequestedSOPClassUID)
+	}
+	sopInstanceUID := DCO.GetString(tags.AffectedSOPInstanceUID)
+	if sopInstanceUID == "" {
+		sopInstanceUID = DCO.GetString(tags.RequestedSOPInstanceUID)
+	}
+
 	DCOR := media.NewEmptyDCMObj()
-	DCOR.SetTransferSyntax(DCO.GetTransferSyntax())
-	DCOR.WriteString(tags.AffectedSOPClassUID, DCO.GetString(tags.AffectedSOPClassUID))
+	DCOR.SetTransferSyntax(transfersyntax.ImplicitVRLittleEndian)
+	DCOR.WriteString(tags.AffectedSOPClassUID, sopClassUID)
 	DCOR.WriteUint16(tags.CommandField, rqCommand+dicomcommand.Offset)
 	DCOR.WriteUint16(tags.MessageIDBeingRespondedTo, DCO.GetUShort(tags.MessageID))
 	DCOR.WriteUint16(tags.CommandDataSetType, leDSType)
 	DCOR.WriteUint16(tags.Status, status[0])
-	DCOR.WriteString(tags.AffectedSOPInstanceUID, DCO.GetString(tags.AffectedSOPInstanceUID))
+	DCOR.WriteString(tags.AffectedSOPInstanceUID, sopInstanceUID)
+	if DCO.GetTag(tags.EventTypeID) != nil {
+		DCOR.WriteUint16(tags.EventTypeID, DCO.GetUShort(tags.EventTypeID))
+	}
+	if DCO.GetTag(tags.ActionTypeID) != nil {
+		DCOR.WriteUint16(tags.ActionTypeID, DCO.GetUShort(tags.ActionTypeID))
+	}
 	if len(status) == 4 {
 		DCOR.WriteUint16(tags.NumberOfRemainingSuboperations, status[1])
 		DCOR.WriteUint16(tags.NumberOfCompletedSuboperations, status[2])
@@ -448,16 +864,21 @@ func (pdu *pduService) WriteRQ(rqCommand uint16, ddo *media.DcmObj, moveDst ...s
 		defer pdu.Write(ddo, 0x00)
 	}
 	sopClassUID := ddo.GetString(tags.SOPClassUID)
+	if sopClassUID == "" {
+		sopClassUID = pdu.getAbstractSyntax(pdu.GetPresentationContextID())
+	}
 	if sopClassUID == "" {
 		for _, presContext := range pdu.GetAAssociationRQ().GetPresContexts() {
 			sopClassUID = presContext.GetAbstractSyntax().GetUID()
 		}
 	}
 	dco := media.NewEmptyDCMObj()
-	dco.SetTransferSyntax(ddo.GetTransferSyntax())
+	dco.SetTransferSyntax(transfersyntax.ImplicitVRLittleEndian)
+	messageID := pdu.nextMessageID()
+	pdu.setOutstanding(messageID, rqCommand)
 	dco.WriteString(tags.AffectedSOPClassUID, sopClassUID)
 	dco.WriteUint16(tags.CommandField, rqCommand)
-	dco.WriteUint16(tags.MessageID, Uniq16odd())
+	dco.WriteUint16(tags.MessageID, messageID)
 	dco.WriteString(tags.MoveDestination, moveDst[0])
 	dco.WriteUint16(tags.Priority, priority.Medium)
 	dco.WriteUint16(tags.CommandDataSetType, leDSType)
@@ -465,15 +886,77 @@ func (pdu *pduService) WriteRQ(rqCommand uint16, ddo *media.DcmObj, moveDst ...s
 	return pdu.Write(dco, 0x01)
 }
 
+// WriteNRQ - writes a DIMSE-N request. typeID is the Action Type ID of an N-ACTION or the Event Type ID of an N-EVENT-REPORT, ignored otherwise
+func (pdu *pduService) WriteNRQ(rqCommand uint16, sopClassUID string, sopInstanceUID string, typeID uint16, ddo *media.DcmObj) error {
+	pdu.commandField = rqCommand
+	leDSType := dicomstatus.CommandDataSetTypeNull
+	if ddo != nil && ddo.TagCount() > 0 {
+		leDSType = dicomstatus.CommandDataSetTypeNonNull
+		defer pdu.Write(ddo, 0x00)
+	}
+	messageID := pdu.nextMessageID()
+	pdu.setOutstanding(messageID, rqCommand)
+	dco := media.NewEmptyDCMObj()
+	switch rqCommand {
+	case dicomcommand.NActionRequest:
+		dco.WriteString(tags.RequestedSOPClassUID, sopClassUID)
+		dco.WriteUint16(tags.CommandField, rqCommand)
+		dco.WriteUint16(tags.MessageID, messageID)
+		dco.WriteUint16(tags.CommandDataSetType, leDSType)
+		dco.WriteString(tags.RequestedSOPInstanceUID, sopInstanceUID)
+		dco.WriteUint16(tags.ActionTypeID, typeID)
+	case dicomcommand.NEventReportRequest:
+		dco.WriteString(tags.AffectedSOPClassUID, sopClassUID)
+		dco.WriteUint16(tags.CommandField, rqCommand)
+		dco.WriteUint16(tags.MessageID, messageID)
+		dco.WriteUint16(tags.CommandDataSetType, leDSType)
+		dco.WriteString(tags.AffectedSOPInstanceUID, sopInstanceUID)
+		dco.WriteUint16(tags.EventTypeID, typeID)
+	case dicomcommand.NCreateRequest:
+		dco.WriteString(tags.AffectedSOPClassUID, sopClassUID)
+		dco.WriteUint16(tags.CommandField, rqCommand)
+		dco.WriteUint16(tags.MessageID, messageID)
+		dco.WriteUint16(tags.CommandDataSetType, leDSType)
+		dco.WriteString(tags.AffectedSOPInstanceUID, sopInstanceUID)
+	case dicomcommand.NSetRequest:
+		dco.WriteString(tags.RequestedSOPClassUID, sopClassUID)
+		dco.WriteUint16(tags.CommandField, rqCommand)
+		dco.WriteUint16(tags.MessageID, messageID)
+		dco.WriteUint16(tags.CommandDataSetType, leDSType)
+		dco.WriteString(tags.RequestedSOPInstanceUID, sopInstanceUID)
+	default:
+		return fmt.Errorf("pduservice::WriteNRQ - command not supported: %v", rqCommand)
+	}
+	return pdu.Write(dco, 0x01)
+}
+
 func (pdu *pduService) ReadResp(pending ...*int) (ddo *media.DcmObj, status uint16, err error) {
+	_, ddo, status, err = pdu.readResp(pending...)
+	return
+}
+
+// proposesRole - a role selection is already proposed for the SOP class
+func (pdu *pduService) proposesRole(sopClassUID string) bool {
+	return slices.ContainsFunc(pdu.AssocRQ.GetUserInformation().GetRoleSelects(), func(role *roleSelect) bool {
+		return role.GetUID() == sopClassUID
+	})
+}
+
+// requestorSCPRole - the requestor may perform operations of the SOP class, eg. receive the C-STORE of a C-GET
+func (pdu *pduService) requestorSCPRole(sopClassUID string) bool {
+	return pdu.scpRoles[sopClassUID]
+}
+
+// readResp - ReadResp with the response command, matched to its request by MessageIDBeingRespondedTo
+func (pdu *pduService) readResp(pending ...*int) (dco, ddo *media.DcmObj, status uint16, err error) {
 	status = dicomstatus.FailureUnableToProcess
-	dco, err := pdu.NextPDU()
-	if err != nil {
+	if dco, err = pdu.NextPDU(); err != nil {
 		return
 	}
 	resp := pdu.commandField + dicomcommand.Offset
 	switch resp {
-	case dicomcommand.CEchoResponse, dicomcommand.CStoreResponse, dicomcommand.CFindResponse, dicomcommand.CMoveResponse:
+	case dicomcommand.CEchoResponse, dicomcommand.CStoreResponse, dicomcommand.CFindResponse, dicomcommand.CMoveResponse,
+		dicomcommand.NActionResponse, dicomcommand.NEventReportResponse, dicomcommand.NCreateResponse, dicomcommand.NSetResponse:
 	default:
 		err = fmt.Errorf("command not found: %v", resp)
 		return
@@ -484,5 +967,9 @@ func (pdu *pduService) ReadResp(pending ...*int) (ddo *media.DcmObj, status uint
 			*pending[0] = int(dco.GetUShort(tags.NumberOfRemainingSuboperations))
 		}
 	}
-	return ddo, dco.GetUShort(tags.Status), err
+	status = dco.GetUShort(tags.Status)
+	if status != dicomstatus.Pending && status != dicomstatus.PendingWithWarnings && dco.GetUShort(tags.MessageIDBeingRespondedTo) == pdu.messageID {
+		pdu.setOutstanding(0, 0)
+	}
+	return dco, ddo, status, err
 }
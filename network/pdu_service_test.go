@@ -0,0 +1,55 @@
+package network
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/t2care/obd-dicom/dictionary/tags"
+	"github.com/t2care/obd-dicom/media"
+)
+
+func TestWriteFragmentation(t *testing.T) {
+	obj, err := media.NewDCMObjFromFile("../samples/test.dcm")
+	assert.NoError(t, err)
+	tests := []struct {
+		name     string
+		peerMax  uint32
+		wantPDUs int
+	}{
+		{name: "Should fragment to 16 KB", peerMax: 16384},
+		{name: "Should fragment to 4 KB", peerMax: 4096},
+		{name: "Should not fragment when unlimited", peerMax: 0, wantPDUs: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			pdu := newPDUService()
+			pdu.SetConn(bufio.NewReadWriter(bufio.NewReader(&out), bufio.NewWriter(&out)))
+			pca := NewPresentationContextAccept()
+			pca.SetPresentationContextID(1)
+			pca.SetAbstractSyntax(obj.GetString(tags.SOPClassUID))
+			pca.SetTransferSyntax(obj.GetTransferSyntax().UID)
+			pdu.AcceptedPresentationContexts = append(pdu.AcceptedPresentationContexts, pca)
+			pdu.SetPresentationContextID(1)
+			pdu.peerMaxPDULength = tt.peerMax
+			assert.NoError(t, pdu.Write(obj, 0x00))
+
+			pdus, size := 0, 0
+			for data := out.Bytes(); len(data) > 0; pdus++ {
+				length := binary.BigEndian.Uint32(data[2:6])
+				if tt.peerMax > 0 {
+					assert.LessOrEqual(t, length, tt.peerMax)
+				}
+				size += int(length) - 6
+				data = data[6+length:]
+			}
+			if tt.wantPDUs == 0 {
+				tt.wantPDUs = (size + int(tt.peerMax) - 7) / int(tt.peerMax-6)
+			}
+			assert.Equal(t, tt.wantPDUs, pdus)
+		})
+	}
+}
@@ -22,8 +22,7 @@ type presentationContext struct {
 // NewPresentationContext - NewPresentationContext
 func NewPresentationContext() *presentationContext {
 	return &presentationContext{
-		ItemType:              0x20,
-		PresentationContextID: Uniq8odd(),
+		ItemType: 0x20,
 	}
 }
 
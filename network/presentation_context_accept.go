@@ -24,9 +24,8 @@ type presentationContextAccept struct {
 // NewPresentationContextAccept creates a PresentationContextAccept
 func NewPresentationContextAccept() *presentationContextAccept {
 	return &presentationContextAccept{
-		ItemType:              0x21,
-		PresentationContextID: Uniq8(),
-		Result:                2,
+		ItemType: 0x21,
+		Result:   2,
 	}
 }
 
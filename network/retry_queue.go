@@ -0,0 +1,134 @@
+package network
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+const retryQueueFile = "retry_queue.json"
+
+// RetryEntry - a file whose C-STORE failed
+type RetryEntry struct {
+	File      string    `json:"file"`
+	Attempts  int       `json:"attempts"`
+	LastError string    `json:"last_error"`
+	Updated   time.Time `json:"updated"`
+}
+
+// RetryQueue - Files whose C-STORE failed, kept on disk until they are stored or given up
+type RetryQueue struct {
+	path        string
+	maxAttempts int
+	mu          sync.Mutex
+	entries     []*RetryEntry
+}
+
+// NewRetryQueue - Loads the queue kept in dir, use one directory per destination
+func NewRetryQueue(dir string) (*RetryQueue, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	q := &RetryQueue{path: filepath.Join(dir, retryQueueFile)}
+	data, err := os.ReadFile(q.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return q, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &q.entries); err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+// SetMaxAttempts - A file is given up after failing n times, 0 is unlimited
+func (q *RetryQueue) SetMaxAttempts(n int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.maxAttempts = n
+}
+
+// Files - Files to send again, oldest first
+func (q *RetryQueue) Files() []string {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	files := make([]string, 0, len(q.entries))
+	for _, entry := range q.entries {
+		files = append(files, entry.File)
+	}
+	return files
+}
+
+// Entries - Copy of the queued entries
+func (q *RetryQueue) Entries() []RetryEntry {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	entries := make([]RetryEntry, 0, len(q.entries))
+	for _, entry := range q.entries {
+		entries = append(entries, *entry)
+	}
+	return entries
+}
+
+func (q *RetryQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.entries)
+}
+
+// update - queues the failed files, removes the stored ones, then saves the queue
+func (q *RetryQueue) update(results []*CStoreResult) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, res := range results {
+		// Only files can be sent again
+		if res.File == "" {
+			continue
+		}
+		index := slices.IndexFunc(q.entries, func(entry *RetryEntry) bool { return entry.File == res.File })
+		if res.Error == nil {
+			if index >= 0 {
+				q.entries = slices.Delete(q.entries, index, index+1)
+			}
+			continue
+		}
+		// A file that can not be read will never be sent
+		if _, err := os.Stat(res.File); err != nil {
+			if index >= 0 {
+				q.entries = slices.Delete(q.entries, index, index+1)
+			}
+			continue
+		}
+		if index < 0 {
+			q.entries = append(q.entries, &RetryEntry{File: res.File})
+			index = len(q.entries) - 1
+		}
+		entry := q.entries[index]
+		entry.Attempts++
+		entry.LastError = res.Error.Error()
+		entry.Updated = time.Now()
+		if q.maxAttempts > 0 && entry.Attempts >= q.maxAttempts {
+			q.entries = slices.Delete(q.entries, index, index+1)
+		}
+	}
+	return q.save()
+}
+
+// save - written to a temporary file first, the queue is never left half written
+func (q *RetryQueue) save() error {
+	data, err := json.MarshalIndent(q.entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := q.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, q.path)
+}
@@ -22,6 +22,29 @@ func NewRoleSelect() *roleSelect {
 	}
 }
 
+// NewRoleSelectUID - creates a role selection sub-item for a SOP class
+func NewRoleSelectUID(uid string, scuRole byte, scpRole byte) *roleSelect {
+	return &roleSelect{
+		ItemType: 0x54,
+		Length:   uint16(4 + len(uid)),
+		SCURole:  scuRole,
+		SCPRole:  scpRole,
+		uid:      uid,
+	}
+}
+
+func (scpscu *roleSelect) GetUID() string {
+	return scpscu.uid
+}
+
+func (scpscu *roleSelect) GetSCURole() byte {
+	return scpscu.SCURole
+}
+
+func (scpscu *roleSelect) GetSCPRole() byte {
+	return scpscu.SCPRole
+}
+
 func (scpscu *roleSelect) Size() uint16 {
 	return scpscu.Length + 4
 }
@@ -2,11 +2,17 @@ package network
 
 import (
 	"bufio"
+	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"log/slog"
 	"net"
+	"os"
+	"sync"
+	"time"
 
+	"github.com/t2care/obd-dicom/dictionary/sopclass"
 	"github.com/t2care/obd-dicom/dictionary/tags"
 	"github.com/t2care/obd-dicom/media"
 	"github.com/t2care/obd-dicom/network/dicomcommand"
@@ -14,33 +20,86 @@ import (
 )
 
 type scp struct {
-	Port                 int
-	listener             net.Listener
+	Port         int
+	mu           sync.RWMutex
+	listener     net.Listener
+	associations map[*pduService]context.CancelFunc // Associations being handled
+	handlers     sync.WaitGroup
+	shuttingDown bool
+	aborting     bool
+	active       int            // Associations admitted
+	activePerAE  map[string]int // Associations admitted per calling AE
+	scpConfig
+}
+
+// scpConfig - settings and handlers, an association uses those set when it started
+type scpConfig struct {
+	tlsConfig            *tls.Config
+	acceptancePolicy     *AcceptancePolicy
+	maxPDULength         uint32
+	maxOpsInvoked        uint16
+	maxOpsPerformed      uint16
+	impClassUID          string
+	impVersion           string
+	maxAssociations      int
+	maxAssociationsPerAE int
+	callingAELimits      map[string]int // Replaced as a whole, it is shared by the copies of the settings
+	artimTimeout         time.Duration
+	idleTimeout          time.Duration
 	onAssociationRequest func(request *AAssociationRQ) bool
 	onAssociationRelease func(request *AAssociationRQ)
 	onCFindRequest       func(request *AAssociationRQ, data *media.DcmObj) ([]*media.DcmObj, uint16)
+	onCFindRequestStream func(request *AAssociationRQ, data *media.DcmObj, yield func(result *media.DcmObj) bool) uint16
 	onCMoveRequest       func(request *AAssociationRQ, moveLevel string, data *media.DcmObj, moveDst *Destination) ([]string, uint16)
+	onCGetRequest        func(request *AAssociationRQ, getLevel string, data *media.DcmObj) ([]string, uint16)
 	onCStoreRequest      func(request *AAssociationRQ, data *media.DcmObj) uint16
+	onStorageCommitment  func(request *AAssociationRQ, transactionUID string, refs []*SOPReference) uint16
+	onCommitmentReport   func(request *AAssociationRQ, result *StorageCommitmentResult) uint16
+	onNCreateRequest     func(request *AAssociationRQ, sopClassUID string, sopInstanceUID string, data *media.DcmObj) uint16
+	onNSetRequest        func(request *AAssociationRQ, sopClassUID string, sopInstanceUID string, data *media.DcmObj) uint16
 }
 
+// DefaultARTIMTimeout - Time given to a peer to request an association once connected, unless configured otherwise
+const DefaultARTIMTimeout = 30 * time.Second
+
 // NewSCP - Creates an interface to scu
 func NewSCP(port int) *scp {
 	media.InitDict()
 
 	return &scp{
-		Port: port,
+		Port:         port,
+		associations: make(map[*pduService]context.CancelFunc),
+		activePerAE:  make(map[string]int),
+		scpConfig: scpConfig{
+			maxPDULength:    DefaultMaxPDULength,
+			maxOpsInvoked:   1,
+			maxOpsPerformed: 1,
+			artimTimeout:    DefaultARTIMTimeout,
+		},
 	}
 }
 
+// config - copy of the settings and handlers, they may be changed while associations are handled
+func (s *scp) config() scpConfig {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.scpConfig
+}
+
 func (s *scp) Start() error {
-	var err error
-	s.listener, err = net.Listen("tcp", fmt.Sprintf(":%d", s.Port))
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", s.Port))
 	if err != nil {
 		return err
 	}
+	if tlsConfig := s.config().tlsConfig; tlsConfig != nil {
+		listener = tls.NewListener(listener, tlsConfig)
+	}
+	s.mu.Lock()
+	s.listener = listener
+	s.mu.Unlock()
 
 	for {
-		conn, err := s.listener.Accept()
+		conn, err := listener.Accept()
 		if err != nil {
 			if errors.Is(err, net.ErrClosed) {
 				return err
@@ -48,8 +107,17 @@ func (s *scp) Start() error {
 			slog.Error(err.Error())
 			continue
 		}
+		s.mu.Lock()
+		if s.shuttingDown {
+			s.mu.Unlock()
+			conn.Close()
+			return net.ErrClosed
+		}
+		s.handlers.Add(1)
+		s.mu.Unlock()
 		slog.Info("handleConnection, new connection", "ADDRESS", conn.RemoteAddr())
 		go func() {
+			defer s.handlers.Done()
 			if err := s.handleConnection(conn); err != nil {
 				slog.Error(err.Error())
 			}
@@ -57,27 +125,251 @@ func (s *scp) Start() error {
 	}
 }
 
+// SetTLSConfig - Accept associations over TLS only, set ClientAuth and ClientCAs for mutual authentication
+func (s *scp) SetTLSConfig(config *tls.Config) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tlsConfig = config
+}
+
+// SetAcceptancePolicy - SOP classes and transfer syntaxes accepted, every SOP class with a decodable transfer syntax by default
+func (s *scp) SetAcceptancePolicy(policy *AcceptancePolicy) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.acceptancePolicy = policy
+}
+
+// SetMaxPDULength - Maximum length of the P-DATA-TF received, accepted in the A-ASSOCIATE-AC. 0 is unlimited
+func (s *scp) SetMaxPDULength(length uint32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.maxPDULength = length
+}
+
+// SetAsyncOperationsWindow - Operations accepted asynchronously when the peer proposes it, 0 is unlimited. As in the
+// A-ASSOCIATE-AC, invoked by the peer and performed by the SCP, performed by the peer for the C-GET sub-operations.
+// Requests are still performed in order
+func (s *scp) SetAsyncOperationsWindow(invoked uint16, performed uint16) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.maxOpsInvoked = invoked
+	s.maxOpsPerformed = performed
+}
+
+// SetImplementation - Implementation Class UID and Version Name of the associations, those of the imp package otherwise
+func (s *scp) SetImplementation(classUID string, version string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.impClassUID = classUID
+	s.impVersion = version
+}
+
+// SetMaxAssociations - Associations handled at once, the others are rejected as "local limit exceeded". 0 is unlimited
+func (s *scp) SetMaxAssociations(max int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.maxAssociations = max
+}
+
+// SetMaxAssociationsPerAE - Associations of one calling AE handled at once, the others are rejected as "local limit exceeded". 0 is unlimited
+func (s *scp) SetMaxAssociationsPerAE(max int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.maxAssociationsPerAE = max
+}
+
+// SetCallingAEMaxAssociations - Associations of the calling AE handled at once, instead of SetMaxAssociationsPerAE. 0 is unlimited
+func (s *scp) SetCallingAEMaxAssociations(callingAE string, max int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	limits := make(map[string]int, len(s.callingAELimits)+1)
+	for ae, limit := range s.callingAELimits {
+		limits[ae] = limit
+	}
+	limits[callingAE] = max
+	s.callingAELimits = limits
+}
+
+// SetARTIMTimeout - Time given to the peer to request an association once connected,
+// and to close the connection once its association is rejected. 0 is unlimited
+func (s *scp) SetARTIMTimeout(timeout time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.artimTimeout = timeout
+}
+
+// SetIdleTimeout - An association whose peer sends no PDU, or reads none of those sent, for longer is aborted,
+// eg. a modality that stalls. 0 is unlimited
+func (s *scp) SetIdleTimeout(timeout time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.idleTimeout = timeout
+}
+
+// admit - counts the association unless a limit is reached
+func (s *scp) admit(cfg *scpConfig, callingAE string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if cfg.maxAssociations > 0 && s.active >= cfg.maxAssociations {
+		return false
+	}
+	limit := cfg.maxAssociationsPerAE
+	if aeLimit, ok := cfg.callingAELimits[callingAE]; ok {
+		limit = aeLimit
+	}
+	if limit > 0 && s.activePerAE[callingAE] >= limit {
+		return false
+	}
+	s.active++
+	s.activePerAE[callingAE]++
+	return true
+}
+
+// release - the association admitted ended
+func (s *scp) release(callingAE string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.active--
+	if s.activePerAE[callingAE]--; s.activePerAE[callingAE] <= 0 {
+		delete(s.activePerAE, callingAE)
+	}
+}
+
 func (s *scp) Stop() error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.listener == nil {
+		return errors.New("scp::Stop, not started")
+	}
 	return s.listener.Close()
 }
 
+// Shutdown - Stops accepting associations and waits for the active ones to be released by their peers.
+// Those still active once the context is done are aborted and the context error is returned, their handlers may still be running
+func (s *scp) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.shuttingDown = true
+	if s.listener != nil {
+		s.listener.Close()
+	}
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.handlers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+	}
+
+	s.mu.Lock()
+	s.aborting = true
+	associations := make(map[*pduService]context.CancelFunc, len(s.associations))
+	for pdu, cancel := range s.associations {
+		associations[pdu] = cancel
+	}
+	s.mu.Unlock()
+	for pdu, cancel := range associations {
+		slog.Info("ASSOC-ABORT-RQ: shutdown", "CallingAE", pdu.AssocRQ.GetCallingAE(), "CalledAE", pdu.AssocRQ.GetCalledAE())
+		cancel()
+		pdu.abort()
+	}
+	return ctx.Err()
+}
+
+// track - the association is aborted by Shutdown when still active at its deadline, false once aborting
+func (s *scp) track(pdu *pduService, cancel context.CancelFunc) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.aborting {
+		return false
+	}
+	s.associations[pdu] = cancel
+	return true
+}
+
+func (s *scp) untrack(pdu *pduService) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.associations, pdu)
+}
+
 func (s *scp) handleConnection(conn net.Conn) (err error) {
 	defer conn.Close()
+	cfg := s.config()
 	rw := bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn))
 
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 	pdu := newPDUService()
 	pdu.SetConn(rw)
+	pdu.Conn = conn
+	pdu.SetContext(ctx)
+	pdu.SetMaxPDULength(cfg.maxPDULength)
+	pdu.SetAsyncOperationsWindow(cfg.maxOpsInvoked, cfg.maxOpsPerformed)
+	if cfg.impClassUID != "" {
+		pdu.SetImplementation(cfg.impClassUID, cfg.impVersion)
+	}
+	pdu.AssocRQ.ctx = ctx
+	if !s.track(pdu, cancel) {
+		return errors.New("handleConnection, SCP shutting down")
+	}
+	defer s.untrack(pdu)
+	if cfg.acceptancePolicy != nil {
+		pdu.SetAcceptancePolicy(cfg.acceptancePolicy)
+	}
+	// ARTIM, the peer has to request the association in time
+	if cfg.artimTimeout > 0 {
+		conn.SetDeadline(time.Now().Add(cfg.artimTimeout))
+	}
+	pdu.readTimeout = cfg.artimTimeout
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		if err = tlsConn.Handshake(); err != nil {
+			return err
+		}
+		pdu.AssocRQ.peerCertificates = tlsConn.ConnectionState().PeerCertificates
+	}
+	var admitted bool
+	pdu.admit = func(request *AAssociationRQ) bool {
+		admitted = s.admit(&cfg, request.GetCallingAE())
+		return admitted
+	}
+	defer func() {
+		if admitted {
+			s.release(pdu.AssocRQ.GetCallingAE())
+		}
+	}()
+	established := false
+	defer func() {
+		if established && errors.Is(err, os.ErrDeadlineExceeded) && ctx.Err() == nil {
+			slog.Warn("ASSOC-ABORT-RQ: idle timeout", "CallingAE", pdu.AssocRQ.GetCallingAE(), "CalledAE", pdu.AssocRQ.GetCalledAE())
+			pdu.abort()
+		}
+	}()
 
-	if s.onAssociationRequest != nil {
-		pdu.SetOnAssociationRequest(s.onAssociationRequest)
+	if cfg.onAssociationRequest != nil {
+		pdu.SetOnAssociationRequest(cfg.onAssociationRequest)
 	}
-	if s.onAssociationRelease != nil {
-		pdu.SetOnAssociationRelease(s.onAssociationRelease)
+	if cfg.onAssociationRelease != nil {
+		pdu.SetOnAssociationRelease(cfg.onAssociationRelease)
 	}
 
 	var dco, ddo *media.DcmObj
 	for err == nil {
-		if dco, err = pdu.NextPDU(); dco == nil {
+		dco, err = pdu.NextPDU()
+		// Accepted, the peer is now given the idle timeout for each PDU, sent or received
+		if !established && len(pdu.AcceptedPresentationContexts) > 0 {
+			established = true
+			pdu.readTimeout = cfg.idleTimeout
+			pdu.writeMu.Lock()
+			pdu.writeTimeout = cfg.idleTimeout
+			pdu.writeMu.Unlock()
+			conn.SetDeadline(time.Time{})
+		}
+		if dco == nil {
 			continue
 		}
 		command := dco.GetUShort(tags.CommandField)
@@ -87,39 +379,130 @@ func (s *scp) handleConnection(conn net.Conn) (err error) {
 			if ddo, err = pdu.NextPDU(); err != nil {
 				return
 			}
-			if s.onCStoreRequest != nil {
-				status = s.onCStoreRequest(pdu.GetAAssociationRQ(), ddo)
+			if cfg.onCStoreRequest != nil {
+				status = cfg.onCStoreRequest(pdu.GetAAssociationRQ(), ddo)
 			}
 		case dicomcommand.CFindRequest:
 			if ddo, err = pdu.NextPDU(); err != nil {
 				return
 			}
-			if s.onCFindRequest != nil {
+			if cfg.onCFindRequestStream != nil {
+				ctx, stop := s.watchCancel(pdu, dco)
+				status = cfg.onCFindRequestStream(pdu.GetAAssociationRQ(), ddo, func(result *media.DcmObj) bool {
+					if err != nil || ctx.Err() != nil {
+						return false
+					}
+					err = pdu.WriteResp(command, dco, result, dicomstatus.Pending)
+					return err == nil
+				})
+				if ctx.Err() != nil {
+					status = dicomstatus.Cancel
+				}
+				stop()
+				if err != nil {
+					return
+				}
+			} else if cfg.onCFindRequest != nil {
+				ctx, stop := s.watchCancel(pdu, dco)
 				var results []*media.DcmObj
-				results, status = s.onCFindRequest(pdu.GetAAssociationRQ(), ddo)
+				results, status = cfg.onCFindRequest(pdu.GetAAssociationRQ(), ddo)
 				for _, result := range results {
+					if ctx.Err() != nil {
+						break
+					}
 					if err = pdu.WriteResp(command, dco, result, dicomstatus.Pending); err != nil {
+						stop()
 						return
 					}
 				}
+				if ctx.Err() != nil {
+					status = dicomstatus.Cancel
+				}
+				stop()
 			}
 		case dicomcommand.CMoveRequest:
 			if ddo, err = pdu.NextPDU(); err != nil {
 				return
 			}
-			if s.onCMoveRequest != nil {
+			if cfg.onCMoveRequest != nil {
+				ctx, stop := s.watchCancel(pdu, dco)
 				moveLevel := ddo.GetString(tags.QueryRetrieveLevel)
 				dst := &Destination{CalledAE: dco.GetString(tags.MoveDestination)}
 				var files []string
-				files, status = s.onCMoveRequest(pdu.GetAAssociationRQ(), moveLevel, ddo, dst)
+				files, status = cfg.onCMoveRequest(pdu.GetAAssociationRQ(), moveLevel, ddo, dst)
 				scu := NewSCU(dst)
 				scu.onCStoreResult = func(pending, completed, failed uint16) error {
 					return pdu.WriteResp(command, dco, ddo, dicomstatus.Pending, completed, failed)
 				}
-				if err = scu.StoreSCU(files, 0); err != nil {
-					status = dicomstatus.CMoveOutOfResourcesUnableToPerformSubOperations
+				if ctx.Err() == nil {
+					if err := scu.StoreSCUContext(ctx, files); err != nil {
+						status = dicomstatus.CMoveOutOfResourcesUnableToPerformSubOperations
+					}
+				}
+				if ctx.Err() != nil {
+					status = dicomstatus.Cancel
 				}
+				stop()
 			}
+		case dicomcommand.CGetRequest:
+			if ddo, err = pdu.NextPDU(); err != nil {
+				return
+			}
+			if cfg.onCGetRequest != nil {
+				getLevel := ddo.GetString(tags.QueryRetrieveLevel)
+				var files []string
+				files, status = cfg.onCGetRequest(pdu.GetAAssociationRQ(), getLevel, ddo)
+				if err = s.cgetSubOperations(pdu, dco, ddo, files, &status); err != nil {
+					return
+				}
+				continue
+			}
+		case dicomcommand.NActionRequest:
+			if ddo, err = pdu.NextPDU(); err != nil {
+				return
+			}
+			if err = s.storageCommitment(pdu, dco, ddo); err != nil {
+				return
+			}
+			continue
+		case dicomcommand.NEventReportRequest:
+			ddo = nil
+			if dco.GetUShort(tags.CommandDataSetType) != dicomstatus.CommandDataSetTypeNull {
+				if ddo, err = pdu.NextPDU(); err != nil {
+					return
+				}
+			}
+			status = dicomstatus.FailureUnableToProcess
+			if cfg.onCommitmentReport != nil && ddo != nil {
+				var result *StorageCommitmentResult
+				if result, err = parseStorageCommitmentReport(ddo); err != nil {
+					return
+				}
+				status = cfg.onCommitmentReport(pdu.GetAAssociationRQ(), result)
+			}
+		case dicomcommand.NCreateRequest:
+			if ddo, err = pdu.NextPDU(); err != nil {
+				return
+			}
+			// The SCP assigns the SOP Instance UID when the SCU did not
+			if dco.GetString(tags.AffectedSOPInstanceUID) == "" {
+				dco.WriteString(tags.AffectedSOPInstanceUID, newUID())
+			}
+			status = dicomstatus.FailureSOPClassNotSupported
+			if cfg.onNCreateRequest != nil && negotiatedMPPS(pdu, dco.GetString(tags.AffectedSOPClassUID)) {
+				status = cfg.onNCreateRequest(pdu.GetAAssociationRQ(), dco.GetString(tags.AffectedSOPClassUID), dco.GetString(tags.AffectedSOPInstanceUID), ddo)
+			}
+		case dicomcommand.NSetRequest:
+			if ddo, err = pdu.NextPDU(); err != nil {
+				return
+			}
+			status = dicomstatus.FailureSOPClassNotSupported
+			if cfg.onNSetRequest != nil && negotiatedMPPS(pdu, dco.GetString(tags.RequestedSOPClassUID)) {
+				status = cfg.onNSetRequest(pdu.GetAAssociationRQ(), dco.GetString(tags.RequestedSOPClassUID), dco.GetString(tags.RequestedSOPInstanceUID), ddo)
+			}
+		case dicomcommand.CCancelRequest:
+			// The request already completed, nothing to answer
+			continue
 		case dicomcommand.CEchoRequest:
 		default:
 			return fmt.Errorf("handleConnection, service not implemented: %v", command)
@@ -129,22 +512,150 @@ func (s *scp) handleConnection(conn net.Conn) (err error) {
 	return
 }
 
+// watchCancel - handlers see the C-CANCEL-RQ of the request with request.Context()
+func (s *scp) watchCancel(pdu *pduService, dco *media.DcmObj) (context.Context, func()) {
+	ctx, stop := pdu.watchCancel(dco.GetUShort(tags.MessageID))
+	pdu.AssocRQ.ctx = ctx
+	return ctx, func() {
+		stop()
+		pdu.AssocRQ.ctx = pdu.ctx
+	}
+}
+
+// negotiatedMPPS - the N-CREATE or N-SET is on the Modality Performed Procedure Step SOP class, the abstract syntax
+// of its presentation context
+func negotiatedMPPS(pdu *pduService, sopClassUID string) bool {
+	return sopClassUID == sopclass.ModalityPerformedProcedureStep.UID && pdu.getAbstractSyntax(pdu.GetPresentationContextID()) == sopClassUID
+}
+
+// cgetSubOperations - sends the files back to the requestor as C-STORE sub-operations, then the final C-GET response
+func (s *scp) cgetSubOperations(pdu *pduService, dco, ddo *media.DcmObj, files []string, status *uint16) error {
+	var completed, failed uint16
+	getPCID := pdu.GetPresentationContextID()
+	for index, file := range files {
+		remaining := uint16(len(files) - index - 1)
+		if err := cgetStore(pdu, file); err != nil {
+			failed++
+			slog.Warn("C-GET sub-operation", "File", file, "Error", err.Error())
+		} else {
+			completed++
+		}
+		pdu.SetPresentationContextID(getPCID)
+		if remaining > 0 {
+			if err := pdu.WriteResp(dicomcommand.CGetRequest, dco, nil, dicomstatus.Pending, remaining, completed, failed); err != nil {
+				return err
+			}
+		}
+	}
+	if failed > 0 && *status == dicomstatus.Success {
+		*status = dicomstatus.CMoveWarningOneOrMoreFailures
+	}
+	return pdu.WriteResp(dicomcommand.CGetRequest, dco, nil, *status, 0, completed, failed)
+}
+
+// cgetStore - sends one file on the presentation context accepted for it
+func cgetStore(pdu *pduService, file string) error {
+	DDO, err := media.NewDCMObjFromFile(file)
+	if err != nil {
+		return err
+	}
+	if !pdu.requestorSCPRole(DDO.GetString(tags.SOPClassUID)) {
+		return fmt.Errorf("SCP role not granted for SOP class %s", DDO.GetString(tags.SOPClassUID))
+	}
+	return cstoreObj(pdu, DDO)
+}
+
+// storageCommitment - answers the N-ACTION then reports the commitment result on the same association
+func (s *scp) storageCommitment(pdu *pduService, dco, ddo *media.DcmObj) error {
+	cfg := s.config()
+	if dco.GetString(tags.RequestedSOPClassUID) != sopclass.StorageCommitmentPushModel.UID {
+		return pdu.WriteResp(dicomcommand.NActionRequest, dco, nil, dicomstatus.FailureSOPClassNotSupported)
+	}
+	if cfg.onStorageCommitment == nil {
+		return pdu.WriteResp(dicomcommand.NActionRequest, dco, nil, dicomstatus.FailureUnableToProcess)
+	}
+	transactionUID, refs, err := parseStorageCommitmentRequest(ddo)
+	if err != nil {
+		return pdu.WriteResp(dicomcommand.NActionRequest, dco, nil, dicomstatus.FailureUnableToProcess)
+	}
+	status := cfg.onStorageCommitment(pdu.GetAAssociationRQ(), transactionUID, refs)
+	if err := pdu.WriteResp(dicomcommand.NActionRequest, dco, nil, status); err != nil {
+		return err
+	}
+	if status != dicomstatus.Success {
+		return nil
+	}
+	return sendStorageCommitmentReport(pdu, splitReferences(transactionUID, refs))
+}
+
 func (s *scp) OnAssociationRequest(f func(request *AAssociationRQ) bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	s.onAssociationRequest = f
 }
 
 func (s *scp) OnAssociationRelease(f func(request *AAssociationRQ)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	s.onAssociationRelease = f
 }
 
 func (s *scp) OnCFindRequest(f func(request *AAssociationRQ, data *media.DcmObj) ([]*media.DcmObj, uint16)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	s.onCFindRequest = f
 }
 
+// OnCFindRequestStream - called for a C-FIND instead of OnCFindRequest, each result given to yield is sent right away
+// as a Pending response. yield returns false once the request is cancelled or the association fails, the handler should stop
+func (s *scp) OnCFindRequestStream(f func(request *AAssociationRQ, data *media.DcmObj, yield func(result *media.DcmObj) bool) uint16) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onCFindRequestStream = f
+}
+
 func (s *scp) OnCMoveRequest(f func(request *AAssociationRQ, moveLevel string, data *media.DcmObj, moveDst *Destination) ([]string, uint16)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	s.onCMoveRequest = f
 }
 
+func (s *scp) OnCGetRequest(f func(request *AAssociationRQ, getLevel string, data *media.DcmObj) ([]string, uint16)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onCGetRequest = f
+}
+
 func (s *scp) OnCStoreRequest(f func(request *AAssociationRQ, data *media.DcmObj) uint16) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	s.onCStoreRequest = f
 }
+
+// OnNCreateRequest - called for an N-CREATE, eg. a Modality Performed Procedure Step IN PROGRESS
+func (s *scp) OnNCreateRequest(f func(request *AAssociationRQ, sopClassUID string, sopInstanceUID string, data *media.DcmObj) uint16) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onNCreateRequest = f
+}
+
+// OnNSetRequest - called for an N-SET, eg. a Modality Performed Procedure Step COMPLETED or DISCONTINUED
+func (s *scp) OnNSetRequest(f func(request *AAssociationRQ, sopClassUID string, sopInstanceUID string, data *media.DcmObj) uint16) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onNSetRequest = f
+}
+
+// OnStorageCommitment - called for a Storage Commitment N-ACTION. Set FailureReason on the references that can not be committed
+func (s *scp) OnStorageCommitment(f func(request *AAssociationRQ, transactionUID string, refs []*SOPReference) uint16) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onStorageCommitment = f
+}
+
+// OnStorageCommitmentReport - called for a Storage Commitment N-EVENT-REPORT received on a later association
+func (s *scp) OnStorageCommitmentReport(f func(request *AAssociationRQ, result *StorageCommitmentResult) uint16) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onCommitmentReport = f
+}
@@ -1,28 +1,50 @@
 package network
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
 	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"os"
 	"os/exec"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/t2care/obd-dicom/dictionary/sopclass"
 	"github.com/t2care/obd-dicom/dictionary/tags"
+	"github.com/t2care/obd-dicom/dictionary/transfersyntax"
 	"github.com/t2care/obd-dicom/media"
+	"github.com/t2care/obd-dicom/network/dicomcommand"
 	"github.com/t2care/obd-dicom/network/dicomstatus"
+	"github.com/t2care/obd-dicom/network/pdutype"
+	"github.com/t2care/obd-dicom/utils"
 )
 
 func Test_Association_ID(t *testing.T) {
 	_, testSCP := StartSCP(t, 1043)
-	var onAssociationRequestID int64
-	var onAssociationReleaseID int64
+	var onAssociationRequestID atomic.Int64
+	var onAssociationReleaseID atomic.Int64
 	testSCP.OnAssociationRequest(func(request *AAssociationRQ) bool {
-		onAssociationRequestID = request.GetID()
+		onAssociationRequestID.Store(request.GetID())
 		return true
 	})
 	testSCP.OnAssociationRelease(func(request *AAssociationRQ) {
-		onAssociationReleaseID = request.GetID()
+		onAssociationReleaseID.Store(request.GetID())
 	})
 	tests := []struct {
 		name    string
@@ -54,8 +76,8 @@ func Test_Association_ID(t *testing.T) {
 				t.Errorf("scu.EchoSCU() error = %v, wantErr %v", err, tt.wantErr)
 			}
 			time.Sleep(100 * time.Millisecond) // wait for association closed
-			if onAssociationRequestID != onAssociationReleaseID {
-				t.Errorf("onAssociationRequestID = %v, onAssociationReleaseID = %v", onAssociationRequestID, onAssociationReleaseID)
+			if onAssociationRequestID.Load() != onAssociationReleaseID.Load() {
+				t.Errorf("onAssociationRequestID = %v, onAssociationReleaseID = %v", onAssociationRequestID.Load(), onAssociationReleaseID.Load())
 			}
 		})
 	}
@@ -83,6 +105,1252 @@ func Test_SCP(t *testing.T) {
 	assert.NoError(t, dcmtk_movescu(port), "MoveSCU should be ok")
 }
 
+func Test_CGet(t *testing.T) {
+	port := 1045
+	_, testSCP := StartSCP(t, port)
+	testSCP.OnAssociationRequest(func(request *AAssociationRQ) bool { return true })
+	testSCP.OnCGetRequest(func(request *AAssociationRQ, getLevel string, query *media.DcmObj) ([]string, uint16) {
+		return []string{"../samples/test.dcm", "../samples/test2.dcm"}, dicomstatus.Success
+	})
+	var received []string
+	d := NewSCU(&Destination{CalledAE: "TEST_SCP", CallingAE: "TEST_SCU", HostName: "localhost", Port: port})
+	d.SetOnCStoreRequest(func(data *media.DcmObj) uint16 {
+		received = append(received, data.GetString(tags.SOPInstanceUID))
+		return dicomstatus.Success
+	})
+	status, err := d.GetSCU(utils.DefaultCMoveRequest("1.2.3"), 0)
+	assert.NoError(t, err, "GetSCU should be ok")
+	assert.Equal(t, dicomstatus.Success, status)
+	assert.Len(t, received, 2)
+}
+
+func Test_StorageCommitment(t *testing.T) {
+	port := 1046
+	_, testSCP := StartSCP(t, port)
+	testSCP.OnAssociationRequest(func(request *AAssociationRQ) bool { return true })
+	testSCP.OnStorageCommitment(func(request *AAssociationRQ, transactionUID string, refs []*SOPReference) uint16 {
+		for _, ref := range refs {
+			if ref.SOPInstanceUID == "1.2.3.2" {
+				ref.FailureReason = 0x0112 // No such object instance
+			}
+		}
+		return dicomstatus.Success
+	})
+	var report *StorageCommitmentResult
+	testSCP.OnStorageCommitmentReport(func(request *AAssociationRQ, result *StorageCommitmentResult) uint16 {
+		report = result
+		return dicomstatus.Success
+	})
+
+	d := NewSCU(&Destination{CalledAE: "TEST_SCP", CallingAE: "TEST_SCU", HostName: "localhost", Port: port})
+	result, err := d.RequestStorageCommitment([]*SOPReference{
+		{SOPClassUID: sopclass.CTImageStorage.UID, SOPInstanceUID: "1.2.3.1"},
+		{SOPClassUID: sopclass.CTImageStorage.UID, SOPInstanceUID: "1.2.3.2"},
+	}, 5)
+	assert.NoError(t, err, "RequestStorageCommitment should be ok")
+	assert.False(t, result.Pending)
+	assert.Len(t, result.Committed, 1)
+	assert.Len(t, result.Failed, 1)
+	assert.Equal(t, "1.2.3.2", result.Failed[0].SOPInstanceUID)
+	assert.Equal(t, uint16(0x0112), result.Failed[0].FailureReason)
+
+	assert.NoError(t, d.SendStorageCommitmentReport(result, 5), "SendStorageCommitmentReport should be ok")
+	assert.Equal(t, result.TransactionUID, report.TransactionUID)
+	assert.Len(t, report.Committed, 1)
+	assert.Len(t, report.Failed, 1)
+}
+
+func Test_StorageCommitmentReportTimeout(t *testing.T) {
+	port := 1070
+	// Answers the N-ACTION, then neither reports nor releases
+	listener, err := net.Listen("tcp", fmt.Sprintf("localhost:%d", port))
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		pdu := newPDUService()
+		pdu.SetConn(bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn)))
+		pdu.Conn = conn
+		pdu.SetContext(context.Background())
+		pdu.SetOnAssociationRequest(func(request *AAssociationRQ) bool { return true })
+		for {
+			dco, err := pdu.NextPDU()
+			if err != nil {
+				return
+			}
+			if dco == nil || dco.GetUShort(tags.CommandField) != dicomcommand.NActionRequest {
+				continue
+			}
+			if _, err := pdu.NextPDU(); err != nil {
+				return
+			}
+			pdu.WriteResp(dicomcommand.NActionRequest, dco, nil, dicomstatus.Success)
+		}
+	}()
+
+	d := NewSCU(&Destination{CalledAE: "TEST_SCP", CallingAE: "TEST_SCU", HostName: "localhost", Port: port})
+	d.SetStorageCommitmentReportTimeout(200 * time.Millisecond)
+	start := time.Now()
+	result, err := d.RequestStorageCommitment([]*SOPReference{
+		{SOPClassUID: sopclass.CTImageStorage.UID, SOPInstanceUID: "1.2.3.1"},
+	}, 0)
+	if assert.NoError(t, err, "RequestStorageCommitment should be ok") {
+		assert.True(t, result.Pending)
+	}
+	assert.Less(t, time.Since(start), 2*time.Second)
+}
+
+func Test_MPPS(t *testing.T) {
+	port := 1047
+	_, testSCP := StartSCP(t, port)
+	testSCP.OnAssociationRequest(func(request *AAssociationRQ) bool { return true })
+	steps := map[string]string{}
+	testSCP.OnNCreateRequest(func(request *AAssociationRQ, sopClassUID string, sopInstanceUID string, data *media.DcmObj) uint16 {
+		if sopClassUID != sopclass.ModalityPerformedProcedureStep.UID {
+			return dicomstatus.FailureSOPClassNotSupported
+		}
+		steps[sopInstanceUID] = data.GetString(tags.PerformedProcedureStepStatus)
+		return dicomstatus.Success
+	})
+	testSCP.OnNSetRequest(func(request *AAssociationRQ, sopClassUID string, sopInstanceUID string, data *media.DcmObj) uint16 {
+		if _, ok := steps[sopInstanceUID]; !ok {
+			return 0x0112 // No such object instance
+		}
+		steps[sopInstanceUID] = data.GetString(tags.PerformedProcedureStepStatus)
+		return dicomstatus.Success
+	})
+
+	d := NewSCU(&Destination{CalledAE: "TEST_SCP", CallingAE: "TEST_SCU", HostName: "localhost", Port: port})
+	mpps := media.NewEmptyDCMObj()
+	mpps.WriteString(tags.PerformedProcedureStepID, "PPS1")
+	uid, status, err := d.CreateMPPS("", mpps, 5)
+	assert.NoError(t, err, "CreateMPPS should be ok")
+	assert.Equal(t, dicomstatus.Success, status)
+	assert.Equal(t, MPPSInProgress, steps[uid])
+	assert.Nil(t, mpps.GetTag(tags.PerformedProcedureStepStatus), "the dataset of the caller is left as is")
+
+	mpps = media.NewEmptyDCMObj()
+	mpps.WriteString(tags.PerformedProcedureStepStatus, MPPSCompleted)
+	status, err = d.UpdateMPPS(uid, mpps, 5)
+	assert.NoError(t, err, "UpdateMPPS should be ok")
+	assert.Equal(t, dicomstatus.Success, status)
+	assert.Equal(t, MPPSCompleted, steps[uid])
+
+	status, err = d.UpdateMPPS("1.2.3.4", mpps, 5)
+	assert.NoError(t, err)
+	assert.Equal(t, uint16(0x0112), status)
+
+	// Other SOP classes, or MPPS on a presentation context negotiated for another one, are not supported
+	for sopClass, requested := range map[*sopclass.SOPClass]string{
+		sopclass.ModalityPerformedProcedureStep: sopclass.Verification.UID,
+		sopclass.Verification:                   sopclass.ModalityPerformedProcedureStep.UID,
+	} {
+		pdu, err := d.openAssociation(newPDUService(), []*sopclass.SOPClass{sopClass}, []string{}, 5)
+		if !assert.NoError(t, err) {
+			continue
+		}
+		for _, command := range []uint16{dicomcommand.NCreateRequest, dicomcommand.NSetRequest} {
+			assert.NoError(t, pdu.WriteNRQ(command, requested, uid, 0, mpps))
+			_, status, err = pdu.ReadResp()
+			assert.NoError(t, err)
+			assert.Equal(t, dicomstatus.FailureSOPClassNotSupported, status)
+		}
+		d.releaseAssociation(pdu)
+	}
+	assert.Equal(t, map[string]string{uid: MPPSCompleted}, steps)
+}
+
+func Test_TLS(t *testing.T) {
+	port := 1048
+	serverCert, serverPool := newTestCertificate(t, "TEST_SCP")
+	clientCert, clientPool := newTestCertificate(t, "TEST_SCU")
+
+	testSCP := NewSCP(port)
+	testSCP.SetTLSConfig(&tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    clientPool,
+	})
+	go testSCP.Start()
+	defer testSCP.Stop()
+	time.Sleep(100 * time.Millisecond) // wait for server started
+	var peer string
+	testSCP.OnAssociationRequest(func(request *AAssociationRQ) bool {
+		if certs := request.GetPeerCertificates(); len(certs) > 0 {
+			peer = certs[0].Subject.CommonName
+		}
+		return true
+	})
+
+	dst := &Destination{CalledAE: "TEST_SCP", CallingAE: "TEST_SCU", HostName: "localhost", Port: port, IsTLS: true}
+	dst.TLSConfig = &tls.Config{Certificates: []tls.Certificate{clientCert}, RootCAs: serverPool}
+	assert.NoError(t, NewSCU(dst).EchoSCU(5), "EchoSCU over TLS should be ok")
+	assert.Equal(t, "TEST_SCU", peer)
+
+	dst.TLSConfig = &tls.Config{RootCAs: serverPool}
+	assert.Error(t, NewSCU(dst).EchoSCU(5), "EchoSCU without client certificate should fail")
+
+	dst.IsTLS, dst.TLSConfig = false, nil
+	assert.Error(t, NewSCU(dst).EchoSCU(5), "EchoSCU without TLS should fail")
+}
+
+func Test_TLSHandshakeTimeout(t *testing.T) {
+	port := 1071
+	// Accepts the connections but never answers the handshake
+	listener, err := net.Listen("tcp", fmt.Sprintf("localhost:%d", port))
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	dst := &Destination{CalledAE: "TEST_SCP", CallingAE: "TEST_SCU", HostName: "localhost", Port: port, IsTLS: true}
+	dst.TLSConfig = &tls.Config{}
+	start := time.Now()
+	assert.Error(t, NewSCU(dst).EchoSCU(1), "EchoSCU should fail once the handshake times out")
+	assert.Less(t, time.Since(start), 3*time.Second)
+}
+
+func Test_AcceptancePolicy(t *testing.T) {
+	port := 1049
+	_, testSCP := StartSCP(t, port)
+	testSCP.OnAssociationRequest(func(request *AAssociationRQ) bool { return true })
+	policy := NewAcceptancePolicy()
+	policy.AddSOPClass(sopclass.Verification)
+	policy.AddSOPClass(sopclass.CTImageStorage, transfersyntax.ExplicitVRLittleEndian)
+	testSCP.SetAcceptancePolicy(policy)
+
+	d := NewSCU(&Destination{CalledAE: "TEST_SCP", CallingAE: "TEST_SCU", HostName: "localhost", Port: port})
+	pdu := newPDUService()
+	sopClasses := []*sopclass.SOPClass{sopclass.Verification, sopclass.CTImageStorage, sopclass.MRImageStorage}
+	pdu, err := d.openAssociation(pdu, sopClasses, []string{transfersyntax.ImplicitVRLittleEndian.UID}, 5)
+	assert.NoError(t, err)
+	defer pdu.Close()
+	results := []byte{}
+	for _, pca := range pdu.AssocAC.GetPresContextAccepts() {
+		results = append(results, pca.GetResult())
+	}
+	assert.Equal(t, []byte{PresentationContextAccepted, PresentationContextTransferSyntaxesNotSupported, PresentationContextAbstractSyntaxNotSupported}, results)
+	assert.Len(t, pdu.AcceptedPresentationContexts, 1)
+}
+
+func Test_SCUContext(t *testing.T) {
+	port := 1050
+	_, testSCP := StartSCP(t, port)
+	testSCP.OnAssociationRequest(func(request *AAssociationRQ) bool { return true })
+	handlerCancelled := make(chan bool, 1)
+	testSCP.OnCFindRequest(func(request *AAssociationRQ, query *media.DcmObj) ([]*media.DcmObj, uint16) {
+		select {
+		case <-request.Context().Done():
+			handlerCancelled <- true
+		case <-time.After(cancelTimeout):
+			handlerCancelled <- false
+		}
+		return []*media.DcmObj{query}, dicomstatus.Success
+	})
+	d := NewSCU(&Destination{CalledAE: "TEST_SCP", CallingAE: "TEST_SCU", HostName: "localhost", Port: port})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.ErrorIs(t, d.EchoSCUContext(ctx), context.Canceled, "EchoSCU should not start")
+
+	ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	results, status, err := d.FindSCUContext(ctx, utils.DefaultCFindRequest())
+	assert.ErrorIs(t, err, context.DeadlineExceeded, "FindSCU should be cancelled")
+	assert.Less(t, time.Since(start), cancelTimeout, "FindSCU should not wait for the abort")
+	assert.Equal(t, dicomstatus.Cancel, status)
+	assert.Equal(t, 0, results)
+	assert.True(t, <-handlerCancelled, "C-FIND handler should see the C-CANCEL")
+
+	assert.NoError(t, d.EchoSCUContext(context.Background()), "EchoSCU should be ok")
+}
+
+func Test_CMoveCancel(t *testing.T) {
+	port, destinationPort := 1051, 1052
+	_, testSCP := StartSCP(t, port)
+	testSCP.OnAssociationRequest(func(request *AAssociationRQ) bool { return true })
+	testSCP.OnCMoveRequest(func(request *AAssociationRQ, moveLevel string, query *media.DcmObj, moveDst *Destination) ([]string, uint16) {
+		moveDst.CallingAE = request.GetCalledAE()
+		moveDst.HostName = "127.0.0.1"
+		moveDst.Port = destinationPort
+		return []string{"../samples/test.dcm", "../samples/test.dcm", "../samples/test.dcm", "../samples/test.dcm"}, dicomstatus.Success
+	})
+	_, destinationSCP := StartSCP(t, destinationPort)
+	destinationSCP.OnAssociationRequest(func(request *AAssociationRQ) bool { return true })
+	stored := make(chan bool, 4)
+	destinationSCP.OnCStoreRequest(func(request *AAssociationRQ, data *media.DcmObj) uint16 {
+		stored <- true
+		time.Sleep(100 * time.Millisecond)
+		return dicomstatus.Success
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-stored
+		cancel()
+	}()
+	d := NewSCU(&Destination{CalledAE: "TEST_SCP", CallingAE: "TEST_SCU", HostName: "localhost", Port: port})
+	status, err := d.MoveSCUContext(ctx, "TEST_SCU", utils.DefaultCMoveRequest("1.2.3"))
+	assert.ErrorIs(t, err, context.Canceled, "MoveSCU should be cancelled")
+	assert.Equal(t, dicomstatus.Cancel, status)
+	assert.Less(t, len(stored), 3, "C-MOVE sub-operations should stop")
+}
+
+func Test_CFindStream(t *testing.T) {
+	port := 1053
+	_, testSCP := StartSCP(t, port)
+	testSCP.OnAssociationRequest(func(request *AAssociationRQ) bool { return true })
+	yielded := make(chan int, 2)
+	testSCP.OnCFindRequestStream(func(request *AAssociationRQ, query *media.DcmObj, yield func(result *media.DcmObj) bool) uint16 {
+		count, limit := 0, 100000
+		if query.GetString(tags.PatientName) == "" {
+			limit = 1000
+		}
+		for i := 0; i < limit; i++ {
+			result := media.NewEmptyDCMObj()
+			result.WriteString(tags.StudyInstanceUID, fmt.Sprintf("1.2.3.%d", i))
+			if !yield(result) {
+				break
+			}
+			count++
+		}
+		yielded <- count
+		return dicomstatus.Success
+	})
+	d := NewSCU(&Destination{CalledAE: "TEST_SCP", CallingAE: "TEST_SCU", HostName: "localhost", Port: port})
+	results, status, err := d.FindSCU(utils.DefaultCFindRequest(), 0)
+	assert.NoError(t, err, "FindSCU should be ok")
+	assert.Equal(t, dicomstatus.Success, status)
+	assert.Equal(t, 1000, results)
+	assert.Equal(t, 1000, <-yielded)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	received := 0
+	d.SetOnCFindResult(func(result *media.DcmObj) {
+		if received++; received == 10 {
+			cancel()
+		}
+	})
+	query := utils.DefaultCFindRequest()
+	query.WriteString(tags.PatientName, "*")
+	_, status, err = d.FindSCUContext(ctx, query)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, dicomstatus.Cancel, status)
+	assert.Less(t, <-yielded, 100000, "Streaming should stop on C-CANCEL")
+}
+
+func Test_MaxPDULength(t *testing.T) {
+	port := 1054
+	testSCP := NewSCP(port)
+	testSCP.SetMaxPDULength(4096)
+	go testSCP.Start()
+	defer testSCP.Stop()
+	time.Sleep(100 * time.Millisecond) // wait for server started
+	proposed := make(chan uint32, 3)
+	testSCP.OnAssociationRequest(func(request *AAssociationRQ) bool {
+		proposed <- request.GetMaxSubLength()
+		return true
+	})
+	received := make(chan string, 1)
+	testSCP.OnCStoreRequest(func(request *AAssociationRQ, data *media.DcmObj) uint16 {
+		received <- data.GetString(tags.SOPInstanceUID)
+		return dicomstatus.Success
+	})
+
+	obj, err := media.NewDCMObjFromFile("../samples/test.dcm")
+	assert.NoError(t, err)
+	d := NewSCU(&Destination{CalledAE: "TEST_SCP", CallingAE: "TEST_SCU", HostName: "localhost", Port: port})
+	d.SetMaxPDULength(0)
+	assert.NoError(t, d.StoreSCU([]string{"../samples/test.dcm"}, 0))
+	assert.Equal(t, uint32(0), <-proposed, "Unlimited should be proposed")
+	assert.Equal(t, obj.GetString(tags.SOPInstanceUID), <-received)
+
+	// The SCP advertises its limit
+	pdu, err := d.openAssociation(newPDUService(), []*sopclass.SOPClass{sopclass.Verification}, []string{}, 5)
+	if assert.NoError(t, err) {
+		assert.Equal(t, uint32(4096), pdu.AssocAC.GetMaxSubLength())
+		pdu.Close()
+	}
+
+	// And the SCU splits the P-DATA-TF to fit it
+	proxy := newPDUProxy(t, 1072, port)
+	d = NewSCU(&Destination{CalledAE: "TEST_SCP", CallingAE: "TEST_SCU", HostName: "localhost", Port: 1072})
+	assert.NoError(t, d.StoreSCU([]string{"../samples/test.dcm"}, 0))
+	assert.Equal(t, obj.GetString(tags.SOPInstanceUID), <-received)
+	count, longest := proxy.pdata()
+	assert.Greater(t, count, 133116/4096, "the dataset should be sent in many PDUs")
+	assert.Equal(t, uint32(4096), longest, "the PDUs should fit the length advertised, fragments of 4090 bytes")
+}
+
+type pduProxy struct {
+	mu              sync.Mutex
+	count           int // P-DATA-TF sent by the requestor
+	longest         uint32
+	outstanding     int // Requests sent and not answered yet
+	mostOutstanding int
+}
+
+// newPDUProxy - forwards the associations to the target port, recording the P-DATA-TF exchanged
+func newPDUProxy(t *testing.T, port int, target int) *pduProxy {
+	proxy := &pduProxy{}
+	listener, err := net.Listen("tcp", fmt.Sprintf("localhost:%d", port))
+	if !assert.NoError(t, err) {
+		return proxy
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			peer, err := net.Dial("tcp", fmt.Sprintf("localhost:%d", target))
+			if err != nil {
+				conn.Close()
+				return
+			}
+			go proxy.relay(peer, conn, false)
+			go proxy.relay(conn, peer, true)
+		}
+	}()
+	return proxy
+}
+
+// relay - forwards the PDUs, recorded before they are forwarded
+func (p *pduProxy) relay(src net.Conn, dst net.Conn, requestor bool) {
+	defer dst.Close()
+	header := make([]byte, 6)
+	for {
+		if _, err := io.ReadFull(src, header); err != nil {
+			return
+		}
+		body := make([]byte, binary.BigEndian.Uint32(header[2:]))
+		if _, err := io.ReadFull(src, body); err != nil {
+			return
+		}
+		if header[0] == pdutype.PDUDataTransfer {
+			p.record(requestor, body)
+		}
+		dst.Write(header)
+		dst.Write(body)
+	}
+}
+
+// record - a P-DATA-TF, its last command fragment is a request from the requestor, a response otherwise
+func (p *pduProxy) record(requestor bool, body []byte) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if requestor {
+		p.count++
+		p.longest = max(p.longest, uint32(len(body)))
+	}
+	for len(body) >= 6 {
+		length := binary.BigEndian.Uint32(body)
+		if body[5]&0x03 == 0x03 {
+			if requestor {
+				p.outstanding++
+				p.mostOutstanding = max(p.mostOutstanding, p.outstanding)
+			} else {
+				p.outstanding--
+			}
+		}
+		body = body[min(len(body), 4+int(length)):]
+	}
+}
+
+// pdata - number of P-DATA-TF sent by the requestor and the longest length
+func (p *pduProxy) pdata() (int, uint32) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.count, p.longest
+}
+
+func Test_AsyncOperationsWindow(t *testing.T) {
+	port := 1055
+	testSCP := NewSCP(port)
+	testSCP.SetAsyncOperationsWindow(3, 1)
+	go testSCP.Start()
+	defer testSCP.Stop()
+	time.Sleep(100 * time.Millisecond) // wait for server started
+	proposed := make(chan uint16, 1)
+	testSCP.OnAssociationRequest(func(request *AAssociationRQ) bool {
+		proposed <- request.GetUserInformation().GetAsyncOperationWindow().GetMaxNumberOperationsInvoked()
+		return true
+	})
+	received := make(chan string, 10)
+	testSCP.OnCStoreRequest(func(request *AAssociationRQ, data *media.DcmObj) uint16 {
+		received <- data.GetString(tags.SOPInstanceUID)
+		// The next requests are sent meanwhile
+		time.Sleep(20 * time.Millisecond)
+		return dicomstatus.Success
+	})
+
+	files := []string{"../samples/test.dcm", "../samples/test2.dcm", "../samples/test.dcm", "../samples/test2.dcm", "../samples/test.dcm", "missing.dcm"}
+	proxy := newPDUProxy(t, 1073, port)
+	d := NewSCU(&Destination{CalledAE: "TEST_SCP", CallingAE: "TEST_SCU", HostName: "localhost", Port: 1073})
+	d.SetAsyncOperationsWindow(0, 1)
+	var completed, failed uint16
+	d.onCStoreResult = func(p, c, f uint16) error {
+		completed, failed = c, f
+		return nil
+	}
+	assert.NoError(t, d.StoreSCU(files, 0))
+	assert.Equal(t, uint16(0), <-proposed, "Unlimited should be proposed")
+	assert.Equal(t, uint16(5), completed)
+	assert.Equal(t, uint16(1), failed)
+	assert.Len(t, received, 5)
+	assert.Greater(t, proxy.concurrency(), 1, "C-STORE requests should be outstanding at once")
+	assert.LessOrEqual(t, proxy.concurrency(), 3, "within the window accepted by the SCP")
+}
+
+func Test_AsyncOperationsWindowAC(t *testing.T) {
+	port := 1074
+	// Acceptor answering in the view of the requestor, PS3.7 D.3.3.3: 2 invoked by the SCU, 1 performed
+	listener, err := net.Listen("tcp", fmt.Sprintf("localhost:%d", port))
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		header := make([]byte, 6)
+		if _, err := io.ReadFull(conn, header); err != nil {
+			return
+		}
+		rq := make([]byte, binary.BigEndian.Uint32(header[2:]))
+		if _, err := io.ReadFull(conn, rq); err != nil {
+			return
+		}
+		// Presentation context ID of the first one proposed
+		var pcID byte
+		for items := rq[68:]; len(items) >= 4; items = items[4+binary.BigEndian.Uint16(items[2:]):] {
+			if items[0] == 0x20 {
+				pcID = items[4]
+				break
+			}
+		}
+		item := func(itemType byte, value []byte) []byte {
+			return append(binary.BigEndian.AppendUint16([]byte{itemType, 0}, uint16(len(value))), value...)
+		}
+		body := append([]byte{0x00, 0x01, 0x00, 0x00}, rq[4:68]...)
+		body = append(body, item(0x10, []byte("1.2.840.10008.3.1.1.1"))...)
+		body = append(body, item(0x21, append([]byte{pcID, 0, 0, 0}, item(0x40, []byte("1.2.840.10008.1.2"))...))...)
+		userInfo := item(0x51, binary.BigEndian.AppendUint32(nil, 16384))
+		userInfo = append(userInfo, item(0x52, []byte("1.2.3.4"))...)
+		userInfo = append(userInfo, item(0x53, []byte{0x00, 0x02, 0x00, 0x01})...)
+		body = append(body, item(0x50, userInfo)...)
+		ac := binary.BigEndian.AppendUint32([]byte{pdutype.AssociationAccept, 0}, uint32(len(body)))
+		conn.Write(append(ac, body...))
+		io.Copy(io.Discard, conn)
+	}()
+
+	d := NewSCU(&Destination{CalledAE: "TEST_SCP", CallingAE: "TEST_SCU", HostName: "localhost", Port: port})
+	d.SetAsyncOperationsWindow(0, 1)
+	pdu, err := d.openAssociation(newPDUService(), []*sopclass.SOPClass{sopclass.CTImageStorage}, []string{}, 5)
+	if assert.NoError(t, err) {
+		assert.Equal(t, uint16(2), pdu.opsWindow, "the SCU invokes the operations invoked in the A-ASSOCIATE-AC")
+		pdu.Conn.Close()
+	}
+
+	// And the SCP answers in the same view, no more than proposed
+	scpPort := 1075
+	_, testSCP := StartSCP(t, scpPort)
+	testSCP.OnAssociationRequest(func(request *AAssociationRQ) bool { return true })
+	testSCP.SetAsyncOperationsWindow(3, 0)
+	d = NewSCU(&Destination{CalledAE: "TEST_SCP", CallingAE: "TEST_SCU", HostName: "localhost", Port: scpPort})
+	d.SetAsyncOperationsWindow(5, 2)
+	pdu, err = d.openAssociation(newPDUService(), []*sopclass.SOPClass{sopclass.CTImageStorage}, []string{}, 5)
+	if assert.NoError(t, err) {
+		window := pdu.AssocAC.GetUserInformation().GetAsyncOperationWindow()
+		assert.Equal(t, uint16(3), window.GetMaxNumberOperationsInvoked())
+		assert.Equal(t, uint16(2), window.GetMaxNumberOperationsPerformed())
+		assert.Equal(t, uint16(3), pdu.opsWindow)
+		d.releaseAssociation(pdu)
+	}
+}
+
+func Test_RoleSelection(t *testing.T) {
+	port := 1056
+	_, testSCP := StartSCP(t, port)
+	policy := NewAcceptancePolicy()
+	policy.AddRoles([]*sopclass.SOPClass{sopclass.CTImageStorage, sopclass.MRImageStorage}, false, true)
+	testSCP.SetAcceptancePolicy(policy)
+	testSCP.OnAssociationRequest(func(request *AAssociationRQ) bool { return true })
+	testSCP.OnCGetRequest(func(request *AAssociationRQ, getLevel string, query *media.DcmObj) ([]string, uint16) {
+		return []string{"../samples/test.dcm", "../samples/test2.dcm"}, dicomstatus.Success
+	})
+	var received []string
+	d := NewSCU(&Destination{CalledAE: "TEST_SCP", CallingAE: "TEST_SCU", HostName: "localhost", Port: port})
+	d.SetOnCStoreRequest(func(data *media.DcmObj) uint16 {
+		received = append(received, data.GetString(tags.SOPClassUID))
+		return dicomstatus.Success
+	})
+	status, err := d.GetSCU(utils.DefaultCMoveRequest("1.2.3"), 0)
+	assert.NoError(t, err, "GetSCU should be ok")
+	assert.Equal(t, dicomstatus.Success, status)
+	assert.Len(t, received, 2)
+
+	// MR no longer proposed with the SCP role, the SCP can not send it back
+	received = nil
+	d.SetRoleSelection([]*sopclass.SOPClass{sopclass.MRImageStorage}, true, false)
+	status, err = d.GetSCU(utils.DefaultCMoveRequest("1.2.3"), 0)
+	assert.NoError(t, err, "GetSCU should be ok")
+	assert.Equal(t, dicomstatus.CMoveWarningOneOrMoreFailures, status)
+	assert.Equal(t, []string{sopclass.CTImageStorage.UID}, received)
+
+	// Denied by the SCP
+	received = nil
+	policy.AddRole(sopclass.CTImageStorage, true, false)
+	d.SetRoleSelection([]*sopclass.SOPClass{sopclass.MRImageStorage}, false, true)
+	status, err = d.GetSCU(utils.DefaultCMoveRequest("1.2.3"), 0)
+	assert.NoError(t, err, "GetSCU should be ok")
+	assert.Equal(t, dicomstatus.CMoveWarningOneOrMoreFailures, status)
+	assert.Equal(t, []string{sopclass.MRImageStorage.UID}, received)
+}
+
+func Test_UserIdentity(t *testing.T) {
+	port := 1057
+	_, testSCP := StartSCP(t, port)
+	testSCP.OnAssociationRequest(func(request *AAssociationRQ) bool {
+		identity := request.GetUserIdentity()
+		if identity == nil {
+			return false
+		}
+		switch identity.GetUserIdentityType() {
+		case UserIdentityUsernamePasscode:
+			return identity.GetUsername() == "user" && identity.GetPasscode() == "secret"
+		case UserIdentityJWT:
+			request.SetUserIdentityResponse([]byte("accepted " + string(identity.GetPrimaryField())))
+			return true
+		}
+		return false
+	})
+
+	d := NewSCU(&Destination{CalledAE: "TEST_SCP", CallingAE: "TEST_SCU", HostName: "localhost", Port: port})
+	assert.Error(t, d.EchoSCU(0), "No identity should be rejected")
+	d.SetUserIdentity(NewUsernameIdentity("user", "wrong"))
+	assert.Error(t, d.EchoSCU(0), "Wrong passcode should be rejected")
+	d.SetUserIdentity(NewUsernameIdentity("user", "secret"))
+	assert.NoError(t, d.EchoSCU(0))
+
+	identity := NewJWTIdentity("token")
+	identity.SetPositiveResponseRequested(true)
+	d.SetUserIdentity(identity)
+	var response string
+	d.SetOnUserIdentityResponse(func(serverResponse []byte) error {
+		response = string(serverResponse)
+		return nil
+	})
+	assert.NoError(t, d.EchoSCU(0))
+	assert.Equal(t, "accepted token", response)
+}
+
+func Test_ExtendedNegotiation(t *testing.T) {
+	port := 1058
+	_, testSCP := StartSCP(t, port)
+	policy := NewAcceptancePolicy()
+	policy.AddExtendedNegotiation(sopclass.StudyRootQueryRetrieveInformationModelFind, func(proposed []byte) []byte {
+		options := ParseQueryRetrieveOptions(proposed)
+		options.RelationalQueries = false
+		return options.Bytes()
+	})
+	testSCP.SetAcceptancePolicy(policy)
+	proposed := make(chan *AAssociationRQ, 1)
+	testSCP.OnAssociationRequest(func(request *AAssociationRQ) bool {
+		proposed <- request
+		return true
+	})
+	testSCP.OnCFindRequest(func(request *AAssociationRQ, query *media.DcmObj) ([]*media.DcmObj, uint16) {
+		return nil, dicomstatus.Success
+	})
+
+	d := NewSCU(&Destination{CalledAE: "TEST_SCP", CallingAE: "TEST_SCU", HostName: "localhost", Port: port})
+	d.SetExtendedNegotiation(sopclass.StudyRootQueryRetrieveInformationModelFind, QueryRetrieveOptions{RelationalQueries: true, FuzzyMatching: true}.Bytes())
+	d.SetExtendedNegotiation(sopclass.CTImageStorage, StorageOptions{}.Bytes())
+	d.SetCommonExtendedNegotiation(sopclass.StudyRootQueryRetrieveInformationModelFind, "1.2.840.10008.4.2", "1.2.3")
+	accepted := map[string][]byte{}
+	d.SetOnExtendedNegotiation(func(sopClassUID string, info []byte) {
+		accepted[sopClassUID] = info
+	})
+	_, status, err := d.FindSCU(utils.DefaultCFindRequest(), 0)
+	assert.NoError(t, err, "FindSCU should be ok")
+	assert.Equal(t, dicomstatus.Success, status)
+
+	request := <-proposed
+	assert.Equal(t, QueryRetrieveOptions{RelationalQueries: true, FuzzyMatching: true}, ParseQueryRetrieveOptions(request.GetExtendedNegotiation(sopclass.StudyRootQueryRetrieveInformationModelFind.UID)))
+	assert.Nil(t, request.GetExtendedNegotiation(sopclass.CTImageStorage.UID), "Only negotiated SOP classes should be proposed")
+	common := request.GetCommonExtendedNegotiation(sopclass.StudyRootQueryRetrieveInformationModelFind.UID)
+	if assert.NotNil(t, common) {
+		assert.Equal(t, "1.2.840.10008.4.2", common.GetServiceClassUID())
+		assert.Equal(t, []string{"1.2.3"}, common.GetRelatedGeneralSOPClassUIDs())
+	}
+	assert.Equal(t, QueryRetrieveOptions{FuzzyMatching: true}, ParseQueryRetrieveOptions(accepted[sopclass.StudyRootQueryRetrieveInformationModelFind.UID]))
+}
+
+func Test_StorePresentationContext(t *testing.T) {
+	port := 1059
+	_, testSCP := StartSCP(t, port)
+	policy := NewAcceptancePolicy()
+	policy.AddSOPClass(sopclass.MRImageStorage, transfersyntax.ImplicitVRLittleEndian)
+	policy.AddSOPClass(sopclass.CTImageStorage, transfersyntax.ExplicitVRLittleEndian)
+	testSCP.SetAcceptancePolicy(policy)
+	testSCP.OnAssociationRequest(func(request *AAssociationRQ) bool { return true })
+	received := make(chan string, 3)
+	testSCP.OnCStoreRequest(func(request *AAssociationRQ, data *media.DcmObj) uint16 {
+		received <- data.GetString(tags.SOPClassUID)
+		return dicomstatus.Success
+	})
+
+	d := NewSCU(&Destination{CalledAE: "TEST_SCP", CallingAE: "TEST_SCU", HostName: "localhost", Port: port})
+	results := map[string]*CStoreResult{}
+	d.SetOnCStoreInstance(func(result *CStoreResult) {
+		results[result.File] = result
+	})
+	assert.NoError(t, d.StoreSCU([]string{"../samples/test.dcm", "../samples/test2.dcm", "../samples/rle_gray.dcm"}, 0, transfersyntax.ExplicitVRLittleEndian.UID, transfersyntax.ImplicitVRLittleEndian.UID))
+	assert.Len(t, received, 2)
+	assert.ElementsMatch(t, []string{sopclass.MRImageStorage.UID, sopclass.CTImageStorage.UID}, []string{<-received, <-received})
+
+	mr, ct, nm := results["../samples/test.dcm"], results["../samples/test2.dcm"], results["../samples/rle_gray.dcm"]
+	assert.NoError(t, mr.Error)
+	assert.Equal(t, transfersyntax.ImplicitVRLittleEndian.UID, mr.TransferSyntaxUID, "MR should be transcoded")
+	assert.NoError(t, ct.Error)
+	assert.Equal(t, transfersyntax.ExplicitVRLittleEndian.UID, ct.TransferSyntaxUID)
+	assert.NotEqual(t, mr.PresentationContextID, ct.PresentationContextID)
+	assert.ErrorContains(t, nm.Error, "no presentation context accepted")
+	assert.Equal(t, byte(0), nm.PresentationContextID)
+}
+
+func Test_StoreResults(t *testing.T) {
+	port := 1060
+	_, testSCP := StartSCP(t, port)
+	testSCP.OnAssociationRequest(func(request *AAssociationRQ) bool { return true })
+	var full atomic.Bool
+	full.Store(true)
+	testSCP.OnCStoreRequest(func(request *AAssociationRQ, data *media.DcmObj) uint16 {
+		if data.GetString(tags.SOPClassUID) == sopclass.MRImageStorage.UID {
+			return dicomstatus.CStoreWarningCoercionOfDataElements
+		}
+		if full.Load() {
+			return dicomstatus.FailureOutOfResources
+		}
+		return dicomstatus.Success
+	})
+
+	dir := t.TempDir()
+	queue, err := NewRetryQueue(dir)
+	assert.NoError(t, err)
+	d := NewSCU(&Destination{CalledAE: "TEST_SCP", CallingAE: "TEST_SCU", HostName: "localhost", Port: port})
+	d.SetRetryQueue(queue)
+	results, err := d.StoreSCUResults([]string{"../samples/test.dcm", "../samples/test2.dcm", "missing.dcm"}, 0)
+	assert.NoError(t, err)
+	if assert.Len(t, results, 3) {
+		obj, _ := media.NewDCMObjFromFile("../samples/test.dcm")
+		assert.Equal(t, obj.GetString(tags.SOPInstanceUID), results[0].SOPInstanceUID)
+		assert.True(t, results[0].Warning(), "Warning should be stored")
+		assert.True(t, results[0].Transcoded, "Explicit VR should be transcoded")
+		assert.Error(t, results[1].Error)
+		assert.Equal(t, dicomstatus.FailureOutOfResources, results[1].Status)
+		assert.Error(t, results[2].Error)
+	}
+	assert.Equal(t, []string{"../samples/test2.dcm"}, queue.Files(), "Only files that can be sent again should be queued")
+
+	// After a restart
+	full.Store(false)
+	queue, err = NewRetryQueue(dir)
+	assert.NoError(t, err)
+	if assert.Len(t, queue.Entries(), 1) {
+		assert.Equal(t, 1, queue.Entries()[0].Attempts)
+	}
+	d = NewSCU(&Destination{CalledAE: "TEST_SCP", CallingAE: "TEST_SCU", HostName: "localhost", Port: port})
+	d.SetRetryQueue(queue)
+	results, err = d.StoreRetryQueue(0)
+	assert.NoError(t, err)
+	if assert.Len(t, results, 1) {
+		assert.NoError(t, results[0].Error)
+	}
+	assert.Equal(t, 0, queue.Len())
+
+	// Association failure
+	d = NewSCU(&Destination{CalledAE: "TEST_SCP", CallingAE: "TEST_SCU", HostName: "localhost", Port: port + 1})
+	d.SetRetryQueue(queue)
+	results, err = d.StoreSCUResults([]string{"../samples/test2.dcm"}, 0)
+	assert.Error(t, err)
+	if assert.Len(t, results, 1) {
+		assert.Error(t, results[0].Error)
+	}
+	assert.Equal(t, 1, queue.Len())
+}
+
+func Test_StoreSources(t *testing.T) {
+	port := 1062
+	_, testSCP := StartSCP(t, port)
+	testSCP.OnAssociationRequest(func(request *AAssociationRQ) bool { return true })
+	received := make(chan *media.DcmObj, 4)
+	testSCP.OnCStoreRequest(func(request *AAssociationRQ, data *media.DcmObj) uint16 {
+		received <- data
+		return dicomstatus.Success
+	})
+
+	sr := media.NewEmptyDCMObj()
+	sr.WriteString(tags.SOPClassUID, sopclass.BasicTextSRStorage.UID)
+	sr.WriteString(tags.SOPInstanceUID, "1.2.3.4")
+	sr.WriteString(tags.PatientName, "SR^TEST")
+	data, err := os.ReadFile("../samples/test.dcm")
+	assert.NoError(t, err)
+	file, err := os.Open("../samples/test2.dcm")
+	assert.NoError(t, err)
+
+	d := NewSCU(&Destination{CalledAE: "TEST_SCP", CallingAE: "TEST_SCU", HostName: "localhost", Port: port})
+	results, err := d.StoreSCUObjects([]*media.DcmObj{sr}, 0)
+	assert.NoError(t, err)
+	if assert.Len(t, results, 1) {
+		assert.NoError(t, results[0].Error)
+		assert.Equal(t, "1.2.3.4", results[0].SOPInstanceUID)
+	}
+	obj := <-received
+	assert.Equal(t, "SR^TEST", obj.GetString(tags.PatientName))
+
+	results, err = d.StoreSCUSourcesContext(context.Background(), []*StoreSource{
+		ReaderSource(bytes.NewReader(data)),
+		ReaderSource(file),
+		FileSource("../samples/test.dcm"),
+		ReaderSource(bytes.NewReader([]byte("not DICOM"))),
+	})
+	assert.NoError(t, err)
+	if assert.Len(t, results, 4) {
+		assert.NoError(t, results[0].Error)
+		assert.NoError(t, results[1].Error)
+		assert.Equal(t, "", results[1].File)
+		assert.Equal(t, "../samples/test.dcm", results[2].File)
+		assert.Error(t, results[3].Error)
+	}
+	assert.Len(t, received, 3)
+}
+
+// closeRecorder - a stream recording whether it was closed
+type closeRecorder struct {
+	io.Reader
+	closed bool
+}
+
+func (c *closeRecorder) Close() error {
+	c.closed = true
+	return nil
+}
+
+func Test_ReaderSourceClosed(t *testing.T) {
+	// No SCP listening, StoreSCU stops before reading the streams
+	d := NewSCU(&Destination{CalledAE: "TEST_SCP", CallingAE: "TEST_SCU", HostName: "localhost", Port: 1077})
+	streams := []*closeRecorder{{Reader: bytes.NewReader(nil)}, {Reader: bytes.NewReader(nil)}}
+	results, err := d.StoreSCUSourcesContext(context.Background(), []*StoreSource{ReaderSource(streams[0]), ReaderSource(streams[1])})
+	assert.Error(t, err)
+	assert.Len(t, results, 2)
+	for _, stream := range streams {
+		assert.True(t, stream.closed)
+	}
+}
+
+func Test_DeflatedTransferSyntax(t *testing.T) {
+	port := 1069
+	_, testSCP := StartSCP(t, port)
+	testSCP.OnAssociationRequest(func(request *AAssociationRQ) bool { return true })
+	received := make(chan *media.DcmObj, 2)
+	testSCP.OnCStoreRequest(func(request *AAssociationRQ, data *media.DcmObj) uint16 {
+		received <- data
+		return dicomstatus.Success
+	})
+
+	sr := media.NewEmptyDCMObj()
+	sr.WriteString(tags.SOPClassUID, sopclass.BasicTextSRStorage.UID)
+	sr.WriteString(tags.SOPInstanceUID, "1.2.3.4")
+	sr.WriteString(tags.PatientName, "SR^TEST")
+	d := NewSCU(&Destination{CalledAE: "TEST_SCP", CallingAE: "TEST_SCU", HostName: "localhost", Port: port})
+	results, err := d.StoreSCUObjects([]*media.DcmObj{sr}, 0, transfersyntax.DeflatedExplicitVRLittleEndian.UID)
+	assert.NoError(t, err)
+	if assert.Len(t, results, 1) {
+		assert.NoError(t, results[0].Error)
+		assert.Equal(t, transfersyntax.DeflatedExplicitVRLittleEndian.UID, results[0].TransferSyntaxUID)
+	}
+	obj := <-received
+	assert.Equal(t, "SR^TEST", obj.GetString(tags.PatientName))
+	assert.Equal(t, transfersyntax.DeflatedExplicitVRLittleEndian, obj.GetTransferSyntax())
+
+	results, err = d.StoreSCUResults([]string{"../samples/test2.dcm"}, 0, transfersyntax.DeflatedExplicitVRLittleEndian.UID)
+	assert.NoError(t, err)
+	if assert.Len(t, results, 1) {
+		assert.NoError(t, results[0].Error)
+		assert.True(t, results[0].Transcoded)
+	}
+	file, _ := media.NewDCMObjFromFile("../samples/test2.dcm")
+	obj = <-received
+	assert.Equal(t, file.GetString(tags.PatientName), obj.GetString(tags.PatientName))
+	assert.Equal(t, file.TagCount(), obj.TagCount())
+}
+
+func Test_BigEndianTransferSyntax(t *testing.T) {
+	port := 1076
+	_, testSCP := StartSCP(t, port)
+	testSCP.OnAssociationRequest(func(request *AAssociationRQ) bool { return true })
+	received := make(chan *media.DcmObj, 1)
+	testSCP.OnCStoreRequest(func(request *AAssociationRQ, data *media.DcmObj) uint16 {
+		received <- data
+		return dicomstatus.Success
+	})
+
+	d := NewSCU(&Destination{CalledAE: "TEST_SCP", CallingAE: "TEST_SCU", HostName: "localhost", Port: port})
+	results, err := d.StoreSCUResults([]string{"../samples/test2.dcm"}, 0, transfersyntax.ExplicitVRBigEndian.UID)
+	assert.NoError(t, err)
+	if assert.Len(t, results, 1) {
+		assert.NoError(t, results[0].Error)
+		assert.Equal(t, transfersyntax.ExplicitVRBigEndian.UID, results[0].TransferSyntaxUID)
+	}
+	file, _ := media.NewDCMObjFromFile("../samples/test2.dcm")
+	obj := <-received
+	assert.Equal(t, transfersyntax.ExplicitVRBigEndian, obj.GetTransferSyntax())
+	assert.Equal(t, file.GetString(tags.PatientName), obj.GetString(tags.PatientName))
+	assert.Equal(t, file.GetUShort(tags.Rows), obj.GetUShort(tags.Rows))
+	assert.Equal(t, file.TagCount(), obj.TagCount())
+}
+
+func Test_AssociationPool(t *testing.T) {
+	port := 1063
+	_, testSCP := StartSCP(t, port)
+	var associations, released atomic.Int32
+	testSCP.OnAssociationRequest(func(request *AAssociationRQ) bool {
+		associations.Add(1)
+		return true
+	})
+	testSCP.OnAssociationRelease(func(request *AAssociationRQ) { released.Add(1) })
+	testSCP.OnCFindRequest(func(request *AAssociationRQ, query *media.DcmObj) ([]*media.DcmObj, uint16) {
+		return []*media.DcmObj{query}, dicomstatus.Success
+	})
+
+	pool := NewAssociationPool(4, 300*time.Millisecond)
+	defer pool.Close()
+	destination := &Destination{CalledAE: "TEST_SCP", CallingAE: "TEST_SCU", HostName: "localhost", Port: port}
+	query := media.NewEmptyDCMObj()
+	query.WriteString(tags.QueryRetrieveLevel, "STUDY")
+
+	d := NewSCU(destination)
+	d.SetAssociationPool(pool)
+	for i := 0; i < 5; i++ {
+		results, status, err := d.FindSCU(query, 5)
+		assert.NoError(t, err)
+		assert.Equal(t, dicomstatus.Success, status)
+		assert.Equal(t, 1, results)
+	}
+	assert.NoError(t, d.EchoSCU(5))
+	assert.Equal(t, int32(2), associations.Load(), "one association for C-FIND, one for C-ECHO")
+	assert.Equal(t, 2, pool.Idle())
+
+	// Checked with a C-ECHO before being reused
+	pool.SetHealthCheckInterval(time.Nanosecond)
+	_, _, err := d.FindSCU(query, 5)
+	assert.NoError(t, err)
+	assert.Equal(t, int32(2), associations.Load())
+	pool.SetHealthCheckInterval(0)
+
+	var wg sync.WaitGroup
+	var failed atomic.Int32
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			d := NewSCU(destination)
+			d.SetAssociationPool(pool)
+			for j := 0; j < 10; j++ {
+				if _, _, err := d.FindSCU(query, 5); err != nil {
+					failed.Add(1)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Zero(t, failed.Load())
+	assert.LessOrEqual(t, associations.Load(), int32(2+8))
+	assert.LessOrEqual(t, pool.Idle(), 4+1)
+
+	// Released once idle for too long
+	time.Sleep(600 * time.Millisecond)
+	assert.Zero(t, pool.Idle())
+	assert.Equal(t, associations.Load(), released.Load())
+}
+
+func Test_ConcurrentSCU(t *testing.T) {
+	port := 1064
+	_, testSCP := StartSCP(t, port)
+	var invalidContexts, stored atomic.Int32
+	testSCP.OnAssociationRequest(func(request *AAssociationRQ) bool {
+		ids := make(map[byte]bool)
+		for _, pc := range request.GetPresContexts() {
+			if id := pc.GetPresentationContextID(); id%2 == 0 || ids[id] {
+				invalidContexts.Add(1)
+			} else {
+				ids[id] = true
+			}
+		}
+		if request.GetImpClass().GetUID() != "1.2.3.4" {
+			invalidContexts.Add(1)
+		}
+		return true
+	})
+	testSCP.OnCStoreRequest(func(request *AAssociationRQ, data *media.DcmObj) uint16 {
+		stored.Add(1)
+		return dicomstatus.Success
+	})
+	testSCP.OnCFindRequest(func(request *AAssociationRQ, query *media.DcmObj) ([]*media.DcmObj, uint16) {
+		return []*media.DcmObj{query}, dicomstatus.Success
+	})
+
+	// One SCU shared by all the goroutines, with and without a pool
+	d := NewSCU(&Destination{CalledAE: "TEST_SCP", CallingAE: "TEST_SCU", HostName: "localhost", Port: port})
+	d.SetImplementation("1.2.3.4", "CONCURRENT")
+	pooled := NewSCU(&Destination{CalledAE: "TEST_SCP", CallingAE: "TEST_SCU", HostName: "localhost", Port: port})
+	pooled.SetImplementation("1.2.3.4", "CONCURRENT")
+	pool := NewAssociationPool(0, time.Second)
+	defer pool.Close()
+	pooled.SetAssociationPool(pool)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 64)
+	for i := 0; i < 8; i++ {
+		for _, user := range []*scu{d, pooled} {
+			wg.Add(1)
+			go func(user *scu) {
+				defer wg.Done()
+				query := media.NewEmptyDCMObj()
+				query.WriteString(tags.QueryRetrieveLevel, "STUDY")
+				for j := 0; j < 3; j++ {
+					if err := user.StoreSCU([]string{"../samples/test.dcm"}, 5); err != nil {
+						errs <- err
+					}
+					if _, _, err := user.FindSCU(query, 5); err != nil {
+						errs <- err
+					}
+					if err := user.EchoSCU(5); err != nil {
+						errs <- err
+					}
+				}
+			}(user)
+		}
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		assert.NoError(t, err)
+	}
+	assert.Zero(t, invalidContexts.Load())
+	assert.Equal(t, int32(2*8*3), stored.Load())
+}
+
+func Test_Shutdown(t *testing.T) {
+	port := 1065
+	testSCP := NewSCP(port)
+	testSCP.OnAssociationRequest(func(request *AAssociationRQ) bool { return true })
+	stored := make(chan bool)
+	testSCP.OnCStoreRequest(func(request *AAssociationRQ, data *media.DcmObj) uint16 {
+		stored <- true
+		<-stored
+		return dicomstatus.Success
+	})
+	go testSCP.Start()
+	time.Sleep(100 * time.Millisecond) // wait for server started
+
+	d := NewSCU(&Destination{CalledAE: "TEST_SCP", CallingAE: "TEST_SCU", HostName: "localhost", Port: port})
+	storeErr := make(chan error)
+	go func() { storeErr <- d.StoreSCU([]string{"../samples/test.dcm"}, 5) }()
+	<-stored
+
+	shutdownErr := make(chan error)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		shutdownErr <- testSCP.Shutdown(ctx)
+	}()
+	time.Sleep(100 * time.Millisecond)
+	assert.Error(t, d.EchoSCU(5), "no association accepted once shutting down")
+
+	// The active association completes
+	stored <- true
+	assert.NoError(t, <-storeErr)
+	assert.NoError(t, <-shutdownErr)
+}
+
+func Test_ShutdownAbort(t *testing.T) {
+	port := 1066
+	testSCP := NewSCP(port)
+	testSCP.OnAssociationRequest(func(request *AAssociationRQ) bool { return true })
+	var cancelled atomic.Bool
+	testSCP.OnCStoreRequest(func(request *AAssociationRQ, data *media.DcmObj) uint16 {
+		select {
+		case <-request.Context().Done():
+			cancelled.Store(true)
+		case <-time.After(5 * time.Second):
+		}
+		return dicomstatus.FailureUnableToProcess
+	})
+	go testSCP.Start()
+	time.Sleep(100 * time.Millisecond) // wait for server started
+
+	d := NewSCU(&Destination{CalledAE: "TEST_SCP", CallingAE: "TEST_SCU", HostName: "localhost", Port: port})
+	storeErr := make(chan error)
+	go func() {
+		results, _ := d.StoreSCUResults([]string{"../samples/test.dcm"}, 10)
+		storeErr <- results[0].Error
+	}()
+	time.Sleep(200 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	assert.ErrorIs(t, testSCP.Shutdown(ctx), context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), time.Second)
+	assert.Error(t, <-storeErr, "association aborted")
+	assert.Eventually(t, cancelled.Load, time.Second, 10*time.Millisecond)
+}
+
+func Test_AssociationLimits(t *testing.T) {
+	port := 1067
+	testSCP := NewSCP(port)
+	testSCP.OnAssociationRequest(func(request *AAssociationRQ) bool { return true })
+	testSCP.SetMaxAssociations(3)
+	testSCP.SetMaxAssociationsPerAE(1)
+	testSCP.SetCallingAEMaxAssociations("SCU_C", 2)
+	go testSCP.Start()
+	defer testSCP.Stop()
+	time.Sleep(100 * time.Millisecond) // wait for server started
+
+	open := func(callingAE string) (*pduService, error) {
+		d := NewSCU(&Destination{CalledAE: "TEST_SCP", CallingAE: callingAE, HostName: "localhost", Port: port})
+		return d.openAssociation(newPDUService(), []*sopclass.SOPClass{sopclass.Verification}, []string{}, 5)
+	}
+	a1, err := open("SCU_A")
+	assert.NoError(t, err)
+	_, err = open("SCU_A")
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "Local limit exceeded")
+	}
+	c1, err := open("SCU_C")
+	assert.NoError(t, err)
+	c2, err := open("SCU_C")
+	assert.NoError(t, err)
+	_, err = open("SCU_B")
+	assert.Error(t, err, "3 associations at most")
+
+	a1.Close()
+	time.Sleep(100 * time.Millisecond)
+	b1, err := open("SCU_B")
+	assert.NoError(t, err)
+	for _, pdu := range []*pduService{b1, c1, c2} {
+		if pdu != nil {
+			pdu.Close()
+		}
+	}
+}
+
+func Test_IdleTimeouts(t *testing.T) {
+	port := 1068
+	testSCP := NewSCP(port)
+	testSCP.OnAssociationRequest(func(request *AAssociationRQ) bool { return true })
+	testSCP.SetARTIMTimeout(200 * time.Millisecond)
+	testSCP.SetIdleTimeout(300 * time.Millisecond)
+	go testSCP.Start()
+	defer testSCP.Stop()
+	time.Sleep(100 * time.Millisecond) // wait for server started
+
+	// No association requested
+	conn, err := net.Dial("tcp", fmt.Sprintf("localhost:%d", port))
+	assert.NoError(t, err)
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	start := time.Now()
+	_, err = conn.Read(make([]byte, 1))
+	assert.ErrorIs(t, err, io.EOF)
+	assert.Less(t, time.Since(start), 2*time.Second)
+
+	// No request sent
+	d := NewSCU(&Destination{CalledAE: "TEST_SCP", CallingAE: "TEST_SCU", HostName: "localhost", Port: port})
+	pdu, err := d.openAssociation(newPDUService(), []*sopclass.SOPClass{sopclass.Verification}, []string{}, 5)
+	if assert.NoError(t, err) {
+		assert.NoError(t, echoAssociation(pdu), "the peer is not idle")
+		_, err = pdu.NextPDU()
+		if assert.Error(t, err) {
+			assert.Contains(t, err.Error(), "A-Abort")
+		}
+		pdu.Conn.Close()
+	}
+
+	// Responses not read
+	stopped := make(chan bool, 1)
+	testSCP.OnCFindRequestStream(func(request *AAssociationRQ, query *media.DcmObj, yield func(result *media.DcmObj) bool) uint16 {
+		result := media.NewEmptyDCMObj()
+		result.WriteString(tags.PatientName, strings.Repeat("A", 1<<16))
+		for yield(result) {
+		}
+		stopped <- true
+		return dicomstatus.Success
+	})
+	pdu, err = d.openAssociation(newPDUService(), []*sopclass.SOPClass{sopclass.StudyRootQueryRetrieveInformationModelFind}, []string{}, 5)
+	if assert.NoError(t, err) {
+		defer pdu.Conn.Close()
+		query := media.NewEmptyDCMObj()
+		query.WriteString(tags.QueryRetrieveLevel, "STUDY")
+		assert.NoError(t, pdu.WriteRQ(dicomcommand.CFindRequest, query))
+		select {
+		case <-stopped:
+		case <-time.After(5 * time.Second):
+			assert.Fail(t, "the SCP should stop writing to a peer that reads nothing")
+		}
+	}
+}
+
+// newTestCertificate - self-signed certificate and the pool trusting it
+func newTestCertificate(t *testing.T, commonName string) (tls.Certificate, *x509.CertPool) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	assert.NoError(t, err)
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: cert}, pool
+}
+
 func dcmtk_findscu(port int) error {
 	return exe("findscu", "-d", "-S", "-k", "QueryRetrieveLevel=STUDY", "-k", "PatientName=", "127.0.0.1", strconv.Itoa(port))
 }
@@ -122,3 +1390,10 @@ func StartSCP(t testing.TB, port int) (func(t testing.TB), *scp) {
 		}
 	}, testSCP
 }
+
+// concurrency - most requests outstanding at once
+func (p *pduProxy) concurrency() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.mostOutstanding
+}
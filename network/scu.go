@@ -1,12 +1,18 @@
 package network
 
 import (
+	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
+	"io"
 	"log/slog"
+	"slices"
 	"strconv"
+	"time"
 
 	"github.com/t2care/obd-dicom/dictionary/sopclass"
+	"github.com/t2care/obd-dicom/dictionary/tags"
 	"github.com/t2care/obd-dicom/dictionary/transfersyntax"
 	"github.com/t2care/obd-dicom/media"
 	"github.com/t2care/obd-dicom/network/dicomcommand"
@@ -14,10 +20,49 @@ import (
 )
 
 type scu struct {
-	destination    *Destination
-	onCFindResult  func(result *media.DcmObj)
-	onCMoveResult  func(result *media.DcmObj)
-	onCStoreResult func(pending, completed, failed uint16) error
+	destination      *Destination
+	onCFindResult    func(result *media.DcmObj)
+	onCMoveResult    func(result *media.DcmObj)
+	onCGetResult     func(result *media.DcmObj)
+	onCStoreResult   func(pending, completed, failed uint16) error
+	onCStoreInstance func(result *CStoreResult)
+	retryQueue       *RetryQueue
+	onCStoreRequest  func(data *media.DcmObj) uint16
+	maxPDULength     uint32
+	maxOpsInvoked    uint16
+	maxOpsPerformed  uint16
+	roles            []*roleSelect
+	extNegotiations  []*sopClassExtendedNegotiation
+	commonExts       []*sopClassCommonExtendedNegotiation
+	onExtNegotiation func(sopClassUID string, info []byte)
+	identity         *userIdentity
+	onIdentity       func(serverResponse []byte) error
+	pool             *AssociationPool
+	impClassUID      string
+	impVersion       string
+	reportTimeout    time.Duration
+}
+
+// DefaultStorageCommitmentReportTimeout - Time the N-EVENT-REPORT is awaited on the association of the N-ACTION,
+// unless configured otherwise
+const DefaultStorageCommitmentReportTimeout = 30 * time.Second
+
+// CStoreResult - C-STORE of one instance
+type CStoreResult struct {
+	File                  string
+	SOPClassUID           string
+	SOPInstanceUID        string
+	PresentationContextID byte   // Context the instance was sent on, 0 when none was accepted for its SOP class
+	TransferSyntaxUID     string // Accepted for the context
+	Transcoded            bool
+	Status                uint16
+	ErrorComment          string // Warning or failure reason given by the SCP
+	Error                 error  // nil when the instance was stored, with or without a warning
+}
+
+// Warning - the instance was stored with a warning, eg. dicomstatus.CStoreWarningCoercionOfDataElements
+func (res *CStoreResult) Warning() bool {
+	return res.Error == nil && dicomstatus.IsWarning(res.Status)
 }
 
 type FindMode uint8
@@ -29,29 +74,122 @@ const (
 	FINDPatientStudyOnly
 )
 
+// Performed Procedure Step Status of a Modality Performed Procedure Step
+const (
+	MPPSInProgress   = "IN PROGRESS"
+	MPPSCompleted    = "COMPLETED"
+	MPPSDiscontinued = "DISCONTINUED"
+)
+
 // NewSCU - Creates an interface to scu
 func NewSCU(destination *Destination) *scu {
 	return &scu{
-		destination: destination,
+		destination:     destination,
+		maxPDULength:    DefaultMaxPDULength,
+		maxOpsInvoked:   1,
+		maxOpsPerformed: 1,
+		reportTimeout:   DefaultStorageCommitmentReportTimeout,
 	}
 }
 
+// SetRoleSelection - Proposes the roles for the SOP classes whenever they are negotiated, eg. the SCP role for storage classes.
+// GetSCU proposes the SCP role for the storage classes it negotiates, with the SOP classes given the SCP role here
+func (d *scu) SetRoleSelection(sopClasses []*sopclass.SOPClass, scuRole bool, scpRole bool) {
+	for _, sopClass := range sopClasses {
+		d.roles = slices.DeleteFunc(d.roles, func(role *roleSelect) bool { return role.GetUID() == sopClass.UID })
+		d.roles = append(d.roles, NewRoleSelectUID(sopClass.UID, roleValue(scuRole), roleValue(scpRole)))
+	}
+}
+
+// SetExtendedNegotiation - Proposes the Service-class-application-information whenever the SOP class is negotiated,
+// eg. QueryRetrieveOptions{FuzzyMatching: true}.Bytes()
+func (d *scu) SetExtendedNegotiation(sopClass *sopclass.SOPClass, info []byte) {
+	d.extNegotiations = slices.DeleteFunc(d.extNegotiations, func(ext *sopClassExtendedNegotiation) bool { return ext.GetUID() == sopClass.UID })
+	d.extNegotiations = append(d.extNegotiations, NewSOPClassExtendedNegotiation(sopClass.UID, info))
+}
+
+// SetCommonExtendedNegotiation - Proposes the service class and the general SOP classes a specialized SOP class is related to
+func (d *scu) SetCommonExtendedNegotiation(sopClass *sopclass.SOPClass, serviceClassUID string, relatedGeneralUIDs ...string) {
+	d.commonExts = slices.DeleteFunc(d.commonExts, func(ext *sopClassCommonExtendedNegotiation) bool { return ext.GetUID() == sopClass.UID })
+	d.commonExts = append(d.commonExts, NewSOPClassCommonExtendedNegotiation(sopClass.UID, serviceClassUID, relatedGeneralUIDs...))
+}
+
+// SetOnExtendedNegotiation - called with the Service-class-application-information answered for each SOP class
+func (d *scu) SetOnExtendedNegotiation(f func(sopClassUID string, info []byte)) {
+	d.onExtNegotiation = f
+}
+
+// SetUserIdentity - Sent with every association, eg. NewUsernameIdentity or NewJWTIdentity
+func (d *scu) SetUserIdentity(identity *userIdentity) {
+	d.identity = identity
+}
+
+// SetOnUserIdentityResponse - called with the server response when the identity asked for a positive response.
+// The association fails when the response is missing or f returns an error
+func (d *scu) SetOnUserIdentityResponse(f func(serverResponse []byte) error) {
+	d.onIdentity = f
+}
+
+// SetAsyncOperationsWindow - Proposes to invoke and perform operations asynchronously, 0 is unlimited.
+// StoreSCU keeps as many C-STORE requests outstanding as the peer accepts
+func (d *scu) SetAsyncOperationsWindow(invoked uint16, performed uint16) {
+	d.maxOpsInvoked = invoked
+	d.maxOpsPerformed = performed
+}
+
+// SetAssociationPool - Operations reuse the idle associations of the pool instead of opening their own,
+// the pool can be shared by the SCUs of many goroutines
+func (d *scu) SetAssociationPool(pool *AssociationPool) {
+	d.pool = pool
+}
+
+// SetImplementation - Implementation Class UID and Version Name of the associations, those of the imp package otherwise
+func (d *scu) SetImplementation(classUID string, version string) {
+	d.impClassUID = classUID
+	d.impVersion = version
+}
+
+// SetStorageCommitmentReportTimeout - Time the N-EVENT-REPORT is awaited by RequestStorageCommitment, the result is Pending
+// once it expires. 0 waits until the peer releases the association or the association timeout expires
+func (d *scu) SetStorageCommitmentReportTimeout(timeout time.Duration) {
+	d.reportTimeout = timeout
+}
+
+// SetMaxPDULength - Maximum length of the P-DATA-TF received, proposed to the peer. 0 is unlimited
+func (d *scu) SetMaxPDULength(length uint32) {
+	d.maxPDULength = length
+}
+
 func (d *scu) EchoSCU(timeout int) error {
+	return d.echo(context.Background(), timeout)
+}
+
+// EchoSCUContext - EchoSCU aborted when the context is done
+func (d *scu) EchoSCUContext(ctx context.Context) error {
+	return d.echo(ctx, 0)
+}
+
+func (d *scu) echo(ctx context.Context, timeout int) error {
 	pdu := newPDUService()
-	if err := d.openAssociation(pdu, []*sopclass.SOPClass{sopclass.Verification}, []string{}, timeout); err != nil {
-		return err
-	}
-	defer pdu.Close()
-	if err := pdu.WriteRQ(dicomcommand.CEchoRequest, media.NewEmptyDCMObj()); err != nil {
-		return err
-	}
-	if _, _, err := pdu.ReadResp(); err != nil {
-		return err
+	pdu.SetContext(ctx)
+	pdu, err := d.openAssociation(pdu, []*sopclass.SOPClass{sopclass.Verification}, []string{}, timeout)
+	if err != nil {
+		return contextError(ctx, err)
 	}
-	return nil
+	defer d.releaseAssociation(pdu)
+	return contextError(ctx, echoAssociation(pdu))
 }
 
 func (d *scu) FindSCU(Query *media.DcmObj, timeout int, mode ...FindMode) (int, uint16, error) {
+	return d.find(context.Background(), Query, timeout, mode...)
+}
+
+// FindSCUContext - FindSCU cancelled with a C-CANCEL when the context is done, the error is then the context one
+func (d *scu) FindSCUContext(ctx context.Context, Query *media.DcmObj, mode ...FindMode) (int, uint16, error) {
+	return d.find(ctx, Query, 0, mode...)
+}
+
+func (d *scu) find(ctx context.Context, Query *media.DcmObj, timeout int, mode ...FindMode) (int, uint16, error) {
 	results := 0
 	status := dicomstatus.Warning
 
@@ -69,18 +207,20 @@ func (d *scu) FindSCU(Query *media.DcmObj, timeout int, mode ...FindMode) (int,
 	}
 
 	pdu := newPDUService()
-	if err := d.openAssociation(pdu, []*sopclass.SOPClass{abstractSyntax}, []string{}, timeout); err != nil {
-		return results, status, err
+	pdu.SetContext(ctx)
+	pdu, err := d.openAssociation(pdu, []*sopclass.SOPClass{abstractSyntax}, []string{}, timeout)
+	if err != nil {
+		return results, status, contextError(ctx, err)
 	}
-	defer pdu.Close()
+	defer d.releaseAssociation(pdu)
 	if err := pdu.WriteRQ(dicomcommand.CFindRequest, Query); err != nil {
-		return results, status, err
+		return results, status, contextError(ctx, err)
 	}
 	for status != dicomstatus.Success {
 		ddo, s, err := pdu.ReadResp()
 		status = s
 		if err != nil {
-			return results, status, err
+			return results, status, contextError(ctx, err)
 		}
 		if (status == dicomstatus.Pending) || (status == dicomstatus.PendingWithWarnings) {
 			results++
@@ -89,30 +229,46 @@ func (d *scu) FindSCU(Query *media.DcmObj, timeout int, mode ...FindMode) (int,
 			} else {
 				slog.Warn("No onCFindResult event found")
 			}
+		} else {
+			break
 		}
 	}
 
-	return results, status, nil
+	return results, status, ctx.Err()
 }
 
 func (d *scu) MoveSCU(destAET string, Query *media.DcmObj, timeout int) (uint16, error) {
+	return d.move(context.Background(), destAET, Query, timeout)
+}
+
+// MoveSCUContext - MoveSCU cancelled with a C-CANCEL when the context is done, the error is then the context one
+func (d *scu) MoveSCUContext(ctx context.Context, destAET string, Query *media.DcmObj) (uint16, error) {
+	return d.move(ctx, destAET, Query, 0)
+}
+
+func (d *scu) move(ctx context.Context, destAET string, Query *media.DcmObj, timeout int) (uint16, error) {
 	var pending int
 	status := dicomstatus.Pending
 
 	pdu := newPDUService()
-	if err := d.openAssociation(pdu, []*sopclass.SOPClass{sopclass.StudyRootQueryRetrieveInformationModelFind, sopclass.StudyRootQueryRetrieveInformationModelMove}, []string{}, timeout); err != nil {
-		return dicomstatus.FailureUnableToProcess, err
+	pdu.SetContext(ctx)
+	pdu, err := d.openAssociation(pdu, []*sopclass.SOPClass{sopclass.StudyRootQueryRetrieveInformationModelFind, sopclass.StudyRootQueryRetrieveInformationModelMove}, []string{}, timeout)
+	if err != nil {
+		return dicomstatus.FailureUnableToProcess, contextError(ctx, err)
+	}
+	defer d.releaseAssociation(pdu)
+	if pcid := pdu.getPresentationContextID(sopclass.StudyRootQueryRetrieveInformationModelMove.UID); pcid != 0 {
+		pdu.SetPresentationContextID(pcid)
 	}
-	defer pdu.Close()
 	if err := pdu.WriteRQ(dicomcommand.CMoveRequest, Query, destAET); err != nil {
-		return dicomstatus.FailureUnableToProcess, err
+		return dicomstatus.FailureUnableToProcess, contextError(ctx, err)
 	}
 
 	for status == dicomstatus.Pending {
 		ddo, s, err := pdu.ReadResp(&pending)
 		status = s
 		if err != nil {
-			return dicomstatus.FailureUnableToProcess, err
+			return dicomstatus.FailureUnableToProcess, contextError(ctx, err)
 		}
 		if d.onCMoveResult != nil {
 			d.onCMoveResult(ddo)
@@ -120,43 +276,407 @@ func (d *scu) MoveSCU(destAET string, Query *media.DcmObj, timeout int) (uint16,
 			slog.Warn("No onCMoveResult event found")
 		}
 	}
-	return status, nil
+	return status, ctx.Err()
+}
+
+// GetSCU - Retrieves the matching instances over the same association with C-GET.
+// Instances are received as C-STORE sub-operations and handed to the OnCStoreRequest callback.
+func (d *scu) GetSCU(Query *media.DcmObj, timeout int, transferSyntaxes ...string) (uint16, error) {
+	return d.get(context.Background(), Query, timeout, transferSyntaxes...)
+}
+
+// GetSCUContext - GetSCU cancelled with a C-CANCEL when the context is done, the error is then the context one
+func (d *scu) GetSCUContext(ctx context.Context, Query *media.DcmObj, transferSyntaxes ...string) (uint16, error) {
+	return d.get(ctx, Query, 0, transferSyntaxes...)
+}
+
+func (d *scu) get(ctx context.Context, Query *media.DcmObj, timeout int, transferSyntaxes ...string) (uint16, error) {
+	status := dicomstatus.Pending
+
+	if len(transferSyntaxes) == 0 {
+		transferSyntaxes = append(transferSyntaxes, transfersyntax.ExplicitVRLittleEndian.UID)
+	}
+	if !slices.Contains(transferSyntaxes, transfersyntax.ImplicitVRLittleEndian.UID) {
+		transferSyntaxes = append(transferSyntaxes, transfersyntax.ImplicitVRLittleEndian.UID)
+	}
+
+	pdu := newPDUService()
+	pdu.SetContext(ctx)
+	storageClasses := slices.Clone(sopclass.DcmShortSCUStorageSOPClassUIDs)
+	for _, role := range d.roles {
+		if role.GetSCPRole() == 1 && !slices.ContainsFunc(storageClasses, func(sop *sopclass.SOPClass) bool { return sop.UID == role.GetUID() }) {
+			if sop := sopclass.GetSOPClassFromUID(role.GetUID()); sop != nil {
+				storageClasses = append(storageClasses, sop)
+			}
+		}
+	}
+	for _, sop := range storageClasses {
+		if d.role(sop.UID) == nil {
+			pdu.AssocRQ.GetUserInformation().AddRoleSelect(NewRoleSelectUID(sop.UID, 0, 1))
+		}
+	}
+	abstractSyntaxes := append([]*sopclass.SOPClass{sopclass.StudyRootQueryRetrieveInformationModelGet}, storageClasses...)
+	pdu, err := d.openAssociation(pdu, abstractSyntaxes, transferSyntaxes, timeout)
+	if err != nil {
+		return dicomstatus.FailureUnableToProcess, contextError(ctx, err)
+	}
+	defer d.releaseAssociation(pdu)
+	if !slices.ContainsFunc(storageClasses, func(sop *sopclass.SOPClass) bool { return pdu.requestorSCPRole(sop.UID) }) {
+		slog.Warn("GetSCU: SCP role not granted for any storage class, no instance can be received")
+	}
+	getPCID := pdu.getPresentationContextID(sopclass.StudyRootQueryRetrieveInformationModelGet.UID)
+	if getPCID == 0 {
+		return dicomstatus.FailureUnableToProcess, errors.New("serviceuser::GetSCU, C-GET presentation context not accepted")
+	}
+	pdu.SetPresentationContextID(getPCID)
+	if err := pdu.WriteRQ(dicomcommand.CGetRequest, Query); err != nil {
+		return dicomstatus.FailureUnableToProcess, contextError(ctx, err)
+	}
+
+	for status == dicomstatus.Pending {
+		dco, err := pdu.NextPDU()
+		if err != nil {
+			return dicomstatus.FailureUnableToProcess, contextError(ctx, err)
+		}
+		if dco == nil {
+			continue
+		}
+		switch command := dco.GetUShort(tags.CommandField); command {
+		case dicomcommand.CStoreRequest:
+			ddo, err := pdu.NextPDU()
+			if err != nil {
+				return dicomstatus.FailureUnableToProcess, contextError(ctx, err)
+			}
+			storeStatus := dicomstatus.FailureUnableToProcess
+			if d.onCStoreRequest != nil {
+				storeStatus = d.onCStoreRequest(ddo)
+			} else {
+				slog.Warn("No onCStoreRequest event found")
+			}
+			if err := pdu.WriteResp(command, dco, nil, storeStatus); err != nil {
+				return dicomstatus.FailureUnableToProcess, contextError(ctx, err)
+			}
+		case dicomcommand.CGetResponse:
+			status = dco.GetUShort(tags.Status)
+			if status != dicomstatus.Pending {
+				pdu.setOutstanding(0, 0)
+			}
+			if dco.GetUShort(tags.CommandDataSetType) != dicomstatus.CommandDataSetTypeNull {
+				if _, err := pdu.NextPDU(); err != nil {
+					return dicomstatus.FailureUnableToProcess, contextError(ctx, err)
+				}
+			}
+			if d.onCGetResult != nil {
+				d.onCGetResult(dco)
+			}
+		default:
+			return dicomstatus.FailureUnableToProcess, fmt.Errorf("serviceuser::GetSCU, unexpected command %v", command)
+		}
+	}
+	return status, ctx.Err()
+}
+
+// RequestStorageCommitment - Asks the peer to commit the storage of the instances (N-ACTION).
+// The N-EVENT-REPORT is awaited on the same association for the report timeout, see SetStorageCommitmentReportTimeout.
+// When it does not arrive the result is Pending and the report is received later by an SCP, see OnStorageCommitmentReport.
+func (d *scu) RequestStorageCommitment(refs []*SOPReference, timeout int) (*StorageCommitmentResult, error) {
+	// Not pooled, the report may come until the peer releases the association
+	pdu := newPDUService()
+	d.proposeAssociation(pdu, []*sopclass.SOPClass{sopclass.StorageCommitmentPushModel}, []string{}, timeout)
+	if err := d.connect(pdu); err != nil {
+		return nil, err
+	}
+	defer pdu.Close()
+
+	result := &StorageCommitmentResult{TransactionUID: newUID(), Pending: true}
+	ddo := newStorageCommitmentRequest(result.TransactionUID, refs, pdu.GetTransferSyntax(pdu.GetPresentationContextID()))
+	if err := pdu.WriteNRQ(dicomcommand.NActionRequest, sopclass.StorageCommitmentPushModel.UID, sopclass.StorageCommitmentPushModelInstance.UID, storageCommitmentRequestActionType, ddo); err != nil {
+		return nil, err
+	}
+	if _, status, err := pdu.ReadResp(); err != nil {
+		return nil, err
+	} else if status != dicomstatus.Success {
+		return nil, fmt.Errorf("serviceuser::RequestStorageCommitment, N-ACTION failed - %d", status)
+	}
+
+	// Given to the peer for each PDU of the report
+	pdu.readTimeout = d.reportTimeout
+	dco, err := pdu.NextPDU()
+	if err != nil || dco == nil || dco.GetUShort(tags.CommandField) != dicomcommand.NEventReportRequest {
+		slog.Info("RequestStorageCommitment: report not received on this association", "TransactionUID", result.TransactionUID)
+		return result, nil
+	}
+	ddo = media.NewEmptyDCMObj()
+	if dco.GetUShort(tags.CommandDataSetType) != dicomstatus.CommandDataSetTypeNull {
+		if ddo, err = pdu.NextPDU(); err != nil {
+			return result, err
+		}
+	}
+	report, err := parseStorageCommitmentReport(ddo)
+	if err != nil {
+		pdu.WriteResp(dicomcommand.NEventReportRequest, dco, nil, dicomstatus.FailureUnableToProcess)
+		return result, err
+	}
+	return report, pdu.WriteResp(dicomcommand.NEventReportRequest, dco, nil, dicomstatus.Success)
+}
+
+// SendStorageCommitmentReport - Sends the N-EVENT-REPORT of a storage commitment on a new association
+func (d *scu) SendStorageCommitmentReport(result *StorageCommitmentResult, timeout int) error {
+	pdu := newPDUService()
+	pdu.AssocRQ.GetUserInformation().AddRoleSelect(NewRoleSelectUID(sopclass.StorageCommitmentPushModel.UID, 0, 1))
+	pdu, err := d.openAssociation(pdu, []*sopclass.SOPClass{sopclass.StorageCommitmentPushModel}, []string{}, timeout)
+	if err != nil {
+		return err
+	}
+	defer d.releaseAssociation(pdu)
+	return sendStorageCommitmentReport(pdu, result)
+}
+
+func sendStorageCommitmentReport(pdu *pduService, result *StorageCommitmentResult) error {
+	ddo, eventType := newStorageCommitmentReport(result, pdu.GetTransferSyntax(pdu.GetPresentationContextID()))
+	if err := pdu.WriteNRQ(dicomcommand.NEventReportRequest, sopclass.StorageCommitmentPushModel.UID, sopclass.StorageCommitmentPushModelInstance.UID, eventType, ddo); err != nil {
+		return err
+	}
+	_, status, err := pdu.ReadResp()
+	if err != nil {
+		return err
+	}
+	if status != dicomstatus.Success {
+		return fmt.Errorf("serviceuser::SendStorageCommitmentReport, N-EVENT-REPORT failed - %d", status)
+	}
+	return nil
+}
+
+// CreateMPPS - Creates a Modality Performed Procedure Step (N-CREATE), IN PROGRESS unless set otherwise.
+// A SOP Instance UID is generated when sopInstanceUID is empty, it is returned to update the step later
+func (d *scu) CreateMPPS(sopInstanceUID string, mpps *media.DcmObj, timeout int) (string, uint16, error) {
+	if sopInstanceUID == "" {
+		sopInstanceUID = newUID()
+	}
+	// Sent as a copy, the dataset of the caller is left as is
+	data := *mpps
+	data.Tags = slices.Clone(mpps.Tags)
+	if mpps.GetString(tags.PerformedProcedureStepStatus) == "" {
+		data.Tags = slices.DeleteFunc(data.Tags, func(tag *media.DcmTag) bool {
+			return tag.Group == tags.PerformedProcedureStepStatus.Group && tag.Element == tags.PerformedProcedureStepStatus.Element
+		})
+		data.WriteString(tags.PerformedProcedureStepStatus, MPPSInProgress)
+	}
+	status, err := d.nRequest(dicomcommand.NCreateRequest, sopInstanceUID, &data, timeout)
+	return sopInstanceUID, status, err
+}
+
+// UpdateMPPS - Updates a Modality Performed Procedure Step (N-SET), eg. to COMPLETED or DISCONTINUED
+func (d *scu) UpdateMPPS(sopInstanceUID string, mpps *media.DcmObj, timeout int) (uint16, error) {
+	return d.nRequest(dicomcommand.NSetRequest, sopInstanceUID, mpps, timeout)
+}
+
+func (d *scu) nRequest(command uint16, sopInstanceUID string, ddo *media.DcmObj, timeout int) (uint16, error) {
+	pdu := newPDUService()
+	pdu, err := d.openAssociation(pdu, []*sopclass.SOPClass{sopclass.ModalityPerformedProcedureStep}, []string{}, timeout)
+	if err != nil {
+		return dicomstatus.FailureUnableToProcess, err
+	}
+	defer d.releaseAssociation(pdu)
+	if err := pdu.WriteNRQ(command, sopclass.ModalityPerformedProcedureStep.UID, sopInstanceUID, 0, ddo); err != nil {
+		return dicomstatus.FailureUnableToProcess, err
+	}
+	_, status, err := pdu.ReadResp()
+	return status, err
 }
 
 func (d *scu) StoreSCU(FileNames []string, timeout int, transferSyntaxes ...string) error {
+	_, err := d.store(context.Background(), fileSources(FileNames), timeout, transferSyntaxes...)
+	return err
+}
+
+// StoreSCUContext - StoreSCU stopped when the context is done, the association is aborted during a C-STORE
+func (d *scu) StoreSCUContext(ctx context.Context, FileNames []string, transferSyntaxes ...string) error {
+	_, err := d.store(ctx, fileSources(FileNames), 0, transferSyntaxes...)
+	return err
+}
+
+// StoreSCUResults - StoreSCU with the result of every file, in the same order.
+// The error is the one of the association, a file failed when its result has an Error
+func (d *scu) StoreSCUResults(FileNames []string, timeout int, transferSyntaxes ...string) ([]*CStoreResult, error) {
+	return d.store(context.Background(), fileSources(FileNames), timeout, transferSyntaxes...)
+}
+
+// StoreSCUResultsContext - StoreSCUResults stopped when the context is done
+func (d *scu) StoreSCUResultsContext(ctx context.Context, FileNames []string, transferSyntaxes ...string) ([]*CStoreResult, error) {
+	return d.store(ctx, fileSources(FileNames), 0, transferSyntaxes...)
+}
+
+// StoreSCUObjects - StoreSCUResults of objects built in memory, see ObjectSource
+func (d *scu) StoreSCUObjects(objs []*media.DcmObj, timeout int, transferSyntaxes ...string) ([]*CStoreResult, error) {
+	sources := make([]*StoreSource, 0, len(objs))
+	for _, obj := range objs {
+		sources = append(sources, ObjectSource(obj))
+	}
+	return d.store(context.Background(), sources, timeout, transferSyntaxes...)
+}
+
+// StoreSCUReaders - StoreSCUResults of DICOM streams, each one read when its turn comes, see ReaderSource
+func (d *scu) StoreSCUReaders(readers []io.Reader, timeout int, transferSyntaxes ...string) ([]*CStoreResult, error) {
+	sources := make([]*StoreSource, 0, len(readers))
+	for _, r := range readers {
+		sources = append(sources, ReaderSource(r))
+	}
+	return d.store(context.Background(), sources, timeout, transferSyntaxes...)
+}
+
+// StoreSCUSourcesContext - StoreSCUResultsContext of files, objects and streams mixed on the same association
+func (d *scu) StoreSCUSourcesContext(ctx context.Context, sources []*StoreSource, transferSyntaxes ...string) ([]*CStoreResult, error) {
+	return d.store(ctx, sources, 0, transferSyntaxes...)
+}
+
+// SetRetryQueue - Files failing to be stored are queued on disk, and removed once stored, see StoreRetryQueue
+func (d *scu) SetRetryQueue(q *RetryQueue) {
+	d.retryQueue = q
+}
+
+// StoreRetryQueue - Sends again the files of the retry queue, eg. after a restart
+func (d *scu) StoreRetryQueue(timeout int, transferSyntaxes ...string) ([]*CStoreResult, error) {
+	if d.retryQueue == nil {
+		return nil, errors.New("serviceuser::StoreRetryQueue, no retry queue")
+	}
+	files := d.retryQueue.Files()
+	if len(files) == 0 {
+		return nil, nil
+	}
+	return d.store(context.Background(), fileSources(files), timeout, transferSyntaxes...)
+}
+
+func (d *scu) store(ctx context.Context, sources []*StoreSource, timeout int, transferSyntaxes ...string) (results []*CStoreResult, err error) {
+	results = make([]*CStoreResult, len(sources))
+	for index, source := range sources {
+		results[index] = &CStoreResult{File: source.file, Status: dicomstatus.FailureUnableToProcess}
+	}
+	defer func() {
+		// Streams never reached are closed, files not sent share the error that stopped StoreSCU
+		for _, source := range sources {
+			source.release()
+		}
+		for _, res := range results {
+			if res.Status == dicomstatus.FailureUnableToProcess && res.Error == nil {
+				res.Error = err
+				if res.Error == nil {
+					res.Error = errors.New("serviceuser::StoreSCU, not sent")
+				}
+			}
+		}
+		if d.retryQueue != nil {
+			if qerr := d.retryQueue.update(results); qerr != nil {
+				slog.Error("StoreSCU: retry queue", "Error", qerr.Error())
+			}
+		}
+	}()
+
 	var failed, completed, pending uint16
 	pdu := newPDUService()
+	pdu.SetContext(ctx)
 	if len(transferSyntaxes) == 0 {
 		transferSyntaxes = append(transferSyntaxes, transfersyntax.JPEGLosslessSV1.UID, transfersyntax.ImplicitVRLittleEndian.UID)
 	}
-	if err := d.openAssociation(pdu, sopclass.DcmShortSCUStorageSOPClassUIDs, transferSyntaxes, timeout); err != nil {
-		return err
+	pdu, err = d.openAssociation(pdu, sopclass.DcmShortSCUStorageSOPClassUIDs, transferSyntaxes, timeout)
+	if err != nil {
+		return results, contextError(ctx, err)
 	}
-	defer pdu.Close()
-	for index, FileName := range FileNames {
-		pending = uint16(len(FileNames) - index - 1)
-		if err := d.cstore(pdu, FileName); err != nil {
+	defer d.releaseAssociation(pdu)
+
+	// C-STORE requests waiting for their response, as many as the negotiated asynchronous operations window
+	outstanding := make(map[uint16]*CStoreResult)
+	report := func(res *CStoreResult) error {
+		if res.Error != nil {
 			failed++
-			slog.Warn("StoreSCU", "File", FileName, "Error", err.Error())
+			slog.Warn("StoreSCU", "File", res.File, "SOPInstanceUID", res.SOPInstanceUID, "Error", res.Error.Error())
 		} else {
 			completed++
 		}
+		pending = uint16(len(sources)) - completed - failed
+		if d.onCStoreInstance != nil {
+			d.onCStoreInstance(res)
+		}
 		if d.onCStoreResult != nil {
-			if err := d.onCStoreResult(pending, completed, failed); err != nil {
-				return err
+			return d.onCStoreResult(pending, completed, failed)
+		}
+		return nil
+	}
+	readResp := func() error {
+		dco, _, status, err := pdu.readResp()
+		if err != nil {
+			// Lost with the association
+			for messageID, res := range outstanding {
+				delete(outstanding, messageID)
+				res.Error = err
+				if err := report(res); err != nil {
+					return err
+				}
 			}
+			return nil
+		}
+		messageID := dco.GetUShort(tags.MessageIDBeingRespondedTo)
+		res, ok := outstanding[messageID]
+		if !ok {
+			return fmt.Errorf("serviceuser::StoreSCU, unexpected response to message %d", messageID)
+		}
+		delete(outstanding, messageID)
+		res.Status = status
+		res.ErrorComment = dco.GetString(tags.ErrorComment)
+		res.Error = getCStoreError(status, nil)
+		return report(res)
+	}
+
+	for index, source := range sources {
+		if err := ctx.Err(); err != nil {
+			slog.Info("StoreSCU", "Completed", completed, "Failed", failed, "Cancelled", err)
+			return results, err
+		}
+		for pdu.opsWindow != 0 && len(outstanding) >= int(pdu.opsWindow) {
+			if err := readResp(); err != nil {
+				return results, err
+			}
+		}
+		res := results[index]
+		writeStoreSource(pdu, source, res)
+		if res.Error != nil {
+			if err := report(res); err != nil {
+				return results, err
+			}
+			continue
+		}
+		outstanding[pdu.messageID] = res
+	}
+	for len(outstanding) > 0 {
+		if err := readResp(); err != nil {
+			return results, err
 		}
 	}
 	slog.Info("StoreSCU", "Completed", completed, "Failed", failed)
-	return nil
+	return results, ctx.Err()
 }
 
-func (d *scu) cstore(pdu *pduService, FileName string) error {
-	DDO, err := media.NewDCMObjFromFile(FileName)
+// writeStoreSource - sends the C-STORE request of an instance, its response is read later
+func writeStoreSource(pdu *pduService, source *StoreSource, res *CStoreResult) {
+	DDO, err := source.load()
 	if err != nil {
-		return err
+		res.Error = err
+		return
+	}
+	res.SOPClassUID = DDO.GetString(tags.SOPClassUID)
+	res.SOPInstanceUID = DDO.GetString(tags.SOPInstanceUID)
+	TrnSyntIN := DDO.GetTransferSyntax()
+	status, err := writeStoreRQ(pdu, DDO)
+	res.PresentationContextID = pdu.GetPresentationContextID()
+	if ts := pdu.GetTransferSyntax(res.PresentationContextID); ts != nil {
+		res.TransferSyntaxUID = ts.UID
+		res.Transcoded = TrnSyntIN != nil && ts.UID != TrnSyntIN.UID
 	}
-	if err = getCStoreError(d.writeStoreRQ(pdu, DDO)); err != nil {
+	res.Error = getCStoreError(status, err)
+}
+
+func cstoreObj(pdu *pduService, DDO *media.DcmObj) error {
+	if err := getCStoreError(writeStoreRQ(pdu, DDO)); err != nil {
 		return err
 	}
 	_, status, err := pdu.ReadResp()
@@ -167,7 +687,7 @@ func getCStoreError(status uint16, err error) error {
 	if err != nil {
 		return err
 	}
-	if status != dicomstatus.Success {
+	if status != dicomstatus.Success && !dicomstatus.IsWarning(status) {
 		return fmt.Errorf("serviceuser::StoreSCU, dimsec.CStoreReadRSP failed - %d", status)
 	}
 	return nil
@@ -181,13 +701,81 @@ func (d *scu) SetOnCMoveResult(f func(result *media.DcmObj)) {
 	d.onCMoveResult = f
 }
 
-func (d *scu) openAssociation(pdu *pduService, abstractSyntaxes []*sopclass.SOPClass, transferSyntaxes []string, timeout int) error {
+// SetOnCStoreInstance - called by StoreSCU once the C-STORE of each instance is done
+func (d *scu) SetOnCStoreInstance(f func(result *CStoreResult)) {
+	d.onCStoreInstance = f
+}
+
+// SetOnCGetResult - called for every C-GET response, result is the response command holding the sub-operation counters
+func (d *scu) SetOnCGetResult(f func(result *media.DcmObj)) {
+	d.onCGetResult = f
+}
+
+// SetOnCStoreRequest - called for every instance received through a C-GET
+func (d *scu) SetOnCStoreRequest(f func(data *media.DcmObj) uint16) {
+	d.onCStoreRequest = f
+}
+
+// contextError - once the context is done its error explains the failure
+func contextError(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+// openAssociation - an idle association of the pool proposing the same, a new one otherwise. releaseAssociation must be called once done
+func (d *scu) openAssociation(pdu *pduService, abstractSyntaxes []*sopclass.SOPClass, transferSyntaxes []string, timeout int) (*pduService, error) {
+	if d.pool == nil {
+		d.proposeAssociation(pdu, abstractSyntaxes, transferSyntaxes, timeout)
+		return pdu, d.connect(pdu)
+	}
+	// Pooled associations are checked with a C-ECHO
+	if !slices.Contains(abstractSyntaxes, sopclass.Verification) {
+		abstractSyntaxes = append(slices.Clone(abstractSyntaxes), sopclass.Verification)
+	}
+	d.proposeAssociation(pdu, abstractSyntaxes, transferSyntaxes, timeout)
+	pdu.poolKey = associationKey(d.destination, pdu)
+	if pooled := d.pool.get(pdu.ctx, pdu.poolKey, timeout); pooled != nil {
+		return pooled, nil
+	}
+	return pdu, d.connect(pdu)
+}
+
+// releaseAssociation - back to the pool when the operation completed, released otherwise
+func (d *scu) releaseAssociation(pdu *pduService) {
+	if d.pool == nil || pdu.poolKey == "" {
+		pdu.Close()
+		return
+	}
+	d.pool.put(pdu.poolKey, pdu)
+}
+
+// proposeAssociation - the A-ASSOCIATE-RQ of the operation
+func (d *scu) proposeAssociation(pdu *pduService, abstractSyntaxes []*sopclass.SOPClass, transferSyntaxes []string, timeout int) {
 	pdu.SetCallingAE(d.destination.CallingAE)
 	pdu.SetCalledAE(d.destination.CalledAE)
 	pdu.SetTimeout(timeout)
+	pdu.SetMaxPDULength(d.maxPDULength)
+	pdu.SetAsyncOperationsWindow(d.maxOpsInvoked, d.maxOpsPerformed)
+	if d.impClassUID != "" {
+		pdu.SetImplementation(d.impClassUID, d.impVersion)
+	}
 
-	Resetuniq()
 	for _, syntax := range abstractSyntaxes {
+		if role := d.role(syntax.UID); role != nil && !pdu.proposesRole(syntax.UID) {
+			pdu.AssocRQ.GetUserInformation().AddRoleSelect(role)
+		}
+		for _, ext := range d.extNegotiations {
+			if ext.GetUID() == syntax.UID {
+				pdu.AssocRQ.GetUserInformation().AddExtendedNegotiation(ext)
+			}
+		}
+		for _, ext := range d.commonExts {
+			if ext.GetUID() == syntax.UID {
+				pdu.AssocRQ.GetUserInformation().AddCommonExtendedNegotiation(ext)
+			}
+		}
 		PresContext := NewPresentationContext()
 		PresContext.SetAbstractSyntax(syntax.UID)
 		for _, ts := range transferSyntaxes {
@@ -199,33 +787,78 @@ func (d *scu) openAssociation(pdu *pduService, abstractSyntaxes []*sopclass.SOPC
 		pdu.AddPresContexts(PresContext)
 	}
 
-	return pdu.Connect(d.destination.HostName, strconv.Itoa(d.destination.Port))
+	if d.destination.IsTLS || d.destination.TLSConfig != nil {
+		config := d.destination.TLSConfig
+		if config == nil {
+			config = &tls.Config{}
+		}
+		pdu.SetTLSConfig(config)
+	}
+	if d.identity != nil {
+		pdu.AssocRQ.GetUserInformation().SetUserIdentity(d.identity)
+	}
+}
+
+// connect - opens the association proposed
+func (d *scu) connect(pdu *pduService) error {
+	if err := pdu.Connect(d.destination.HostName, strconv.Itoa(d.destination.Port)); err != nil {
+		return err
+	}
+	if d.onExtNegotiation != nil {
+		for _, ext := range pdu.AssocAC.GetUserInformation().GetExtendedNegotiations() {
+			d.onExtNegotiation(ext.GetUID(), ext.GetInfo())
+		}
+	}
+	if d.identity != nil && d.identity.IsPositiveResponseRequested() {
+		if err := d.userIdentityResponse(pdu.AssocAC.GetUserInformation().GetUserIdentityAC()); err != nil {
+			pdu.Close()
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *scu) userIdentityResponse(identity *userIdentityAC) error {
+	if identity == nil {
+		return errors.New("serviceuser::OpenAssociation, user identity not acknowledged")
+	}
+	if d.onIdentity != nil {
+		return d.onIdentity(identity.GetServerResponse())
+	}
+	return nil
 }
 
-func (d *scu) writeStoreRQ(pdu *pduService, DDO *media.DcmObj) (uint16, error) {
-	status := dicomstatus.FailureUnableToProcess
+// role - role selection proposed for the SOP class, nil for the default SCU role
+func (d *scu) role(sopClassUID string) *roleSelect {
+	for _, role := range d.roles {
+		if role.GetUID() == sopClassUID {
+			return role
+		}
+	}
+	return nil
+}
 
-	PCID := pdu.GetPresentationContextID()
+// writeStoreRQ - sends the instance on the context accepted for its SOP class, transcoded when its transfer syntax was not accepted
+func writeStoreRQ(pdu *pduService, DDO *media.DcmObj) (uint16, error) {
+	sopClassUID := DDO.GetString(tags.SOPClassUID)
+	PCID := pdu.storePresentationContextID(sopClassUID, DDO.GetTransferSyntax())
+	pdu.SetPresentationContextID(PCID)
 	if PCID == 0 {
-		return dicomstatus.FailureUnableToProcess, errors.New("serviceuser::WriteStoreRQ, PCID==0")
+		return dicomstatus.FailureUnableToProcess, fmt.Errorf("serviceuser::WriteStoreRQ, no presentation context accepted for SOP class %s", sopClassUID)
 	}
 	TrnSyntOUT := pdu.GetTransferSyntax(PCID)
-
 	if TrnSyntOUT == nil {
 		return dicomstatus.FailureUnableToProcess, errors.New("serviceuser::WriteStoreRQ, TrnSyntOut is empty")
 	}
 
-	if TrnSyntOUT.UID == DDO.GetTransferSyntax().UID {
-		if err := pdu.WriteRQ(dicomcommand.CStoreRequest, DDO); err != nil {
-			return status, err
+	// Objects built in memory are encoded with the accepted transfer syntax
+	if TrnSyntIN := DDO.GetTransferSyntax(); TrnSyntIN != nil && TrnSyntOUT.UID != TrnSyntIN.UID {
+		slog.Info("StoreSCU: Transcode.", "From", TrnSyntIN.Description, "To", TrnSyntOUT.Description)
+		if err := DDO.ChangeTransferSynx(TrnSyntOUT); err != nil {
+			return dicomstatus.FailureUnableToProcess, fmt.Errorf("serviceuser::WriteStoreRQ, transcoding from %s to %s - %w", TrnSyntIN.Description, TrnSyntOUT.Description, err)
 		}
-		return dicomstatus.Success, nil
 	}
-	slog.Info("StoreSCU: Transcode.", "From", DDO.GetTransferSyntax().Description, "To", TrnSyntOUT.Description)
-	DDO.ChangeTransferSynx(TrnSyntOUT)
-
-	err := pdu.WriteRQ(dicomcommand.CStoreRequest, DDO)
-	if err != nil {
+	if err := pdu.WriteRQ(dicomcommand.CStoreRequest, DDO); err != nil {
 		return dicomstatus.FailureUnableToProcess, err
 	}
 	return dicomstatus.Success, nil
@@ -0,0 +1,248 @@
+package network
+
+import (
+	"bufio"
+	"errors"
+	"strings"
+
+	"github.com/t2care/obd-dicom/media"
+)
+
+type sopClassExtendedNegotiation struct {
+	ItemType  byte //0x56
+	Reserved1 byte
+	Length    uint16
+	uid       string
+	Info      []byte // Service-class-application-information
+}
+
+// NewSOPClassExtendedNegotiation - SOP Class Extended Negotiation sub-item, see QueryRetrieveOptions and StorageOptions
+func NewSOPClassExtendedNegotiation(uid string, info []byte) *sopClassExtendedNegotiation {
+	return &sopClassExtendedNegotiation{
+		ItemType: 0x56,
+		uid:      uid,
+		Info:     info,
+	}
+}
+
+func (ext *sopClassExtendedNegotiation) GetUID() string {
+	return ext.uid
+}
+
+func (ext *sopClassExtendedNegotiation) GetInfo() []byte {
+	return ext.Info
+}
+
+func (ext *sopClassExtendedNegotiation) Size() uint16 {
+	ext.Length = uint16(2 + len(ext.uid) + len(ext.Info))
+	return ext.Length + 4
+}
+
+func (ext *sopClassExtendedNegotiation) Write(rw *bufio.ReadWriter) bool {
+	bd := media.NewEmptyBufData()
+
+	ext.Size()
+	bd.SetBigEndian(true)
+	bd.WriteByte(ext.ItemType)
+	bd.WriteByte(ext.Reserved1)
+	bd.WriteUint16(ext.Length)
+	bd.WriteUint16(uint16(len(ext.uid)))
+	bd.Write([]byte(ext.uid), len(ext.uid))
+	bd.Write(ext.Info, len(ext.Info))
+
+	if err := bd.Send(rw); err != nil {
+		return false
+	}
+	return true
+}
+
+func (ext *sopClassExtendedNegotiation) Read(ms *media.MemoryStream) (err error) {
+	if ext.ItemType, err = ms.GetByte(); err != nil {
+		return err
+	}
+	return ext.ReadDynamic(ms)
+}
+
+func (ext *sopClassExtendedNegotiation) ReadDynamic(ms *media.MemoryStream) (err error) {
+	if ext.Reserved1, err = ms.GetByte(); err != nil {
+		return err
+	}
+	if ext.Length, err = ms.GetUint16(); err != nil {
+		return err
+	}
+	uid, err := readField(ms)
+	if err != nil {
+		return err
+	}
+	ext.uid = strings.TrimRight(string(uid), "\x00")
+	if int(ext.Length) < 2+len(uid) {
+		return errors.New("sopClassExtendedNegotiation::ReadDynamic, invalid length")
+	}
+	ext.Info = make([]byte, int(ext.Length)-2-len(uid))
+	return ms.ReadData(ext.Info)
+}
+
+type sopClassCommonExtendedNegotiation struct {
+	ItemType           byte //0x57
+	SubItemVersion     byte
+	Length             uint16
+	uid                string
+	serviceClassUID    string
+	relatedGeneralUIDs []string
+}
+
+// NewSOPClassCommonExtendedNegotiation - SOP Class Common Extended Negotiation sub-item, A-ASSOCIATE-RQ only.
+// relatedGeneralUIDs are the general SOP classes the SOP class is a specialization of
+func NewSOPClassCommonExtendedNegotiation(uid string, serviceClassUID string, relatedGeneralUIDs ...string) *sopClassCommonExtendedNegotiation {
+	return &sopClassCommonExtendedNegotiation{
+		ItemType:           0x57,
+		uid:                uid,
+		serviceClassUID:    serviceClassUID,
+		relatedGeneralUIDs: relatedGeneralUIDs,
+	}
+}
+
+func (ext *sopClassCommonExtendedNegotiation) GetUID() string {
+	return ext.uid
+}
+
+func (ext *sopClassCommonExtendedNegotiation) GetServiceClassUID() string {
+	return ext.serviceClassUID
+}
+
+func (ext *sopClassCommonExtendedNegotiation) GetRelatedGeneralSOPClassUIDs() []string {
+	return ext.relatedGeneralUIDs
+}
+
+func (ext *sopClassCommonExtendedNegotiation) relatedLength() int {
+	length := 0
+	for _, uid := range ext.relatedGeneralUIDs {
+		length += 2 + len(uid)
+	}
+	return length
+}
+
+func (ext *sopClassCommonExtendedNegotiation) Size() uint16 {
+	ext.Length = uint16(6 + len(ext.uid) + len(ext.serviceClassUID) + ext.relatedLength())
+	return ext.Length + 4
+}
+
+func (ext *sopClassCommonExtendedNegotiation) Write(rw *bufio.ReadWriter) bool {
+	bd := media.NewEmptyBufData()
+
+	ext.Size()
+	bd.SetBigEndian(true)
+	bd.WriteByte(ext.ItemType)
+	bd.WriteByte(ext.SubItemVersion)
+	bd.WriteUint16(ext.Length)
+	bd.WriteUint16(uint16(len(ext.uid)))
+	bd.Write([]byte(ext.uid), len(ext.uid))
+	bd.WriteUint16(uint16(len(ext.serviceClassUID)))
+	bd.Write([]byte(ext.serviceClassUID), len(ext.serviceClassUID))
+	bd.WriteUint16(uint16(ext.relatedLength()))
+	for _, uid := range ext.relatedGeneralUIDs {
+		bd.WriteUint16(uint16(len(uid)))
+		bd.Write([]byte(uid), len(uid))
+	}
+
+	if err := bd.Send(rw); err != nil {
+		return false
+	}
+	return true
+}
+
+func (ext *sopClassCommonExtendedNegotiation) Read(ms *media.MemoryStream) (err error) {
+	if ext.ItemType, err = ms.GetByte(); err != nil {
+		return err
+	}
+	return ext.ReadDynamic(ms)
+}
+
+func (ext *sopClassCommonExtendedNegotiation) ReadDynamic(ms *media.MemoryStream) (err error) {
+	if ext.SubItemVersion, err = ms.GetByte(); err != nil {
+		return err
+	}
+	if ext.Length, err = ms.GetUint16(); err != nil {
+		return err
+	}
+	uid, err := readField(ms)
+	if err != nil {
+		return err
+	}
+	ext.uid = strings.TrimRight(string(uid), "\x00")
+	if uid, err = readField(ms); err != nil {
+		return err
+	}
+	ext.serviceClassUID = strings.TrimRight(string(uid), "\x00")
+	related, err := ms.GetUint16()
+	if err != nil {
+		return err
+	}
+	ext.relatedGeneralUIDs = nil
+	for count := int(related); count > 0; count -= 2 + len(uid) {
+		if uid, err = readField(ms); err != nil {
+			return err
+		}
+		ext.relatedGeneralUIDs = append(ext.relatedGeneralUIDs, strings.TrimRight(string(uid), "\x00"))
+	}
+	return nil
+}
+
+// QueryRetrieveOptions - Service-class-application-information of the Query/Retrieve FIND, MOVE and GET SOP classes
+type QueryRetrieveOptions struct {
+	RelationalQueries  bool
+	DateTimeMatching   bool // Combined date and time range matching
+	FuzzyMatching      bool // Fuzzy semantic matching of person names
+	TimezoneAdjustment bool // Timezone query adjustment
+}
+
+// Bytes - Service-class-application-information of the options
+func (o QueryRetrieveOptions) Bytes() []byte {
+	return []byte{roleValue(o.RelationalQueries), roleValue(o.DateTimeMatching), roleValue(o.FuzzyMatching), roleValue(o.TimezoneAdjustment)}
+}
+
+// ParseQueryRetrieveOptions - Options of a Service-class-application-information, the missing ones are not supported
+func ParseQueryRetrieveOptions(info []byte) QueryRetrieveOptions {
+	flag := func(index int) bool {
+		return len(info) > index && info[index] == 1
+	}
+	return QueryRetrieveOptions{
+		RelationalQueries:  flag(0),
+		DateTimeMatching:   flag(1),
+		FuzzyMatching:      flag(2),
+		TimezoneAdjustment: flag(3),
+	}
+}
+
+// Storage SCP levels of support and of digital signature support
+const (
+	StorageLevel0             byte = 0
+	StorageLevel1             byte = 1
+	StorageLevel2             byte = 2
+	StorageLevelNotApplicable byte = 3
+)
+
+// StorageOptions - Service-class-application-information answered by a Storage SCP
+type StorageOptions struct {
+	Level            byte
+	DigitalSignature byte
+	ElementCoercion  bool
+}
+
+// Bytes - Service-class-application-information of the options
+func (o StorageOptions) Bytes() []byte {
+	return []byte{o.Level, 0, o.DigitalSignature, 0, roleValue(o.ElementCoercion), 0}
+}
+
+// ParseStorageOptions - Options of a Service-class-application-information
+func ParseStorageOptions(info []byte) StorageOptions {
+	o := StorageOptions{Level: StorageLevelNotApplicable, DigitalSignature: StorageLevelNotApplicable}
+	if len(info) > 0 {
+		o.Level = info[0]
+	}
+	if len(info) > 2 {
+		o.DigitalSignature = info[2]
+	}
+	o.ElementCoercion = len(info) > 4 && info[4] == 1
+	return o
+}
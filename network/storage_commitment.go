@@ -0,0 +1,122 @@
+package network
+
+import (
+	"github.com/t2care/obd-dicom/dictionary/tags"
+	"github.com/t2care/obd-dicom/dictionary/transfersyntax"
+	"github.com/t2care/obd-dicom/media"
+)
+
+// Storage Commitment Push Model N-ACTION and N-EVENT-REPORT type IDs
+const (
+	storageCommitmentRequestActionType uint16 = 1
+	storageCommitmentSuccessEventType  uint16 = 1
+	storageCommitmentFailureEventType  uint16 = 2
+)
+
+// SOPReference - a SOP instance referenced by a storage commitment transaction
+type SOPReference struct {
+	SOPClassUID    string
+	SOPInstanceUID string
+	FailureReason  uint16 // 0 when the instance is committed
+}
+
+// StorageCommitmentResult - outcome of a storage commitment transaction
+type StorageCommitmentResult struct {
+	TransactionUID string
+	Committed      []*SOPReference
+	Failed         []*SOPReference
+	Pending        bool // The report was not received on the requesting association
+}
+
+// newStorageCommitmentRequest - N-ACTION data set with the Referenced SOP Sequence
+func newStorageCommitmentRequest(transactionUID string, refs []*SOPReference, ts *transfersyntax.TransferSyntax) *media.DcmObj {
+	ddo := media.NewEmptyDCMObj()
+	if ts != nil {
+		ddo.SetTransferSyntax(ts)
+	}
+	ddo.WriteString(tags.TransactionUID, transactionUID)
+	ddo.WriteSeq(tags.ReferencedSOPSequence, referenceItems(refs, false))
+	return ddo
+}
+
+func parseStorageCommitmentRequest(ddo *media.DcmObj) (string, []*SOPReference, error) {
+	refs, err := parseReferences(ddo, tags.ReferencedSOPSequence)
+	if err != nil {
+		return "", nil, err
+	}
+	return ddo.GetString(tags.TransactionUID), refs, nil
+}
+
+// newStorageCommitmentReport - N-EVENT-REPORT data set with the committed and failed instances
+func newStorageCommitmentReport(result *StorageCommitmentResult, ts *transfersyntax.TransferSyntax) (*media.DcmObj, uint16) {
+	ddo := media.NewEmptyDCMObj()
+	if ts != nil {
+		ddo.SetTransferSyntax(ts)
+	}
+	ddo.WriteString(tags.TransactionUID, result.TransactionUID)
+	if len(result.Failed) > 0 {
+		ddo.WriteSeq(tags.FailedSOPSequence, referenceItems(result.Failed, true))
+	}
+	if len(result.Committed) > 0 {
+		ddo.WriteSeq(tags.ReferencedSOPSequence, referenceItems(result.Committed, false))
+	}
+	if len(result.Failed) > 0 {
+		return ddo, storageCommitmentFailureEventType
+	}
+	return ddo, storageCommitmentSuccessEventType
+}
+
+func parseStorageCommitmentReport(ddo *media.DcmObj) (*StorageCommitmentResult, error) {
+	result := &StorageCommitmentResult{TransactionUID: ddo.GetString(tags.TransactionUID)}
+	var err error
+	if result.Committed, err = parseReferences(ddo, tags.ReferencedSOPSequence); err != nil {
+		return nil, err
+	}
+	if result.Failed, err = parseReferences(ddo, tags.FailedSOPSequence); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// splitReferences - splits the references checked by the SCP into committed and failed
+func splitReferences(transactionUID string, refs []*SOPReference) *StorageCommitmentResult {
+	result := &StorageCommitmentResult{TransactionUID: transactionUID}
+	for _, ref := range refs {
+		if ref.FailureReason != 0 {
+			result.Failed = append(result.Failed, ref)
+		} else {
+			result.Committed = append(result.Committed, ref)
+		}
+	}
+	return result
+}
+
+func referenceItems(refs []*SOPReference, withReason bool) []*media.DcmObj {
+	items := make([]*media.DcmObj, 0, len(refs))
+	for _, ref := range refs {
+		item := media.NewEmptyDCMObj()
+		item.WriteString(tags.ReferencedSOPClassUID, ref.SOPClassUID)
+		item.WriteString(tags.ReferencedSOPInstanceUID, ref.SOPInstanceUID)
+		if withReason {
+			item.WriteUint16(tags.FailureReason, ref.FailureReason)
+		}
+		items = append(items, item)
+	}
+	return items
+}
+
+func parseReferences(ddo *media.DcmObj, tag *tags.Tag) ([]*SOPReference, error) {
+	items, err := ddo.GetSeq(tag)
+	if err != nil {
+		return nil, err
+	}
+	refs := make([]*SOPReference, 0, len(items))
+	for _, item := range items {
+		refs = append(refs, &SOPReference{
+			SOPClassUID:    item.GetString(tags.ReferencedSOPClassUID),
+			SOPInstanceUID: item.GetString(tags.ReferencedSOPInstanceUID),
+			FailureReason:  item.GetUShort(tags.FailureReason),
+		})
+	}
+	return refs, nil
+}
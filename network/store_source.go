@@ -0,0 +1,70 @@
+package network
+
+import (
+	"errors"
+	"io"
+	"sync"
+
+	"github.com/t2care/obd-dicom/media"
+)
+
+// StoreSource - an instance sent by StoreSCU, loaded only when its turn comes
+type StoreSource struct {
+	file  string
+	load  func() (*media.DcmObj, error)
+	close func() // Releases the source when StoreSCU stops before loading it, nil when there is nothing to release
+}
+
+// FileSource - a DICOM file, the File of its CStoreResult
+func FileSource(fileName string) *StoreSource {
+	return &StoreSource{
+		file: fileName,
+		load: func() (*media.DcmObj, error) { return media.NewDCMObjFromFile(fileName) },
+	}
+}
+
+// ObjectSource - an object built in memory, eg. a SR or an encapsulated PDF. The object itself is sent, not a copy:
+// its transfer syntax is set to the accepted one when it has none, and it is transcoded in place otherwise
+func ObjectSource(obj *media.DcmObj) *StoreSource {
+	return &StoreSource{
+		load: func() (*media.DcmObj, error) {
+			if obj == nil {
+				return nil, errors.New("serviceuser::StoreSCU, nil object")
+			}
+			return obj, nil
+		},
+	}
+}
+
+// ReaderSource - a DICOM stream read when its turn comes, eg. an HTTP body. Closed after reading when it is an io.Closer,
+// or when StoreSCU returns before its turn. The whole instance is held in memory, see media.NewDCMObjFromReader
+func ReaderSource(r io.Reader) *StoreSource {
+	var once sync.Once
+	closeReader := func() {
+		if closer, ok := r.(io.Closer); ok {
+			once.Do(func() { closer.Close() })
+		}
+	}
+	return &StoreSource{
+		load: func() (*media.DcmObj, error) {
+			defer closeReader()
+			return media.NewDCMObjFromReader(r)
+		},
+		close: closeReader,
+	}
+}
+
+// release - closes the source, a no-op once loaded
+func (source *StoreSource) release() {
+	if source.close != nil {
+		source.close()
+	}
+}
+
+func fileSources(fileNames []string) []*StoreSource {
+	sources := make([]*StoreSource, 0, len(fileNames))
+	for _, fileName := range fileNames {
+		sources = append(sources, FileSource(fileName))
+	}
+	return sources
+}
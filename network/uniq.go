@@ -1,44 +1,71 @@
 package network
 
+import (
+	"crypto/rand"
+	"math/big"
+	"sync"
+)
+
 var (
+	uniqMu sync.Mutex
 	uniqid = 1
 )
 
+// newUID - creates a UID derived from a random UUID (PS3.5 B.2), for transactions and SOP instances.
+// Unique whatever the number of associations creating them at once
+func newUID() string {
+	uuid := make([]byte, 16)
+	rand.Read(uuid)
+	// Version 4, variant 1 (RFC 4122)
+	uuid[6] = uuid[6]&0x0F | 0x40
+	uuid[8] = uuid[8]&0x3F | 0x80
+	return "2.25." + new(big.Int).SetBytes(uuid).String()
+}
+
 // Resetuniq - Resetuniq
+//
+// Deprecated: message and presentation context IDs are kept per association, the global counter is no longer used
 func Resetuniq() {
+	uniqMu.Lock()
+	defer uniqMu.Unlock()
 	uniqid = 1
 }
 
 // Uniq8 - Uniq8
+//
+// Deprecated: message and presentation context IDs are kept per association, the global counter is no longer used
 func Uniq8() byte {
-	uniqid++
-	return byte(uniqid & 0xff)
+	return byte(nextUniq(false) & 0xff)
 }
 
 // Uniq16 - Uniq16
+//
+// Deprecated: message and presentation context IDs are kept per association, the global counter is no longer used
 func Uniq16() uint16 {
-	uniqid++
-	return uint16(uniqid & 0xffff)
+	return uint16(nextUniq(false) & 0xffff)
 }
 
 // Uniq8odd - Uniq8odd
+//
+// Deprecated: message and presentation context IDs are kept per association, the global counter is no longer used
 func Uniq8odd() byte {
-	if uniqid&0x01 == 1 {
-		uniqid++
-		return byte(uniqid & 0xff)
-	}
-	uniqid++
-	uniqid++
-	return byte(uniqid & 0xff)
+	return byte(nextUniq(true) & 0xff)
 }
 
 // Uniq16odd - Uniq16odd
+//
+// Deprecated: message and presentation context IDs are kept per association, the global counter is no longer used
 func Uniq16odd() uint16 {
-	if uniqid&0x01 == 1 {
+	return uint16(nextUniq(true) & 0xffff)
+}
+
+// nextUniq - the next value of the global counter, the next even one when even, as the *odd variants always did
+func nextUniq(even bool) int {
+	uniqMu.Lock()
+	defer uniqMu.Unlock()
+	uniqid++
+	if even && uniqid&0x01 == 1 {
 		uniqid++
-		return uint16(uniqid & 0xffff)
 	}
-	uniqid++
-	uniqid++
-	return uint16(uniqid & 0xffff)
+	return uniqid
 }
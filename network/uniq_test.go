@@ -0,0 +1,33 @@
+package network
+
+import (
+	"regexp"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewUID(t *testing.T) {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	uids := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				uid := newUID()
+				mu.Lock()
+				uids[uid] = true
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Len(t, uids, 10000, "UIDs created at once should be unique")
+	for uid := range uids {
+		assert.Regexp(t, regexp.MustCompile(`^2\.25\.(0|[1-9][0-9]*)$`), uid)
+		assert.LessOrEqual(t, len(uid), 64)
+	}
+}
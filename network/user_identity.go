@@ -0,0 +1,211 @@
+package network
+
+import (
+	"bufio"
+
+	"github.com/t2care/obd-dicom/media"
+)
+
+// User Identity Types of the User Identity sub-item
+const (
+	UserIdentityUsername         byte = 1
+	UserIdentityUsernamePasscode byte = 2
+	UserIdentityKerberos         byte = 3
+	UserIdentitySAML             byte = 4
+	UserIdentityJWT              byte = 5
+)
+
+type userIdentity struct {
+	ItemType                  byte //0x58
+	Reserved1                 byte
+	Length                    uint16
+	UserIdentityType          byte
+	PositiveResponseRequested byte
+	PrimaryField              []byte
+	SecondaryField            []byte // Passcode, only with UserIdentityUsernamePasscode
+}
+
+// NewUserIdentity - User Identity sub-item of an A-ASSOCIATE-RQ, secondary is only sent with UserIdentityUsernamePasscode
+func NewUserIdentity(identityType byte, primary []byte, secondary []byte) *userIdentity {
+	if identityType != UserIdentityUsernamePasscode {
+		secondary = nil
+	}
+	return &userIdentity{
+		ItemType:         0x58,
+		UserIdentityType: identityType,
+		PrimaryField:     primary,
+		SecondaryField:   secondary,
+	}
+}
+
+// NewUsernameIdentity - Username, with its passcode when not empty
+func NewUsernameIdentity(username string, passcode string) *userIdentity {
+	if passcode == "" {
+		return NewUserIdentity(UserIdentityUsername, []byte(username), nil)
+	}
+	return NewUserIdentity(UserIdentityUsernamePasscode, []byte(username), []byte(passcode))
+}
+
+// NewJWTIdentity - JSON Web Token
+func NewJWTIdentity(token string) *userIdentity {
+	return NewUserIdentity(UserIdentityJWT, []byte(token), nil)
+}
+
+func (identity *userIdentity) GetUserIdentityType() byte {
+	return identity.UserIdentityType
+}
+
+func (identity *userIdentity) GetPrimaryField() []byte {
+	return identity.PrimaryField
+}
+
+func (identity *userIdentity) GetSecondaryField() []byte {
+	return identity.SecondaryField
+}
+
+// GetUsername - Username of UserIdentityUsername and UserIdentityUsernamePasscode
+func (identity *userIdentity) GetUsername() string {
+	if identity.UserIdentityType != UserIdentityUsername && identity.UserIdentityType != UserIdentityUsernamePasscode {
+		return ""
+	}
+	return string(identity.PrimaryField)
+}
+
+// GetPasscode - Passcode of UserIdentityUsernamePasscode
+func (identity *userIdentity) GetPasscode() string {
+	return string(identity.SecondaryField)
+}
+
+// SetPositiveResponseRequested - The acceptor answers with a server response, eg. a Kerberos server ticket or a SAML response
+func (identity *userIdentity) SetPositiveResponseRequested(requested bool) {
+	identity.PositiveResponseRequested = roleValue(requested)
+}
+
+func (identity *userIdentity) IsPositiveResponseRequested() bool {
+	return identity.PositiveResponseRequested == 1
+}
+
+func (identity *userIdentity) Size() uint16 {
+	identity.Length = uint16(6 + len(identity.PrimaryField) + len(identity.SecondaryField))
+	return identity.Length + 4
+}
+
+func (identity *userIdentity) Write(rw *bufio.ReadWriter) bool {
+	bd := media.NewEmptyBufData()
+
+	identity.Size()
+	bd.SetBigEndian(true)
+	bd.WriteByte(identity.ItemType)
+	bd.WriteByte(identity.Reserved1)
+	bd.WriteUint16(identity.Length)
+	bd.WriteByte(identity.UserIdentityType)
+	bd.WriteByte(identity.PositiveResponseRequested)
+	bd.WriteUint16(uint16(len(identity.PrimaryField)))
+	bd.Write(identity.PrimaryField, len(identity.PrimaryField))
+	bd.WriteUint16(uint16(len(identity.SecondaryField)))
+	bd.Write(identity.SecondaryField, len(identity.SecondaryField))
+
+	if err := bd.Send(rw); err != nil {
+		return false
+	}
+	return true
+}
+
+func (identity *userIdentity) Read(ms *media.MemoryStream) (err error) {
+	if identity.ItemType, err = ms.GetByte(); err != nil {
+		return err
+	}
+	return identity.ReadDynamic(ms)
+}
+
+func (identity *userIdentity) ReadDynamic(ms *media.MemoryStream) (err error) {
+	if identity.Reserved1, err = ms.GetByte(); err != nil {
+		return err
+	}
+	if identity.Length, err = ms.GetUint16(); err != nil {
+		return err
+	}
+	if identity.UserIdentityType, err = ms.GetByte(); err != nil {
+		return err
+	}
+	if identity.PositiveResponseRequested, err = ms.GetByte(); err != nil {
+		return err
+	}
+	if identity.PrimaryField, err = readField(ms); err != nil {
+		return err
+	}
+	identity.SecondaryField, err = readField(ms)
+	return
+}
+
+type userIdentityAC struct {
+	ItemType       byte //0x59
+	Reserved1      byte
+	Length         uint16
+	ServerResponse []byte
+}
+
+// NewUserIdentityAC - User Identity sub-item of an A-ASSOCIATE-AC, empty when the identity type has no server response
+func NewUserIdentityAC(serverResponse []byte) *userIdentityAC {
+	return &userIdentityAC{
+		ItemType:       0x59,
+		ServerResponse: serverResponse,
+	}
+}
+
+func (identity *userIdentityAC) GetServerResponse() []byte {
+	return identity.ServerResponse
+}
+
+func (identity *userIdentityAC) Size() uint16 {
+	identity.Length = uint16(2 + len(identity.ServerResponse))
+	return identity.Length + 4
+}
+
+func (identity *userIdentityAC) Write(rw *bufio.ReadWriter) bool {
+	bd := media.NewEmptyBufData()
+
+	identity.Size()
+	bd.SetBigEndian(true)
+	bd.WriteByte(identity.ItemType)
+	bd.WriteByte(identity.Reserved1)
+	bd.WriteUint16(identity.Length)
+	bd.WriteUint16(uint16(len(identity.ServerResponse)))
+	bd.Write(identity.ServerResponse, len(identity.ServerResponse))
+
+	if err := bd.Send(rw); err != nil {
+		return false
+	}
+	return true
+}
+
+func (identity *userIdentityAC) Read(ms *media.MemoryStream) (err error) {
+	if identity.ItemType, err = ms.GetByte(); err != nil {
+		return err
+	}
+	return identity.ReadDynamic(ms)
+}
+
+func (identity *userIdentityAC) ReadDynamic(ms *media.MemoryStream) (err error) {
+	if identity.Reserved1, err = ms.GetByte(); err != nil {
+		return err
+	}
+	if identity.Length, err = ms.GetUint16(); err != nil {
+		return err
+	}
+	identity.ServerResponse, err = readField(ms)
+	return
+}
+
+// readField - field preceded by its 2 bytes length
+func readField(ms *media.MemoryStream) ([]byte, error) {
+	length, err := ms.GetUint16()
+	if err != nil {
+		return nil, err
+	}
+	field := make([]byte, length)
+	if err := ms.ReadData(field); err != nil {
+		return nil, err
+	}
+	return field, nil
+}
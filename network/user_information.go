@@ -3,21 +3,26 @@ package network
 import (
 	"bufio"
 	"errors"
-	"strconv"
+	"log/slog"
 
 	"github.com/t2care/obd-dicom/media"
 )
 
 type userInformation struct {
-	ItemType        byte //0x50
-	Reserved1       byte
-	Length          uint16
-	UserInfoBaggage uint32
-	MaxSubLength    *maximumSubLength
-	AsyncOpWindow   *asyncOperationWindow
-	SCPSCURole      *roleSelect
-	ImpClass        *uidItem
-	ImpVersion      *uidItem
+	ItemType              byte //0x50
+	Reserved1             byte
+	Length                uint16
+	UserInfoBaggage       uint32
+	MaxSubLength          *maximumSubLength
+	AsyncOpWindow         *asyncOperationWindow
+	SCPSCURole            *roleSelect
+	RoleSelects           []*roleSelect
+	ImpClass              *uidItem
+	ImpVersion            *uidItem
+	ExtNegotiations       []*sopClassExtendedNegotiation
+	CommonExtNegotiations []*sopClassCommonExtendedNegotiation // A-ASSOCIATE-RQ only
+	UserIdentity          *userIdentity                        // A-ASSOCIATE-RQ only, nil when not sent
+	UserIdentityAC        *userIdentityAC                      // A-ASSOCIATE-AC only, nil when not sent
 }
 
 // NewUserInformation - NewUserInformation
@@ -56,10 +61,78 @@ func (ui *userInformation) SetMaxSubLength(length *maximumSubLength) {
 	ui.MaxSubLength = length
 }
 
+func (ui *userInformation) GetRoleSelects() []*roleSelect {
+	return ui.RoleSelects
+}
+
+func (ui *userInformation) AddRoleSelect(role *roleSelect) {
+	ui.RoleSelects = append(ui.RoleSelects, role)
+}
+
+func (ui *userInformation) GetExtendedNegotiations() []*sopClassExtendedNegotiation {
+	return ui.ExtNegotiations
+}
+
+func (ui *userInformation) AddExtendedNegotiation(ext *sopClassExtendedNegotiation) {
+	ui.ExtNegotiations = append(ui.ExtNegotiations, ext)
+}
+
+// GetExtendedNegotiation - Service-class-application-information of the SOP class, nil when not negotiated
+func (ui *userInformation) GetExtendedNegotiation(sopClassUID string) []byte {
+	for _, ext := range ui.ExtNegotiations {
+		if ext.GetUID() == sopClassUID {
+			return ext.GetInfo()
+		}
+	}
+	return nil
+}
+
+func (ui *userInformation) GetCommonExtendedNegotiations() []*sopClassCommonExtendedNegotiation {
+	return ui.CommonExtNegotiations
+}
+
+func (ui *userInformation) AddCommonExtendedNegotiation(ext *sopClassCommonExtendedNegotiation) {
+	ui.CommonExtNegotiations = append(ui.CommonExtNegotiations, ext)
+}
+
+func (ui *userInformation) GetUserIdentity() *userIdentity {
+	return ui.UserIdentity
+}
+
+func (ui *userInformation) SetUserIdentity(identity *userIdentity) {
+	ui.UserIdentity = identity
+}
+
+func (ui *userInformation) GetUserIdentityAC() *userIdentityAC {
+	return ui.UserIdentityAC
+}
+
+func (ui *userInformation) SetUserIdentityAC(identity *userIdentityAC) {
+	ui.UserIdentityAC = identity
+}
+
 func (ui *userInformation) Size() uint16 {
 	ui.Length = ui.MaxSubLength.Size()
 	ui.Length += ui.ImpClass.GetSize()
+	if ui.AsyncOpWindow.IsSet() {
+		ui.Length += ui.AsyncOpWindow.Size()
+	}
 	ui.Length += ui.ImpVersion.GetSize()
+	for _, role := range ui.RoleSelects {
+		ui.Length += role.Size()
+	}
+	for _, ext := range ui.ExtNegotiations {
+		ui.Length += ext.Size()
+	}
+	for _, ext := range ui.CommonExtNegotiations {
+		ui.Length += ext.Size()
+	}
+	if ui.UserIdentity != nil {
+		ui.Length += ui.UserIdentity.Size()
+	}
+	if ui.UserIdentityAC != nil {
+		ui.Length += ui.UserIdentityAC.Size()
+	}
 	return ui.Length + 4
 }
 
@@ -100,7 +173,26 @@ func (ui *userInformation) Write(rw *bufio.ReadWriter) (err error) {
 
 	ui.MaxSubLength.Write(rw)
 	ui.ImpClass.Write(rw)
+	if ui.AsyncOpWindow.IsSet() {
+		ui.AsyncOpWindow.Write(rw)
+	}
+	// Sub-items in ascending item type, the role selections (0x54) before the implementation version name (0x55)
+	for _, role := range ui.RoleSelects {
+		role.Write(rw)
+	}
 	ui.ImpVersion.Write(rw)
+	for _, ext := range ui.ExtNegotiations {
+		ext.Write(rw)
+	}
+	for _, ext := range ui.CommonExtNegotiations {
+		ext.Write(rw)
+	}
+	if ui.UserIdentity != nil {
+		ui.UserIdentity.Write(rw)
+	}
+	if ui.UserIdentityAC != nil {
+		ui.UserIdentityAC.Write(rw)
+	}
 
 	return
 }
@@ -138,16 +230,55 @@ func (ui *userInformation) ReadDynamic(ms *media.MemoryStream) (err error) {
 			ui.AsyncOpWindow.ReadDynamic(ms)
 			Count = Count - int(ui.AsyncOpWindow.Size())
 		case 0x54:
-			ui.SCPSCURole.ReadDynamic(ms)
-			Count = Count - int(ui.SCPSCURole.Size())
-			ui.UserInfoBaggage += uint32(ui.SCPSCURole.Size())
+			role := NewRoleSelect()
+			role.ReadDynamic(ms)
+			Count = Count - int(role.Size())
+			ui.UserInfoBaggage += uint32(role.Size())
+			ui.SCPSCURole = role
+			ui.RoleSelects = append(ui.RoleSelects, role)
 		case 0x55:
 			ui.ImpVersion.ReadDynamic(ms)
 			Count = Count - int(ui.ImpVersion.GetSize())
+		case 0x56:
+			ext := &sopClassExtendedNegotiation{ItemType: TempByte}
+			if err := ext.ReadDynamic(ms); err != nil {
+				return err
+			}
+			Count = Count - int(ext.Size())
+			ui.ExtNegotiations = append(ui.ExtNegotiations, ext)
+		case 0x57:
+			ext := &sopClassCommonExtendedNegotiation{ItemType: TempByte}
+			if err := ext.ReadDynamic(ms); err != nil {
+				return err
+			}
+			Count = Count - int(ext.Length) - 4
+			ui.CommonExtNegotiations = append(ui.CommonExtNegotiations, ext)
+		case 0x58:
+			ui.UserIdentity = &userIdentity{ItemType: TempByte}
+			if err := ui.UserIdentity.ReadDynamic(ms); err != nil {
+				return err
+			}
+			Count = Count - int(ui.UserIdentity.Length) - 4
+		case 0x59:
+			ui.UserIdentityAC = &userIdentityAC{ItemType: TempByte}
+			if err := ui.UserIdentityAC.ReadDynamic(ms); err != nil {
+				return err
+			}
+			Count = Count - int(ui.UserIdentityAC.Length) - 4
 		default:
-			ui.UserInfoBaggage = uint32(Count)
-			Count = -1
-			return errors.New("user::ReadDynamic, unknown TempByte: " + strconv.Itoa(int(TempByte)))
+			// Sub-items not supported are ignored
+			if _, err := ms.GetByte(); err != nil {
+				return err
+			}
+			length, err := ms.GetUint16()
+			if err != nil {
+				return err
+			}
+			if err := ms.ReadData(make([]byte, length)); err != nil {
+				return err
+			}
+			slog.Info("user::ReadDynamic, unknown sub-item ignored", "ItemType", TempByte, "Length", length)
+			Count = Count - int(length) - 4
 		}
 	}
 
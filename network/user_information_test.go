@@ -1,8 +1,14 @@
 package network
 
 import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
 	"reflect"
 	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/t2care/obd-dicom/media"
 )
 
 func TestNewUserInformation(t *testing.T) {
@@ -30,3 +36,52 @@ func TestNewUserInformation(t *testing.T) {
 		})
 	}
 }
+
+func TestUserInformationExtendedNegotiation(t *testing.T) {
+	ui := NewUserInformation()
+	ui.SetImpClassUID("1.2.3")
+	ui.SetImpVersionName("TEST")
+	ui.AddExtendedNegotiation(NewSOPClassExtendedNegotiation("1.2.840.10008.5.1.4.1.2.2.1", QueryRetrieveOptions{FuzzyMatching: true}.Bytes()))
+	ui.AddCommonExtendedNegotiation(NewSOPClassCommonExtendedNegotiation("1.2.840.10008.5.1.4.1.1.88.22", "1.2.840.10008.4.2", "1.2.840.10008.5.1.4.1.1.88.11"))
+	var buf bytes.Buffer
+	rw := bufio.NewReadWriter(bufio.NewReader(&buf), bufio.NewWriter(&buf))
+	assert.NoError(t, ui.Write(rw))
+	assert.NoError(t, rw.Flush())
+	data := buf.Bytes()
+	// Unknown sub-item appended, it should be ignored
+	data = append(data, 0x5A, 0x00, 0x00, 0x02, 0x01, 0x02)
+	binary.BigEndian.PutUint16(data[2:], binary.BigEndian.Uint16(data[2:])+6)
+
+	read := NewUserInformation()
+	assert.NoError(t, read.Read(media.NewMemoryStreamFromBytes(data)))
+	assert.Equal(t, "1.2.3", read.GetImpClass().GetUID())
+	assert.Equal(t, QueryRetrieveOptions{FuzzyMatching: true}, ParseQueryRetrieveOptions(read.GetExtendedNegotiation("1.2.840.10008.5.1.4.1.2.2.1")))
+	if assert.Len(t, read.GetCommonExtendedNegotiations(), 1) {
+		ext := read.GetCommonExtendedNegotiations()[0]
+		assert.Equal(t, "1.2.840.10008.5.1.4.1.1.88.22", ext.GetUID())
+		assert.Equal(t, "1.2.840.10008.4.2", ext.GetServiceClassUID())
+		assert.Equal(t, []string{"1.2.840.10008.5.1.4.1.1.88.11"}, ext.GetRelatedGeneralSOPClassUIDs())
+	}
+}
+
+func TestUserInformationSubItemOrder(t *testing.T) {
+	ui := NewUserInformation()
+	ui.SetImpClassUID("1.2.3")
+	ui.SetImpVersionName("TEST")
+	ui.GetAsyncOperationWindow().SetMaxNumberOperations(2, 1)
+	ui.AddRoleSelect(NewRoleSelectUID("1.2.840.10008.5.1.4.1.1.2", 1, 1))
+	ui.AddExtendedNegotiation(NewSOPClassExtendedNegotiation("1.2.840.10008.5.1.4.1.2.2.1", QueryRetrieveOptions{FuzzyMatching: true}.Bytes()))
+	var buf bytes.Buffer
+	rw := bufio.NewReadWriter(bufio.NewReader(&buf), bufio.NewWriter(&buf))
+	assert.NoError(t, ui.Write(rw))
+	assert.NoError(t, rw.Flush())
+	data := buf.Bytes()
+	assert.Equal(t, int(binary.BigEndian.Uint16(data[2:])), len(data)-4)
+
+	// PS3.8 Annex D, the sub-items follow in ascending item type
+	var itemTypes []byte
+	for i := 4; i+4 <= len(data); i += 4 + int(binary.BigEndian.Uint16(data[i+2:])) {
+		itemTypes = append(itemTypes, data[i])
+	}
+	assert.Equal(t, []byte{0x51, 0x52, 0x53, 0x54, 0x55, 0x56}, itemTypes)
+}
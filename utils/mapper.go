@@ -10,8 +10,11 @@ import (
 	"github.com/t2care/obd-dicom/media"
 )
 
-var key string
-var struct2Dicom bool
+// mapper - keyword of the struct tags and mapping direction of one mapping
+type mapper struct {
+	key          string
+	struct2Dicom bool
+}
 
 // Map dicom object to struct. keyword = dicom by defaut
 func MapDicomDataToStruct(dicomDataset *media.DcmObj, targetStruct any, keyword ...string) (err error) {
@@ -24,12 +27,11 @@ func mapping(dicomDataset *media.DcmObj, targetStruct any, toDicom bool, keyword
 	if t != reflect.Ptr {
 		return fmt.Errorf("targerStruct must be a pointer")
 	}
-	key = "dicom"
+	m := &mapper{key: "dicom", struct2Dicom: toDicom}
 	if len(keyword) > 0 {
-		key = keyword[0]
+		m.key = keyword[0]
 	}
-	struct2Dicom = toDicom
-	recursiveFill(dicomDataset, v.Elem())
+	m.recursiveFill(dicomDataset, v.Elem())
 	return nil
 }
 
@@ -39,21 +41,21 @@ func MapToDicom(in any, obj *media.DcmObj, keyword ...string) (err error) {
 }
 
 // recursiveFill analyze recursively the target structure and find corresponding Dicom value in the dataset.
-func recursiveFill(dataset *media.DcmObj, targetStructure reflect.Value) {
+func (m *mapper) recursiveFill(dataset *media.DcmObj, targetStructure reflect.Value) {
 	if targetStructure.Kind() == reflect.Struct {
 		targetType := targetStructure.Type()
 		for i := 0; i < targetStructure.NumField(); i++ {
 			field := targetType.Field(i)
 			fieldName := field.Name
 			fieldType := field.Type
-			groupElem := field.Tag.Get(key)
+			groupElem := field.Tag.Get(m.key)
 			switch fieldType.Kind() {
 			case reflect.Struct:
-				recursiveFill(dataset, targetStructure.Field(i))
+				m.recursiveFill(dataset, targetStructure.Field(i))
 			case reflect.Slice:
-				goDeeper(fieldType, dataset, targetStructure, fieldName, groupElem)
+				m.goDeeper(fieldType, dataset, targetStructure, fieldName, groupElem)
 			default:
-				fillElement(fieldType, dataset, targetStructure, fieldName, groupElem)
+				m.fillElement(fieldType, dataset, targetStructure, fieldName, groupElem)
 			}
 
 		}
@@ -61,21 +63,21 @@ func recursiveFill(dataset *media.DcmObj, targetStructure reflect.Value) {
 }
 
 // goDeeper is called when a struct field is a Slice of another type, either a base type or a struct type
-func goDeeper(fieldType reflect.Type, dataset *media.DcmObj, targetStructure reflect.Value, fieldName, groupElem string) {
+func (m *mapper) goDeeper(fieldType reflect.Type, dataset *media.DcmObj, targetStructure reflect.Value, fieldName, groupElem string) {
 	sliceElement := fieldType.Elem()
 	if sliceElement.Kind() == reflect.Struct {
 		s := reflect.New(sliceElement)
-		recursiveFill(dataset, s.Elem())
+		m.recursiveFill(dataset, s.Elem())
 		f := targetStructure.FieldByName(fieldName)
 		f = reflect.MakeSlice(f.Type(), 1, 1)
 		f.Index(0).Set(s.Elem())
 		targetStructure.FieldByName(fieldName).Set(f)
 	} else {
-		fillElement(fieldType, dataset, targetStructure, fieldName, groupElem)
+		m.fillElement(fieldType, dataset, targetStructure, fieldName, groupElem)
 	}
 }
 
-func fillElement(fieldType reflect.Type, dataset *media.DcmObj, targetStructure reflect.Value, fieldName, groupElem string) {
+func (m *mapper) fillElement(fieldType reflect.Type, dataset *media.DcmObj, targetStructure reflect.Value, fieldName, groupElem string) {
 	var group, elem string
 	dicomFieldTags := strings.Split(groupElem, ",")
 	if len(dicomFieldTags) == 2 {
@@ -96,7 +98,7 @@ func fillElement(fieldType reflect.Type, dataset *media.DcmObj, targetStructure
 	tag := &tags.Tag{Group: uint16(groupHex), Element: uint16(elemHex)}
 	switch fieldType.Kind() {
 	case reflect.String:
-		if struct2Dicom {
+		if m.struct2Dicom {
 			if dataset.GetTag(tag) != nil {
 				dataset.WriteString(tag, targetStructure.FieldByName(fieldName).String())
 			}
@@ -104,7 +106,7 @@ func fillElement(fieldType reflect.Type, dataset *media.DcmObj, targetStructure
 			targetStructure.FieldByName(fieldName).SetString(dataset.GetString(tag))
 		}
 	case reflect.Uint8, reflect.Uint16:
-		if struct2Dicom {
+		if m.struct2Dicom {
 			if dataset.GetTag(tag) != nil {
 				dataset.WriteUint16(tag, uint16(targetStructure.FieldByName(fieldName).Uint()))
 			}